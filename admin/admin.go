@@ -0,0 +1,198 @@
+// Package admin serves the AdminService gRPC API (see adminpb/admin.proto)
+// for managing a golimiter.Limiter's white/blacklists and config at runtime,
+// with a stream of the limiter's events for dashboards
+package admin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/i-norden/golimiter"
+	"github.com/i-norden/golimiter/admin/adminpb"
+	c "github.com/i-norden/golimiter/common"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the golimiter.admin.v1.AdminService
+type Server struct {
+	adminpb.UnimplementedAdminServiceServer
+	Limiter *golimiter.Limiter // Limiter that is managed
+	// Events buffered for each watcher; watchers that fall further
+	// behind miss events rather than block the limiter (default 64)
+	Buffer   int
+	mu       sync.Mutex
+	watchers map[chan golimiter.Event]bool
+}
+
+// Creates a new Server managing the given (initialized) limiter
+// The server is hooked into the limiter's event hook, chaining any hook already set
+func NewServer(l *golimiter.Limiter) *Server {
+	s := &Server{Limiter: l, watchers: make(map[chan golimiter.Event]bool)}
+	next := l.EventHook()
+	l.SetOnEvent(func(e golimiter.Event) {
+		if next != nil {
+			next(e)
+		}
+		s.broadcast(e)
+	})
+	return s
+}
+
+// Registers the server with a grpc.Server
+func (s *Server) Register(gs *grpc.Server) {
+	adminpb.RegisterAdminServiceServer(gs, s)
+}
+
+// Adds an ip to a list
+func (s *Server) AddToList(ctx context.Context, req *adminpb.ListEntryRequest) (*adminpb.Empty, error) {
+	var err error
+	switch req.GetList() {
+	case adminpb.List_LIST_WHITELIST:
+		err = s.Limiter.AddToWhitelist(req.GetIp())
+	case adminpb.List_LIST_BLACKLIST:
+		err = s.Limiter.AddToBlacklist(req.GetIp())
+	default:
+		return nil, errUnknownList
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &adminpb.Empty{}, nil
+}
+
+// Removes an ip from a list
+func (s *Server) RemoveFromList(ctx context.Context, req *adminpb.ListEntryRequest) (*adminpb.Empty, error) {
+	switch req.GetList() {
+	case adminpb.List_LIST_WHITELIST:
+		s.Limiter.RemoveFromWhiteList(req.GetIp())
+	case adminpb.List_LIST_BLACKLIST:
+		s.Limiter.RemoveFromBlackList(req.GetIp())
+	default:
+		return nil, errUnknownList
+	}
+	return &adminpb.Empty{}, nil
+}
+
+// Returns the entries of a list
+func (s *Server) ListEntries(ctx context.Context, req *adminpb.ListEntriesRequest) (*adminpb.ListEntriesResponse, error) {
+	var entries []c.Entry
+	switch req.GetList() {
+	case adminpb.List_LIST_WHITELIST:
+		entries = s.Limiter.WhitelistEntries()
+	case adminpb.List_LIST_BLACKLIST:
+		entries = s.Limiter.BlacklistEntries()
+	default:
+		return nil, errUnknownList
+	}
+	resp := &adminpb.ListEntriesResponse{Entries: make([]*adminpb.Entry, len(entries))}
+	for i, e := range entries {
+		pe := &adminpb.Entry{Ip: e.IP, Reason: e.Reason, AddedBy: e.AddedBy, Level: int32(e.Level)}
+		if !e.Expires.IsZero() {
+			pe.ExpiresUnix = e.Expires.Unix()
+		}
+		resp.Entries[i] = pe
+	}
+	return resp, nil
+}
+
+// Sets the default rate and, unless it is zero, burst
+func (s *Server) SetRate(ctx context.Context, req *adminpb.SetRateRequest) (*adminpb.Empty, error) {
+	if err := s.Limiter.SetRate(rate.Limit(req.GetRate())); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetBurst() != 0 {
+		if err := s.Limiter.SetBurst(int(req.GetBurst())); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return &adminpb.Empty{}, nil
+}
+
+// Returns a snapshot of the limiter's internals
+func (s *Server) GetStats(ctx context.Context, req *adminpb.Empty) (*adminpb.Stats, error) {
+	st := s.Limiter.Stats()
+	return &adminpb.Stats{
+		Mode:          st.Mode,
+		State:         int32(st.State),
+		Visitors:      int64(st.Visitors),
+		Allowed:       st.Allowed,
+		Denied:        st.Denied,
+		ShadowDenials: st.ShadowDenials,
+		Whitelist:     int64(st.Whitelist),
+		Blacklist:     int64(st.Blacklist),
+		StoreCalls:    st.StoreCalls,
+		StoreErrors:   st.StoreErrors,
+	}, nil
+}
+
+// Blacklists an ip, for the requested duration if one is given
+// Fails with FailedPrecondition while the limiter's blacklist is off
+func (s *Server) Ban(ctx context.Context, req *adminpb.BanRequest) (*adminpb.Empty, error) {
+	if req.GetDurationSeconds() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ban duration must not be negative")
+	}
+	err := s.Limiter.Ban(req.GetIp(), time.Duration(req.GetDurationSeconds())*time.Second, req.GetReason())
+	if errors.Is(err, golimiter.ErrBlacklistOff) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &adminpb.Empty{}, nil
+}
+
+// Streams the limiter's events until the client goes away
+func (s *Server) Watch(req *adminpb.Empty, stream adminpb.AdminService_WatchServer) error {
+	size := s.Buffer
+	if size == 0 {
+		size = 64 // Use default buffer if none provided
+	}
+	ch := make(chan golimiter.Event, size)
+	s.mu.Lock()
+	s.watchers[ch] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-ch:
+			pe := &adminpb.Event{
+				TimeUnixNano: e.Time.UnixNano(),
+				Kind:         string(e.Kind),
+				Key:          e.Key,
+				Detail:       e.Detail,
+			}
+			if e.Err != nil {
+				pe.Error = e.Err.Error()
+			}
+			if err := stream.Send(pe); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Sends the event to every watcher that has room for it
+func (s *Server) broadcast(e golimiter.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+var errUnknownList = status.Error(codes.InvalidArgument, "list must be the whitelist or blacklist")
@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/i-norden/golimiter"
+	"github.com/i-norden/golimiter/admin/adminpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBanFailures(t *testing.T) {
+	l := &golimiter.Limiter{Rate: 1, Burst: 1}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	s := NewServer(l)
+	ban := func(ip string) codes.Code {
+		_, err := s.Ban(context.Background(), &adminpb.BanRequest{Ip: ip, DurationSeconds: 60})
+		return status.Code(err)
+	}
+	if code := ban("10.0.0.1"); code != codes.FailedPrecondition {
+		t.Fatalf("ban with the blacklist off: %v", code)
+	}
+	l.Blacklist.Filename = filepath.Join(t.TempDir(), "blacklist")
+	os.WriteFile(l.Blacklist.Filename, nil, 0644)
+	if err := l.SetBlacklist(true); err != nil {
+		t.Fatal(err)
+	}
+	if code := ban("not-an-ip"); code != codes.InvalidArgument {
+		t.Fatalf("ban of a key that isn't an ip: %v", code)
+	}
+	if code := ban("10.0.0.1"); code != codes.OK {
+		t.Fatalf("ban: %v", code)
+	}
+}
@@ -0,0 +1,186 @@
+package golimiter
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A denial (or sampled allowed event) recorded in the audit log
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key"`              // Visitor key (ip, identity or API key)
+	Path   string    `json:"path,omitempty"`   // Request path, for http
+	Rule   string    `json:"rule"`             // Rule that denied it (whitelist, blacklist, rate, state, quota, ...)
+	Reason Reason    `json:"reason,omitempty"` // Reason code of the rule, for denials
+	Status int       `json:"status,omitempty"` // Response status, for http
+	Shadow bool      `json:"shadow,omitempty"` // Whether the denial was let through in shadow mode
+	Allow  bool      `json:"allow,omitempty"`  // Whether the entry is a sampled allowed event rather than a denial
+	Origin           // Network origin of the key, if Geo.GeoIP is set and the key is an ip
+}
+
+// AuditLog is an append-only log of denials written as JSON lines,
+// for abuse forensics and compliance
+type AuditLog struct {
+	sync.Mutex
+	// Record 1 in AllowEvery allowed events, besides every denial (0- none)
+	AllowEvery uint64
+	// Entries recorded per key per minute; a key's further entries are dropped
+	// until the next minute, so one flooding key can't swamp the log (0- unlimited)
+	PerKey int
+	// Size in bytes after which a file log is rotated (0- never)
+	MaxBytes int64
+	// Called with the log's path when it is rotated, after the file is closed
+	// and before it is reopened (e.g. to rename or compress it)
+	// If nil, the file is renamed with a timestamp suffix
+	Rotate  func(path string) error
+	w       io.Writer
+	file    *os.File // Set if the log writes to a file
+	path    string
+	fn      func(e AuditEntry) error // Set if the log hands its entries to a func instead
+	written int64
+	allows  uint64         // Allowed events offered to the log, accessed atomically
+	keys    map[string]int // Entries recorded this minute, by key
+	minute  int64          // Minute the keys are counted in
+	dropped uint64         // Entries dropped by the PerKey limit, accessed atomically
+}
+
+// Creates an audit log writing to w
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// Creates an audit log handing its entries to f (e.g. to store them in a
+// database) instead of writing them as JSON lines; sampling still applies
+func NewAuditFunc(f func(e AuditEntry) error) *AuditLog {
+	return &AuditLog{fn: f}
+}
+
+// Opens (or creates) an audit log file, appending to it
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{path: path}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Opens the log file
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.w, a.written = f, f, info.Size()
+	return nil
+}
+
+// Closes and reopens the log file, e.g. after it was moved by an external
+// rotation tool; a no-op for logs not writing to a file
+func (a *AuditLog) Reopen() error {
+	a.Lock()
+	defer a.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	a.file.Close()
+	return a.open()
+}
+
+// Closes the log file
+func (a *AuditLog) Close() error {
+	a.Lock()
+	defer a.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// Appends an entry to the log
+func (a *AuditLog) Write(e AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if a.fn != nil {
+		return a.fn(e)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	a.Lock()
+	defer a.Unlock()
+	if a.file != nil && a.MaxBytes > 0 && a.written+int64(len(line)) > a.MaxBytes {
+		if err = a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.w.Write(line)
+	a.written += int64(n)
+	return err
+}
+
+// Rotates the log file
+// Must be called while holding the lock
+func (a *AuditLog) rotate() error {
+	a.file.Close()
+	rotate := a.Rotate
+	if rotate == nil {
+		rotate = func(path string) error {
+			return os.Rename(path, path+"."+time.Now().Format("20060102T150405"))
+		}
+	}
+	if err := rotate(a.path); err != nil {
+		a.open() // Keep appending to the current file
+		return err
+	}
+	return a.open()
+}
+
+// Reports whether the entry is sampled (allowed events) and within its key's PerKey limit
+func (a *AuditLog) admit(e AuditEntry) bool {
+	if e.Allow && (a.AllowEvery == 0 || atomic.AddUint64(&a.allows, 1)%a.AllowEvery != 0) {
+		return false
+	}
+	if a.PerKey <= 0 {
+		return true
+	}
+	minute := time.Now().Unix() / 60
+	a.Lock()
+	defer a.Unlock()
+	if a.keys == nil || minute != a.minute {
+		a.keys, a.minute = make(map[string]int), minute
+	}
+	if a.keys[e.Key] >= a.PerKey {
+		atomic.AddUint64(&a.dropped, 1)
+		return false
+	}
+	a.keys[e.Key]++
+	return true
+}
+
+// Returns the number of entries dropped by the PerKey limit
+func (a *AuditLog) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Records a denial, or samples an allowed event, if the limiter has an audit log
+func (l *Limiter) audit(e AuditEntry) {
+	if l.Audit != nil && l.Audit.admit(e) {
+		e.Origin = l.originOf(e.Key)
+		if !e.Allow {
+			e.Reason = RuleReason(e.Rule)
+		}
+		l.Audit.Write(e)
+	}
+}
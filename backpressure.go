@@ -0,0 +1,26 @@
+package golimiter
+
+import "net/http"
+
+// Reports whether the limiter is in a load state at or above Backpressure.From,
+// in which its responses signal overload
+func (l *Limiter) overloaded() bool {
+	if !l.Backpressure.On {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	return !l.useDefault && len(l.curve) == 0 && l.state >= l.Backpressure.From
+}
+
+// Sets the backpressure header on the response if the limiter is overloaded
+func (l *Limiter) signalOverload(w http.ResponseWriter) {
+	if !l.overloaded() {
+		return
+	}
+	header := l.Backpressure.Header
+	if header == "" {
+		header = "X-Backend-Overloaded" // Use default header if none provided
+	}
+	w.Header().Set(header, "1")
+}
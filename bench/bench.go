@@ -0,0 +1,190 @@
+// Package bench drives a golimiter.Limiter's http middleware with synthetic
+// workloads and measures its throughput, latency and allocations, so changes
+// to the request path's locking and allocation behavior can be compared
+// The golimiterload command runs it from the command line
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Creates the source of client addresses (host:port) for one worker
+type Workload func(r *rand.Rand) func() string
+
+// Clients picked uniformly from a pool of ips
+func Uniform(ips int) Workload {
+	pool := addrs(ips)
+	return func(r *rand.Rand) func() string {
+		return func() string {
+			return pool[r.Intn(len(pool))]
+		}
+	}
+}
+
+// Clients picked from a pool of ips by a Zipf distribution with exponent s (> 1),
+// so a few hot ips send most of the requests, as in real traffic
+func Zipfian(ips int, s float64) Workload {
+	pool := addrs(ips)
+	return func(r *rand.Rand) func() string {
+		z := rand.NewZipf(r, s, 1, uint64(len(pool)-1))
+		return func() string {
+			return pool[z.Uint64()]
+		}
+	}
+}
+
+// A flood of spoofed clients, each request from a new random ip
+func Spoofed() Workload {
+	return func(r *rand.Rand) func() string {
+		return func() string {
+			return addr(r.Uint32())
+		}
+	}
+}
+
+// Returns the addresses of n distinct ips
+func addrs(n int) []string {
+	if n < 1 {
+		n = 1
+	}
+	pool := make([]string, n)
+	for i := range pool {
+		pool[i] = addr(uint32(i) + 1<<24) // Start at 1.0.0.0
+	}
+	return pool
+}
+
+// Returns the address of the ipv4 given as a number
+func addr(n uint32) string {
+	ip := netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	return netip.AddrPortFrom(ip, 1234).String()
+}
+
+// Settings of a run
+type Config struct {
+	Workload Workload      // Source of the clients (default Uniform(10000))
+	Workers  int           // Concurrent clients (default GOMAXPROCS)
+	Duration time.Duration // Length of the run (default 10 seconds)
+	Seed     int64         // Seed of the workers' random sources
+}
+
+// Results of a run
+type Result struct {
+	Requests    uint64        // Requests sent
+	Allowed     uint64        // Requests the limiter let through
+	Elapsed     time.Duration // Length of the run
+	P50         time.Duration // Median latency of the middleware
+	P99         time.Duration // 99th percentile latency of the middleware
+	Max         time.Duration // Largest latency sampled
+	AllocsPerOp float64       // Heap allocations per request
+	BytesPerOp  float64       // Heap bytes allocated per request
+}
+
+// Requests per second
+func (r Result) Throughput() float64 {
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%d requests (%d allowed) in %v: %.0f req/s, p50 %v, p99 %v, max %v, %.1f allocs/op, %.0f B/op",
+		r.Requests, r.Allowed, r.Elapsed.Round(time.Millisecond), r.Throughput(),
+		r.P50, r.P99, r.Max, r.AllocsPerOp, r.BytesPerOp)
+}
+
+// Every this many requests a worker samples the latency
+const sampleEvery = 16
+
+// Sends requests through the limiter's middleware (which must be initialized)
+// from the workload's clients until the duration is over
+func Run(l *golimiter.Limiter, cfg Config) Result {
+	if cfg.Workload == nil {
+		cfg.Workload = Uniform(10000)
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = runtime.GOMAXPROCS(0)
+	}
+	if cfg.Duration == 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	var allowed, requests uint64
+	handler := l.LimitHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&allowed, 1)
+	}))
+	samples := make([][]time.Duration, cfg.Workers)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	deadline := start.Add(cfg.Duration)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			next := cfg.Workload(rand.New(rand.NewSource(cfg.Seed + int64(i))))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := &discard{header: make(http.Header)}
+			var n uint64
+			for ; ; n++ {
+				if n%sampleEvery == 0 && time.Now().After(deadline) {
+					break
+				}
+				req.RemoteAddr = next()
+				w.reset()
+				if n%sampleEvery != 0 {
+					handler.ServeHTTP(w, req)
+					continue
+				}
+				t := time.Now()
+				handler.ServeHTTP(w, req)
+				samples[i] = append(samples[i], time.Since(t))
+			}
+			atomic.AddUint64(&requests, n)
+		}(i)
+	}
+	wg.Wait()
+	res := Result{Requests: requests, Allowed: allowed, Elapsed: time.Since(start)}
+	runtime.ReadMemStats(&after)
+	if requests > 0 {
+		res.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(requests)
+		res.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(requests)
+	}
+	var all []time.Duration
+	for _, s := range samples {
+		all = append(all, s...)
+	}
+	if len(all) > 0 {
+		sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+		res.P50 = all[len(all)/2]
+		res.P99 = all[len(all)*99/100]
+		res.Max = all[len(all)-1]
+	}
+	return res
+}
+
+// ResponseWriter discarding the response, reused across a worker's requests
+type discard struct {
+	header http.Header
+}
+
+func (d *discard) Header() http.Header         { return d.header }
+func (d *discard) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discard) WriteHeader(int)             {}
+
+// Clears the headers of the previous response
+func (d *discard) reset() {
+	for k := range d.header {
+		delete(d.header, k)
+	}
+}
@@ -0,0 +1,72 @@
+package bench
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Compare runs with: go test -run '^$' -bench . -benchmem ./bench > new.txt
+// and benchstat old.txt new.txt
+
+// Sends the workload's requests through the middleware from parallel clients
+func benchmark(b *testing.B, workload Workload) {
+	l := &golimiter.Limiter{Rate: 10, Burst: 20}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		b.Fatal(err)
+	}
+	defer l.Stop()
+	handler := l.LimitHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	var seed int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		next := workload(rand.New(rand.NewSource(atomic.AddInt64(&seed, 1))))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := &discard{header: make(http.Header)}
+		for pb.Next() {
+			req.RemoteAddr = next()
+			w.reset()
+			handler.ServeHTTP(w, req)
+		}
+	})
+}
+
+func BenchmarkUniform(b *testing.B) {
+	benchmark(b, Uniform(10000))
+}
+
+func BenchmarkZipfian(b *testing.B) {
+	benchmark(b, Zipfian(10000, 1.1))
+}
+
+func BenchmarkSpoofed(b *testing.B) {
+	benchmark(b, Spoofed())
+}
+
+// One hot client, every request contending on the same visitor
+func BenchmarkSingleClient(b *testing.B) {
+	benchmark(b, Uniform(1))
+}
+
+func TestRun(t *testing.T) {
+	l := &golimiter.Limiter{Rate: 10, Burst: 20}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	res := Run(l, Config{Workload: Uniform(10), Workers: 2, Duration: 50 * time.Millisecond})
+	if res.Requests == 0 || res.Allowed == 0 || res.Allowed > res.Requests {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	if res.Allowed > 10*20+10 { // Each of the 10 clients' burst, plus some refill
+		t.Fatalf("limiter let through %d requests from 10 clients", res.Allowed)
+	}
+}
@@ -0,0 +1,93 @@
+package golimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// What the limiter does while the store's circuit breaker is open
+type StoreFallback int
+
+const (
+	// Limit with the local limiters only
+	FallbackLocal StoreFallback = iota
+	// Allow every event
+	FallbackAllow
+	// Deny every event
+	FallbackDeny
+)
+
+// Circuit breaker state for the store
+type breaker struct {
+	sync.Mutex
+	failures int       // Consecutive failed store calls
+	openedAt time.Time // When the breaker opened; zero while closed
+	probing  bool      // Whether a call is probing the store for recovery
+}
+
+// Reports whether a store call may be made
+// While open, a single call is let through once the cooldown has passed to probe for recovery
+func (l *Limiter) storeAvailable() bool {
+	b := &l.breaker
+	b.Lock()
+	defer b.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < l.Breaker.Cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Records the outcome of a store call, opening or closing the breaker
+func (l *Limiter) storeResult(err error) {
+	b := &l.breaker
+	b.Lock()
+	wasOpen := !b.openedAt.IsZero()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.openedAt = time.Time{}
+	} else {
+		b.failures++
+		if wasOpen || b.failures >= l.Breaker.Failures {
+			b.openedAt = time.Now() // A failed probe restarts the cooldown
+		}
+	}
+	isOpen := !b.openedAt.IsZero()
+	b.Unlock()
+	if isOpen == wasOpen {
+		return
+	}
+	if isOpen {
+		l.emit(Event{Kind: EventBreakerOpen, Err: err})
+	} else {
+		l.emit(Event{Kind: EventBreakerClose})
+	}
+	if l.Breaker.OnChange != nil {
+		l.Breaker.OnChange(isOpen, err)
+	}
+}
+
+// Returns the decision of the fallback policy, and false if the
+// local limiters should decide instead
+// Unless the fallback allows or denies, the OnInternalError policy applies
+func (l *Limiter) fallbackDecision() (Decision, bool) {
+	policy := l.OnInternalError
+	switch l.Breaker.Fallback {
+	case FallbackAllow:
+		policy = ErrorAllow
+	case FallbackDeny:
+		policy = ErrorDeny
+	}
+	return l.failDecision(policy, l.Breaker.Cooldown)
+}
+
+// Reports whether the store's circuit breaker is open
+func (l *Limiter) StoreBreakerOpen() bool {
+	l.breaker.Lock()
+	defer l.breaker.Unlock()
+	return !l.breaker.openedAt.IsZero()
+}
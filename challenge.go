@@ -0,0 +1,145 @@
+package golimiter
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Challenger issues challenges to visitors that exceed their limit and verifies
+// requests that have passed one, so that legitimate clients sharing an ip
+// (e.g. behind a NAT) can be limited individually instead of all being blocked
+type Challenger interface {
+	// Writes a challenge (e.g. a work token, captcha redirect or cookie) as the response
+	Challenge(w http.ResponseWriter, r *http.Request)
+	// Reports whether the request carries a passed challenge, and
+	// if so the key the request should be limited under instead of its ip
+	Verify(r *http.Request) (key string, ok bool)
+}
+
+// Returns the key a request is limited under and whether it has passed a challenge
+// Passed requests are keyed by their ip and the challenge's key, so passes
+// carried to other ips don't share a bucket
+// Passing doesn't clear the ip's challenges: each one mints a bucket, so an
+// ip gets at most MaxFailures of them per Window however many it solves
+func (l *Limiter) challengeKey(r *http.Request) (string, bool) {
+	client := l.clientKey(r)
+	if l.Challenge.Challenger == nil {
+		return client, false
+	}
+	key, ok := l.Challenge.Challenger.Verify(r)
+	if !ok {
+		return client, false
+	}
+	return RemoteIP(r.RemoteAddr) + "|" + key, true
+}
+
+// Challenges a denied visitor if they have not yet been issued too many
+// challenges in the current window
+// Returns whether a challenge was written
+func (l *Limiter) challenge(w http.ResponseWriter, r *http.Request, v *visitor) bool {
+	if l.Challenge.Challenger == nil {
+		return false
+	}
+	max := l.Challenge.MaxFailures
+	if max == 0 {
+		max = 3 // Use default max if none provided
+	}
+	window := l.Challenge.Window
+	if window == 0 {
+		window = time.Hour // Use default window if none provided
+	}
+	now := time.Now()
+	l.Lock()
+	if now.Sub(v.chalAt) >= window {
+		v.failures, v.chalAt = 0, now
+	}
+	if v.failures >= max {
+		l.Unlock()
+		return false
+	}
+	v.failures++
+	l.Unlock()
+	l.Challenge.Challenger.Challenge(w, r)
+	return true
+}
+
+// CookieChallenger is a Challenger that sets a signed cookie and redirects
+// the client back to the requested url; clients that keep cookies pass and are
+// limited per cookie, clients that don't (most bots) keep failing and are blocked
+// Cookies are signed for the ip they were issued to and are not valid from others
+type CookieChallenger struct {
+	Secret []byte        // HMAC key used to sign the cookies
+	Name   string        // Cookie name (default "golimiter")
+	TTL    time.Duration // How long a cookie remains valid (default 1 hour)
+}
+
+func (cc *CookieChallenger) name() string {
+	if cc.Name == "" {
+		return "golimiter"
+	}
+	return cc.Name
+}
+
+// Sets a new signed cookie and redirects to the same url
+func (cc *CookieChallenger) Challenge(w http.ResponseWriter, r *http.Request) {
+	ttl := cc.TTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
+		return
+	}
+	expires := time.Now().Add(ttl)
+	payload := hex.EncodeToString(id) + "." + strconv.FormatInt(expires.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cc.name(),
+		Value:    payload + "." + cc.sign(payload, RemoteIP(r.RemoteAddr)),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
+
+// Checks the cookie's signature for the request's ip and its expiry, and
+// returns its id as the key
+func (cc *CookieChallenger) Verify(r *http.Request) (string, bool) {
+	ck, err := r.Cookie(cc.name())
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndex(ck.Value, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := ck.Value[:i], ck.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(cc.sign(payload, RemoteIP(r.RemoteAddr)))) {
+		return "", false
+	}
+	parts := strings.SplitN(payload, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+	return "cookie:" + parts[0], true
+}
+
+// Signs the cookie's payload for the ip
+func (cc *CookieChallenger) sign(payload, ip string) string {
+	mac := hmac.New(sha256.New, cc.Secret)
+	mac.Write([]byte(payload + "|" + ip))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
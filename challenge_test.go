@@ -0,0 +1,96 @@
+package golimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Sends a request from the address with the cookies, returning the response
+func requestWith(h http.Handler, addr string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = addr
+	for _, ck := range cookies {
+		r.AddCookie(ck)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func newChallengeLimiter(t *testing.T, window time.Duration) (*Limiter, http.Handler) {
+	t.Helper()
+	l := &Limiter{Rate: 0.001, Burst: 1}
+	l.Cleanup.Off = true
+	l.Challenge.Challenger = &CookieChallenger{Secret: []byte("secret")}
+	l.Challenge.MaxFailures = 2
+	l.Challenge.Window = window
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return l, l.LimitHTTPFunc(func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func TestChallengePassLimitedPerCookie(t *testing.T) {
+	_, h := newChallengeLimiter(t, time.Hour)
+	if w := requestWith(h, "10.0.0.1:1"); w.Code != http.StatusOK {
+		t.Fatal("first request denied")
+	}
+	w := requestWith(h, "10.0.0.1:1")
+	if w.Code != http.StatusTemporaryRedirect || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("over the limit: got %d, want a challenge", w.Code)
+	}
+	ck := w.Result().Cookies()[0]
+	if w := requestWith(h, "10.0.0.1:2", ck); w.Code != http.StatusOK {
+		t.Fatal("solved challenge denied")
+	}
+	// The cookie's bucket is spent, and it is not valid from another ip
+	if w := requestWith(h, "10.0.0.1:2", ck); w.Code == http.StatusOK {
+		t.Fatal("cookie bucket not limited")
+	}
+	if w := requestWith(h, "10.0.0.2:1", ck); w.Code != http.StatusOK {
+		t.Fatal("other ip's own bucket denied")
+	}
+	if w := requestWith(h, "10.0.0.2:1", ck); w.Code != http.StatusTemporaryRedirect {
+		t.Fatal("cookie carried to another ip was accepted")
+	}
+}
+
+func TestChallengeEscalatesToBlock(t *testing.T) {
+	_, h := newChallengeLimiter(t, time.Hour)
+	requestWith(h, "10.0.0.1:1")
+	// Solving every challenge and dropping the cookie doesn't reset the count
+	for i := 0; i < 2; i++ {
+		w := requestWith(h, "10.0.0.1:1")
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("challenge %d: got %d", i, w.Code)
+		}
+		requestWith(h, "10.0.0.1:1", w.Result().Cookies()[0])
+	}
+	if w := requestWith(h, "10.0.0.1:1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("ip past its challenges: got %d, want a block", w.Code)
+	}
+}
+
+func TestChallengeWindowResets(t *testing.T) {
+	_, h := newChallengeLimiter(t, 50*time.Millisecond)
+	requestWith(h, "10.0.0.1:1")
+	requestWith(h, "10.0.0.1:1")
+	requestWith(h, "10.0.0.1:1")
+	if w := requestWith(h, "10.0.0.1:1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want a block", w.Code)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if w := requestWith(h, "10.0.0.1:1"); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("got %d, want a challenge in the new window", w.Code)
+	}
+}
+
+func TestCookieChallengerNeedsSecret(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Challenge.Challenger = &CookieChallenger{}
+	if err := l.Validate(); err == nil {
+		t.Fatal("empty cookie secret accepted")
+	}
+}
@@ -0,0 +1,53 @@
+package golimiter
+
+import (
+	"net/http"
+)
+
+// Evaluates the request like LimitHTTPHandler (mode, lists, state and buckets)
+// without writing a response, for frameworks that write their own
+// Denied decisions carry the rule that denied the request and the status the
+// middleware would have responded with; all decisions carry the headers it
+// would have set (e.g. Retry-After, or a challenge's cookie)
+// Allowed requests are charged; denials are audited like the middleware's,
+// but not tarpitted
+func (l *Limiter) Check(r *http.Request) Decision {
+	cw := &checkWriter{header: make(http.Header)}
+	d, passed := Decision{}, false
+	l.LimitHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ = FromContext(r.Context())
+		passed = true
+	})).ServeHTTP(cw, r)
+	d.Allowed = passed
+	if !passed {
+		d.Rule, d.Status = cw.rule, cw.status
+		if d.Rule == "" { // Only challenges write a response without denying
+			d.Rule = "challenge"
+		}
+		d.Reason = RuleReason(d.Rule)
+	}
+	d.Header = cw.header
+	return d
+}
+
+// ResponseWriter recording what the middleware would have responded, for Check
+type checkWriter struct {
+	header http.Header
+	status int    // Status written, 0 if none
+	rule   string // Rule that denied the request, set by deny
+}
+
+func (cw *checkWriter) Header() http.Header         { return cw.header }
+func (cw *checkWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func (cw *checkWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+// Returns whether the response is only recorded for Check
+func checking(w http.ResponseWriter) bool {
+	_, ok := w.(*checkWriter)
+	return ok
+}
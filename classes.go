@@ -0,0 +1,77 @@
+package golimiter
+
+import (
+	"net/http"
+)
+
+// Endpoint classes, shed in this order as the load state escalates
+const (
+	ClassExpensive = "expensive" // Throttled from the first state (default)
+	ClassNormal    = "normal"    // Throttled from the second state (default); requests matching no route are normal
+	ClassCritical  = "critical"  // Throttled only in the last state (default), e.g. health checks and payment callbacks
+)
+
+// Annotates the endpoints matching a pattern with their class
+type RouteClass struct {
+	Match string // Pattern of the endpoints, as Dimension.Match
+	Class string // ClassExpensive, ClassNormal or ClassCritical
+}
+
+// A parsed RouteClass
+type classRoute struct {
+	route route
+	class string
+}
+
+// Returns the class of the first route the request matches, ClassNormal if none
+func (l *Limiter) classOf(r *http.Request) string {
+	for _, cr := range l.classes {
+		if cr.route.matches(r) {
+			return cr.class
+		}
+	}
+	return ClassNormal
+}
+
+// Returns the index of the first state the class is throttled in, of n states
+func (l *Limiter) classFrom(class string, n int) int {
+	from, ok := l.Classes.From[class]
+	if !ok {
+		switch class { // Use default order if none provided
+		case ClassExpensive:
+			from = 0
+		case ClassNormal:
+			from = 1
+		default:
+			from = n - 1
+		}
+	}
+	if from > n-1 {
+		from = n - 1 // Every class is throttled in the last state
+	}
+	return from
+}
+
+// Checks whether the active load state does not yet apply to the request's
+// endpoint class, so the request is limited by the default rate instead
+func (l *Limiter) sheltered(r *http.Request) bool {
+	if len(l.classes) == 0 {
+		return false
+	}
+	class := l.classOf(r)
+	l.Lock()
+	defer l.Unlock()
+	if l.useDefault || len(l.curve) > 0 {
+		return false
+	}
+	return l.state < l.classFrom(class, len(l.params))
+}
+
+// Checks that a class is one of the known classes
+func validClass(class string) bool {
+	switch class {
+	case ClassExpensive, ClassNormal, ClassCritical:
+		return true
+	}
+	return false
+}
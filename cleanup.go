@@ -0,0 +1,131 @@
+package golimiter
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"runtime"
+	"time"
+)
+
+// Visitor keys ordered by the time their visitors expire, unless seen again
+// before then; visitors seen again are rescheduled once their time comes,
+// so each sweep only costs as much as the visitors that are due
+// Overrides and failure records are kept in heaps of their own alike
+type expiryHeap []expiry
+
+// A key and the time it is due to be checked
+type expiry struct {
+	key string
+	at  time.Time
+}
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = expiry{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Removes the visitors that are due and expired
+func (l *Limiter) sweepVisitors(ctx context.Context) {
+	l.sweep(ctx, &l.expiries, func(key string) (time.Time, bool) {
+		v, ok := l.visitors[key]
+		if !ok {
+			return time.Time{}, false
+		}
+		return v.lastSeen.Add(l.visitorTTL(v)), true
+	}, func(key string) {
+		l.releaseHint(l.visitors[key])
+		delete(l.warned, key)
+		delete(l.visitors, key)
+	})
+}
+
+// Removes the overrides that have expired
+func (l *Limiter) sweepOverrides(ctx context.Context) {
+	l.sweep(ctx, &l.overrideAt, func(key string) (time.Time, bool) {
+		o, ok := l.overrides[key]
+		return o.until, ok
+	}, func(key string) {
+		delete(l.overrides, key)
+	})
+}
+
+// Removes the failure records of keys that haven't failed for the failure memory
+func (l *Limiter) sweepFailures(ctx context.Context) {
+	l.sweep(ctx, &l.failureAt, func(key string) (time.Time, bool) {
+		f, ok := l.failures[key]
+		if !ok {
+			return time.Time{}, false
+		}
+		return f.last.Add(l.failureMemory()), true
+	}, func(key string) {
+		delete(l.failures, key)
+	})
+}
+
+// Removes the idempotency keys of visitors whose keys are all outside the window
+func (l *Limiter) sweepRetries(ctx context.Context) {
+	l.sweep(ctx, &l.retryAt, func(key string) (time.Time, bool) {
+		recent, ok := l.retries[key]
+		return l.retriesDue(recent), ok
+	}, func(key string) {
+		delete(l.retries, key)
+	})
+}
+
+// Removes the keys in the heap that are due and expired, checking at most
+// Cleanup.Batch of them per hold of the lock and yielding in between, so
+// mass expiries don't stall requests
+// due returns when the key expires, or false if it is already gone; keys
+// whose time has moved on since they were scheduled are rescheduled
+// due and remove are called while holding the lock
+func (l *Limiter) sweep(ctx context.Context, h *expiryHeap, due func(key string) (time.Time, bool), remove func(key string)) {
+	batch := l.Cleanup.Batch
+	if batch == 0 {
+		batch = 1000 // Use default batch if none provided
+	}
+	for {
+		l.Lock()
+		now := time.Now()
+		for n := 0; n < batch && h.Len() > 0 && !(*h)[0].at.After(now); n++ {
+			e := heap.Pop(h).(expiry)
+			at, ok := due(e.key)
+			if !ok {
+				continue
+			}
+			if at.After(now) {
+				heap.Push(h, expiry{key: e.key, at: at})
+				continue
+			}
+			remove(e.key)
+		}
+		done := h.Len() == 0 || (*h)[0].at.After(now)
+		if done && cap(*h) > 1024 && h.Len() < cap(*h)/4 {
+			*h = append(expiryHeap(nil), *h...) // Release the memory of mass expiries
+		}
+		l.Unlock()
+		if done || ctx.Err() != nil {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+// Schedules a new visitor to be checked for expiry
+// Must be called while holding the lock
+func (l *Limiter) track(v *visitor) {
+	heap.Push(&l.expiries, expiry{key: v.key, at: v.lastSeen.Add(l.visitorTTL(v))})
+}
+
+// Returns the period plus a random jitter of up to a tenth of it, so
+// limiters started together don't sweep at the same time
+func jitter(period time.Duration) time.Duration {
+	return period + time.Duration(rand.Int63n(int64(period)/10+1))
+}
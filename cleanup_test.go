@@ -0,0 +1,73 @@
+package golimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSweepOverrides(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l.SetVisitorLimit("short", 1, 1, 10*time.Millisecond)
+	l.SetVisitorLimit("extended", 1, 1, 10*time.Millisecond)
+	l.SetVisitorLimit("extended", 1, 1, time.Hour) // Rescheduled when its first time comes
+	l.SetVisitorLimit("long", 1, 1, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	l.sweepOverrides(context.Background())
+	if _, ok := l.overrides["short"]; ok {
+		t.Fatal("expired override kept")
+	}
+	if len(l.overrides) != 2 {
+		t.Fatalf("%d overrides left, want 2", len(l.overrides))
+	}
+	if len(l.overrideAt) != 2 || l.overrideAt[0].at.Before(time.Now().Add(time.Minute)) {
+		t.Fatalf("overrides scheduled at %v", l.overrideAt)
+	}
+}
+
+func TestSweepFailures(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Cleanup.Off = true
+	l.Failures.Threshold = 100
+	l.Failures.Forget = 100 * time.Millisecond
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l.ReportFailure("idle", 1)
+	l.ReportFailure("active", 1)
+	time.Sleep(60 * time.Millisecond)
+	l.ReportFailure("active", 1) // Failed again, so remembered for longer
+	time.Sleep(60 * time.Millisecond)
+	l.sweepFailures(context.Background())
+	if _, ok := l.failures["idle"]; ok {
+		t.Fatal("forgotten failures kept")
+	}
+	if _, ok := l.failures["active"]; !ok {
+		t.Fatal("recent failures removed")
+	}
+}
+
+func TestSweepRetries(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Cleanup.Off = true
+	l.Idempotency.Window = 100 * time.Millisecond
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l.remember("idle", "a")
+	l.remember("active", "a")
+	time.Sleep(60 * time.Millisecond)
+	l.remember("active", "b") // Charged again, so kept for longer
+	time.Sleep(60 * time.Millisecond)
+	l.sweepRetries(context.Background())
+	if _, ok := l.retries["idle"]; ok {
+		t.Fatal("idempotency keys past the window kept")
+	}
+	if _, ok := l.retries["active"]; !ok {
+		t.Fatal("idempotency keys in the window removed")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/i-norden/golimiter"
+	"github.com/i-norden/golimiter/dashboard"
+)
+
+// Request counters exposed on the admin listener
+type metrics struct {
+	allowed     uint64   // Requests passed to the upstream
+	limited     uint64   // Requests rejected with 429
+	listDenied  uint64   // Requests rejected by the white/blacklist
+	unavailable uint64   // Other limiter rejections
+	reasons     sync.Map // Rejections by reason code (golimiter.Reason to *uint64)
+}
+
+// Wraps the upstream handler with the limiter and counts the outcomes
+func (m *metrics) record(lim *golimiter.Limiter, upstream http.Handler) http.Handler {
+	limited := lim.LimitHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The limiter may have wrapped the recorder, so it's found through the context
+		if rec, ok := r.Context().Value(recorderKey{}).(*statusRecorder); ok {
+			rec.passed = true
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		limited.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), recorderKey{}, rec)))
+		if reason := w.Header().Get("X-RateLimit-Reason"); reason != "" && !rec.passed {
+			n, _ := m.reasons.LoadOrStore(golimiter.Reason(reason), new(uint64))
+			atomic.AddUint64(n.(*uint64), 1)
+		}
+		switch {
+		case rec.passed:
+			atomic.AddUint64(&m.allowed, 1)
+		case rec.status == http.StatusTooManyRequests:
+			atomic.AddUint64(&m.limited, 1)
+		case rec.status == http.StatusUnauthorized || rec.status == http.StatusForbidden:
+			atomic.AddUint64(&m.listDenied, 1)
+		default:
+			atomic.AddUint64(&m.unavailable, 1)
+		}
+	})
+}
+
+// Writes the counters in the Prometheus text exposition format
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# TYPE golimiterd_requests_total counter\n")
+	fmt.Fprintf(w, "golimiterd_requests_total{result=\"allowed\"} %d\n", atomic.LoadUint64(&m.allowed))
+	fmt.Fprintf(w, "golimiterd_requests_total{result=\"limited\"} %d\n", atomic.LoadUint64(&m.limited))
+	fmt.Fprintf(w, "golimiterd_requests_total{result=\"list_denied\"} %d\n", atomic.LoadUint64(&m.listDenied))
+	fmt.Fprintf(w, "golimiterd_requests_total{result=\"other_denied\"} %d\n", atomic.LoadUint64(&m.unavailable))
+	fmt.Fprintf(w, "# TYPE golimiterd_denials_total counter\n")
+	m.reasons.Range(func(reason, n interface{}) bool {
+		fmt.Fprintf(w, "golimiterd_denials_total{reason=%q} %d\n", reason, atomic.LoadUint64(n.(*uint64)))
+		return true
+	})
+}
+
+// Context key of the request's statusRecorder
+type recorderKey struct{}
+
+// Records the status written by the limiter, or whether
+// the request was let through to the upstream
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	passed bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Lets the limiter hijack the connection to drop denied requests
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Admin API routes
+//
+//	POST/DELETE /whitelist?ip=...  add or remove an ip from the whitelist
+//	POST/DELETE /blacklist?ip=...  add or remove an ip from the blacklist
+//	GET/POST /mode?mode=...        show or switch the limiter mode (enforce, shadow, deny-all, allow-all)
+//	GET /history?key=...          a visitor's requests per minute (needs History.Minutes)
+//	GET /metrics                   request counters
+//	GET /healthz                   health report, 503 if unhealthy
+//	GET /dashboard/                live dashboard of the limiter
+func adminHandler(lim *golimiter.Limiter, m *metrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	mux.Handle("/healthz", lim.HealthHandler())
+	mux.Handle("/dashboard/", http.StripPrefix("/dashboard", dashboard.New(lim)))
+	mux.HandleFunc("/mode", modeHandler(lim))
+	mux.HandleFunc("/history", historyHandler(lim))
+	mux.HandleFunc("/whitelist", listHandler(lim.AddToWhitelist, lim.RemoveFromWhiteList))
+	mux.HandleFunc("/blacklist", listHandler(lim.AddToBlacklist, lim.RemoveFromBlackList))
+	return mux
+}
+
+// Handler for mutating a white/blacklist
+func listHandler(add func(string) error, remove func(string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			http.Error(w, "ip parameter is required", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			if err := add(ip); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			remove(ip)
+		default:
+			http.Error(w, http.StatusText(405), http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Handler for showing and switching the limiter mode
+func modeHandler(lim *golimiter.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			m, err := golimiter.ParseMode(r.URL.Query().Get("mode"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			lim.SetMode(m)
+		default:
+			http.Error(w, http.StatusText(405), http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintln(w, lim.Mode())
+	}
+}
+
+// Handler for showing a visitor's requests per minute
+func historyHandler(lim *golimiter.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(405), http.StatusMethodNotAllowed)
+			return
+		}
+		counts, ok := lim.VisitorHistory(r.URL.Query().Get("key"))
+		if !ok {
+			http.Error(w, "no history for key", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(counts)
+	}
+}
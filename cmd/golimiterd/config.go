@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"github.com/i-norden/golimiter"
+	"github.com/i-norden/golimiter/kube"
+	"github.com/i-norden/golimiter/kvconfig"
+	"github.com/i-norden/golimiter/store/memcached"
+	"github.com/i-norden/golimiter/store/redis"
+	"golang.org/x/time/rate"
+)
+
+// Daemon configuration, read from a JSON file
+type config struct {
+	Listen      string  `json:"listen"`               // Address the proxy listens on
+	Upstream    string  `json:"upstream"`             // URL requests are proxied to once allowed
+	AdminListen string  `json:"admin_listen"`         // Address for the admin API and metrics (off if empty)
+	Rate        float64 `json:"rate"`                 // Default limiter rate
+	Burst       int     `json:"burst"`                // Default limiter burst/bucket size
+	Degraded    int     `json:"degraded_status"`      // Status for requests denied only because of the load state (e.g. 503)
+	Listed      int     `json:"listed_status"`        // Status for requests denied by the white/blacklist (default 403)
+	MaxStreams  int     `json:"max_streams_per_conn"` // Concurrent requests allowed per connection (0- off)
+	Rollout     float64 `json:"rollout_percent"`      // Percentage of keys whose denials are enforced, the rest shadowed (0- off)
+	Forward     string  `json:"forward_secret"`       // Secret signing the decision headers passed to the upstream (off if empty)
+	ReusePort   bool    `json:"reuse_port"`           // Bind with SO_REUSEPORT so several processes can share the listen address
+	Processes   int     `json:"processes"`            // Processes sharing the address without a store; each enforces 1/Processes of the limits
+	Whitelist   list    `json:"whitelist"`
+	Blacklist   list    `json:"blacklist"`
+	States      []struct {
+		Threshold int     `json:"threshold"` // Load, in requests per second, above which the state is entered
+		Rate      float64 `json:"rate"`
+		Burst     int     `json:"burst"`
+	} `json:"states"`
+	Schedule struct {
+		Timezone string `json:"timezone"` // IANA time zone the windows are evaluated in (default local)
+		Windows  []struct {
+			Name  string  `json:"name"`
+			Cron  string  `json:"cron"` // e.g. "* 9-16 * * 1-5"
+			Rate  float64 `json:"rate"`
+			Burst int     `json:"burst"`
+		} `json:"windows"`
+	} `json:"schedule"`
+	Cleanup struct {
+		Off   bool `json:"off"`
+		Thres int  `json:"thres"` // In minutes
+		Freq  int  `json:"freq"`  // In minutes
+	} `json:"cleanup"`
+	History struct {
+		Minutes    int     `json:"minutes"`     // Minutes of requests kept per visitor, served on /history (0- off)
+		SpikeRatio float64 `json:"spike_ratio"` // Ban visitors whose minute reaches this multiple of their mean (0- off; needs the blacklist)
+		SpikeMin   int     `json:"spike_min"`
+		SpikeBan   int     `json:"spike_ban"` // In minutes (0- until removed)
+	} `json:"history"`
+	Store struct { // Store shared by the processes (and instances) enforcing one limit
+		Memcached []string `json:"memcached"` // Memcached servers (off if empty)
+		Redis     struct {
+			Addrs      []string `json:"addrs"`       // Server, cluster seed nodes or sentinels (off if empty)
+			Cluster    bool     `json:"cluster"`     // Whether addrs are Redis Cluster nodes
+			MasterName string   `json:"master_name"` // Master monitored by the sentinels at addrs
+			Password   string   `json:"password"`
+			DB         int      `json:"db"`
+		} `json:"redis"`
+	} `json:"store"`
+	Replicas struct { // Discovery of the instances the limits are divided among, without a store
+		DNS        string `json:"dns"`         // Headless Service name whose addresses are counted
+		Service    string `json:"service"`     // Kubernetes Service whose ready endpoints are counted
+		Namespace  string `json:"namespace"`   // Namespace of the Service (default the pod's)
+		UpdateFreq int    `json:"update_freq"` // In minutes
+	} `json:"replicas"`
+	KV struct { // etcd or Consul KV the rate, burst, mode, plans and lists are watched in
+		Consul string `json:"consul"` // Consul agent address (off if empty)
+		Etcd   string `json:"etcd"`   // etcd endpoint, used if consul is empty (off if empty)
+		Prefix string `json:"prefix"` // Key prefix of the config, e.g. "golimiter/api"
+		Token  string `json:"token"`
+	} `json:"kv"`
+}
+
+// White/blacklist settings
+type list struct {
+	On         bool   `json:"on"`
+	Filename   string `json:"filename"`
+	Format     string `json:"format"`      // "lines", "json" or "yaml" (default by file extension)
+	UpdateFreq int    `json:"update_freq"` // In minutes
+	Persist    bool   `json:"persist"`     // Write changes made through the admin api back to the file
+}
+
+// Reads the config file at the given location
+func readConfig(loc string) (cfg config, err error) {
+	raw, err := ioutil.ReadFile(loc)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &cfg); err != nil {
+		return
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8080"
+	}
+	if cfg.Upstream == "" {
+		err = errors.New("upstream is not set")
+	}
+	return
+}
+
+// Builds an uninitialized limiter from the config
+func (cfg config) limiter() (*golimiter.Limiter, error) {
+	l := &golimiter.Limiter{}
+	l.Rate = rate.Limit(cfg.Rate)
+	l.Burst = cfg.Burst
+	l.Responses.DegradedStatus = cfg.Degraded
+	l.Responses.ListedStatus = cfg.Listed
+	l.Streams.MaxPerConn = cfg.MaxStreams
+	if cfg.Rollout > 0 {
+		l.Rollout = golimiter.RolloutPercent(cfg.Rollout)
+	}
+	l.Whitelist.On = cfg.Whitelist.On
+	l.Whitelist.Filename = cfg.Whitelist.Filename
+	l.Whitelist.Format = cfg.Whitelist.Format
+	l.Whitelist.UpdateFreq = time.Duration(cfg.Whitelist.UpdateFreq)
+	l.Whitelist.Persist = cfg.Whitelist.Persist
+	l.Blacklist.On = cfg.Blacklist.On
+	l.Blacklist.Filename = cfg.Blacklist.Filename
+	l.Blacklist.Format = cfg.Blacklist.Format
+	l.Blacklist.UpdateFreq = time.Duration(cfg.Blacklist.UpdateFreq)
+	l.Blacklist.Persist = cfg.Blacklist.Persist
+	l.Cleanup.Off = cfg.Cleanup.Off
+	l.Cleanup.Thres = time.Duration(cfg.Cleanup.Thres)
+	l.Cleanup.Freq = time.Duration(cfg.Cleanup.Freq)
+	l.History.Minutes = cfg.History.Minutes
+	l.History.SpikeRatio = cfg.History.SpikeRatio
+	l.History.SpikeMin = cfg.History.SpikeMin
+	l.History.SpikeBan = time.Duration(cfg.History.SpikeBan) * time.Minute
+	switch {
+	case len(cfg.Store.Memcached) > 0:
+		l.Store = memcached.New(cfg.Store.Memcached...)
+	case len(cfg.Store.Redis.Addrs) > 0:
+		st, err := redis.New(redis.Options{
+			Addrs:      cfg.Store.Redis.Addrs,
+			Cluster:    cfg.Store.Redis.Cluster,
+			MasterName: cfg.Store.Redis.MasterName,
+			Password:   cfg.Store.Redis.Password,
+			DB:         cfg.Store.Redis.DB,
+		})
+		if err != nil {
+			return nil, err
+		}
+		l.Store = st
+	default:
+		// Without a store, the processes (of every instance) split the limits
+		if cfg.Processes > 1 {
+			l.Replicas.Count = cfg.Processes
+		}
+		var discover func() (int, error)
+		switch {
+		case cfg.Replicas.Service != "":
+			discover = kube.Endpoints(cfg.Replicas.Namespace, cfg.Replicas.Service)
+		case cfg.Replicas.DNS != "":
+			discover = kube.DNS(cfg.Replicas.DNS)
+		}
+		if discover != nil {
+			processes := max(cfg.Processes, 1)
+			l.Replicas.Discover = func() (int, error) {
+				n, err := discover()
+				return n * processes, err
+			}
+			l.Replicas.UpdateFreq = time.Duration(cfg.Replicas.UpdateFreq)
+		}
+	}
+	if cfg.Schedule.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Schedule.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		l.Schedule.Location = loc
+	}
+	for _, w := range cfg.Schedule.Windows {
+		l.Schedule.Windows = append(l.Schedule.Windows, golimiter.Window{
+			Name:  w.Name,
+			Cron:  w.Cron,
+			Rate:  rate.Limit(w.Rate),
+			Burst: w.Burst,
+		})
+	}
+	states := make([]golimiter.State, len(cfg.States))
+	for i, st := range cfg.States {
+		states[i] = golimiter.State{Threshold: st.Threshold, Rate: rate.Limit(st.Rate), Burst: st.Burst}
+	}
+	return l, l.SetStates(states)
+}
+
+// Returns a watcher of the config kept in etcd or Consul, nil if none is set
+func (cfg config) watcher(l *golimiter.Limiter) *kvconfig.Watcher {
+	switch {
+	case cfg.KV.Consul != "":
+		return &kvconfig.Watcher{Limiter: l, Source: &kvconfig.Consul{Addr: cfg.KV.Consul, Prefix: cfg.KV.Prefix, Token: cfg.KV.Token}}
+	case cfg.KV.Etcd != "":
+		return &kvconfig.Watcher{Limiter: l, Source: &kvconfig.Etcd{Endpoint: cfg.KV.Etcd, Prefix: cfg.KV.Prefix, Token: cfg.KV.Token}}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// First file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// Returns the sockets passed by systemd socket activation, by their
+// FileDescriptorName (unnamed ones by position: "proxy", then "admin")
+// Returns nil if the process was not socket activated
+func systemdListeners() (map[string]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// The variables are only meant for this process, not its children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	listeners := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if name == "" || name == "unknown" {
+			name = []string{"proxy", "admin"}[i%2]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close() // The listener holds a duplicate of the descriptor
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %q: %v", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// Returns the listener for the address: the socket systemd passed under
+// the name if there is one, otherwise a new one, bound with SO_REUSEPORT
+// if reusePort is set so several processes can share the address
+func listen(activated map[string]net.Listener, name, addr string, reusePort bool) (net.Listener, error) {
+	if ln, ok := activated[name]; ok {
+		return ln, nil
+	}
+	lc := net.ListenConfig{}
+	if reusePort {
+		lc.Control = setReusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,78 @@
+// Command golimiterd runs a golimiter.Limiter as a standalone reverse proxy,
+// or as a sidecar when the upstream is a local port, so services not
+// written in Go can use the limiter
+//
+// Usage:
+//
+//	golimiterd -config /etc/golimiterd.json
+//
+// The proxy and admin sockets can be passed by systemd socket activation,
+// named "proxy" and "admin" with FileDescriptorName= (or in that order);
+// with "reuse_port" set, several processes can listen on the same address
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/i-norden/golimiter/forward"
+)
+
+func main() {
+	loc := flag.String("config", "golimiterd.json", "location of the JSON config file")
+	flag.Parse()
+
+	cfg, err := readConfig(*loc)
+	if err != nil {
+		log.Fatalf("golimiterd: reading config: %v", err)
+	}
+	upstream, err := url.Parse(cfg.Upstream)
+	if err != nil {
+		log.Fatalf("golimiterd: parsing upstream: %v", err)
+	}
+	lim, err := cfg.limiter()
+	if err != nil {
+		log.Fatalf("golimiterd: configuring states: %v", err)
+	}
+	if err = lim.Init(); err != nil {
+		log.Fatalf("golimiterd: initializing limiter: %v", err)
+	}
+	if w := cfg.watcher(lim); w != nil {
+		if err = w.Start(); err != nil { // The file config holds until the store answers
+			log.Printf("golimiterd: reading kv config: %v", err)
+		}
+	}
+
+	activated, err := systemdListeners()
+	if err != nil {
+		log.Fatalf("golimiterd: %v", err)
+	}
+	m := &metrics{}
+	if _, ok := activated["admin"]; ok || cfg.AdminListen != "" {
+		ln, err := listen(activated, "admin", cfg.AdminListen, cfg.ReusePort)
+		if err != nil {
+			log.Fatalf("golimiterd: admin listener: %v", err)
+		}
+		go func() {
+			log.Fatal(http.Serve(ln, adminHandler(lim, m)))
+		}()
+	}
+
+	var proxy http.Handler = httputil.NewSingleHostReverseProxy(upstream)
+	if cfg.Forward != "" { // Pass the decisions on to the upstream in signed headers
+		proxy = (&forward.Signer{Secret: []byte(cfg.Forward)}).Handler(proxy)
+	}
+	ln, err := listen(activated, "proxy", cfg.Listen, cfg.ReusePort)
+	if err != nil {
+		log.Fatalf("golimiterd: listener: %v", err)
+	}
+	log.Printf("golimiterd: proxying %s to %s", ln.Addr(), cfg.Upstream)
+	srv := &http.Server{
+		Handler:     m.record(lim, proxy),
+		ConnContext: lim.ConnContext,
+	}
+	log.Fatal(srv.Serve(ln))
+}
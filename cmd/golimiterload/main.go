@@ -0,0 +1,54 @@
+// Command golimiterload runs a golimiter.Limiter's http middleware under a
+// synthetic workload and reports its throughput, latency and allocations,
+// to catch and document performance regressions between releases
+//
+// Usage:
+//
+//	golimiterload -workload zipf -ips 100000 -workers 8 -duration 30s
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/i-norden/golimiter"
+	"github.com/i-norden/golimiter/bench"
+	"golang.org/x/time/rate"
+)
+
+func main() {
+	workload := flag.String("workload", "uniform", "client distribution: uniform, zipf or spoofed")
+	ips := flag.Int("ips", 10000, "distinct client ips of the uniform and zipf workloads")
+	s := flag.Float64("zipf-s", 1.1, "exponent of the zipf workload (> 1)")
+	r := flag.Float64("rate", 10, "rate of each visitor's bucket (per second)")
+	burst := flag.Int("burst", 20, "burst of each visitor's bucket")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "concurrent clients")
+	duration := flag.Duration("duration", 0, "length of the run (default 10s)")
+	seed := flag.Int64("seed", 1, "seed of the clients' random sources")
+	flag.Parse()
+
+	var w bench.Workload
+	switch *workload {
+	case "uniform":
+		w = bench.Uniform(*ips)
+	case "zipf":
+		if *s <= 1 {
+			log.Fatal("golimiterload: -zipf-s must be greater than 1")
+		}
+		w = bench.Zipfian(*ips, *s)
+	case "spoofed":
+		w = bench.Spoofed()
+	default:
+		log.Fatalf("golimiterload: unknown workload %q", *workload)
+	}
+
+	lim := &golimiter.Limiter{Rate: rate.Limit(*r), Burst: *burst}
+	if err := lim.Init(); err != nil {
+		log.Fatalf("golimiterload: initializing limiter: %v", err)
+	}
+	defer lim.Stop()
+	res := bench.Run(lim, bench.Config{Workload: w, Workers: *workers, Duration: *duration, Seed: *seed})
+	fmt.Printf("%s (%d workers): %v\n", *workload, *workers, res)
+}
@@ -0,0 +1,140 @@
+package golimiter
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Responses shared by identical GETs denied by the load state
+type coalescer struct {
+	sync.Mutex
+	entries map[string]*coalesced // Responses by method, host and uri
+}
+
+// A response recorded for identical requests
+type coalesced struct {
+	done    chan struct{} // Closed once the response has been recorded
+	ok      bool          // Whether the response could be shared
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// Serves a GET denied only by the load state from a shared response instead:
+// the first such request for a uri is let through and its response is served
+// to the identical requests that follow for Coalesce.TTL
+// Returns false if the request should be denied as usual
+func (l *Limiter) coalesce(w http.ResponseWriter, r *http.Request, next http.Handler, key string) bool {
+	if l.Coalesce.TTL <= 0 || r.Method != http.MethodGet || checking(w) ||
+		r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+		return false // Responses to credentialed requests may be personal
+	}
+	uri := r.Host + r.URL.RequestURI()
+	l.flights.Lock()
+	c, exists := l.flights.entries[uri]
+	if !exists || time.Now().After(c.expires) {
+		c = l.startFlight(uri)
+		l.flights.Unlock()
+		if c == nil {
+			return false // The cache is full
+		}
+		r = r.WithContext(NewContext(r.Context(), Decision{Allowed: true, Key: key}))
+		l.recordFlight(w, r, next, c)
+		return true
+	}
+	l.flights.Unlock()
+	select {
+	case <-c.done:
+	case <-r.Context().Done():
+		return false
+	}
+	if !c.ok {
+		return false
+	}
+	for k, vals := range c.header {
+		w.Header()[k] = vals
+	}
+	w.WriteHeader(c.status)
+	w.Write(c.body)
+	return true
+}
+
+// Adds an in-flight entry for the uri, nil if the cache is full
+// Must be called while holding the coalescer's lock
+func (l *Limiter) startFlight(uri string) *coalesced {
+	size := l.Coalesce.Size
+	if size == 0 {
+		size = 100 // Use default size if none provided
+	}
+	if l.flights.entries == nil {
+		l.flights.entries = make(map[string]*coalesced)
+	}
+	now := time.Now()
+	if len(l.flights.entries) >= size {
+		for k, c := range l.flights.entries {
+			if now.After(c.expires) {
+				delete(l.flights.entries, k)
+			}
+		}
+		if len(l.flights.entries) >= size {
+			return nil
+		}
+	}
+	c := &coalesced{done: make(chan struct{}), expires: now.Add(l.Coalesce.TTL)}
+	l.flights.entries[uri] = c
+	return c
+}
+
+// Serves the request while recording its response for the entry
+// Responses that are not 200s, are marked private or no-store, set cookies,
+// vary or exceed Coalesce.MaxBytes are not shared
+func (l *Limiter) recordFlight(w http.ResponseWriter, r *http.Request, next http.Handler, c *coalesced) {
+	max := l.Coalesce.MaxBytes
+	if max == 0 {
+		max = 1 << 20 // Use default max if none provided
+	}
+	rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK, max: max}
+	defer func() {
+		cc := strings.ToLower(rw.Header().Get("Cache-Control"))
+		c.ok = rw.status == http.StatusOK && !rw.over && rw.Header().Get("Set-Cookie") == "" &&
+			rw.Header().Get("Vary") == "" && !strings.Contains(cc, "private") && !strings.Contains(cc, "no-store")
+		c.status, c.header, c.body = rw.status, rw.Header().Clone(), rw.body.Bytes()
+		close(c.done)
+	}()
+	next.ServeHTTP(rw, r)
+}
+
+// Records the status and (up to max bytes of the) body of a response
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	max    int64
+	over   bool // Whether the body exceeded max
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if !rw.over && int64(rw.body.Len()+len(p)) <= rw.max {
+		rw.body.Write(p)
+	} else {
+		rw.over = true
+		rw.body.Reset()
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Lets handlers flush through the recording writer
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
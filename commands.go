@@ -0,0 +1,84 @@
+package golimiter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits the commands of one long-lived connection (e.g. an SMTP session's
+// RCPT TOs or an IMAP session's commands), so abuse within a connection is
+// limited and not just its establishment
+// Commands are charged to the visitor the connection is limited under, and
+// to the connection's own bucket if CommandLimits.Rate is set
+type Commands struct {
+	l    *Limiter
+	key  string
+	conn *rate.Limiter // The connection's own bucket, nil if CommandLimits.Rate is not set
+}
+
+// Returns a command limiter for a connection limited under the key: its ip,
+// RemoteIP(conn.RemoteAddr().String()), or its identity's key
+// Get one per connection, e.g. at the start of LimitNetConn's handler
+func (l *Limiter) Commands(key string) *Commands {
+	c := &Commands{l: l, key: key}
+	if l.CommandLimits.Rate > 0 {
+		burst := l.CommandLimits.Burst
+		if burst == 0 {
+			burst = 1 // Use default burst if none provided
+		}
+		c.conn = rate.NewLimiter(l.CommandLimits.Rate, burst)
+	}
+	return c
+}
+
+// Checks whether the connection is allowed the named command (e.g. "RCPT"),
+// charging its cost in CommandLimits.Costs
+// Denied decisions carry the wait before a retry could pass in RetryAfter
+func (c *Commands) Allow(cmd string) Decision {
+	return c.AllowN(cmd, 1)
+}
+
+// Checks whether the connection is allowed n of the named command at once
+func (c *Commands) AllowN(cmd string, n int) Decision {
+	l := c.l
+	switch l.Mode() {
+	case AllowAll:
+		return Decision{Allowed: true}
+	case DenyAll:
+		return Decision{Allowed: false}
+	}
+	cost := n
+	if per, ok := l.CommandLimits.Costs[cmd]; ok {
+		cost = n * per
+	}
+	now := time.Now()
+	var held *rate.Reservation
+	if c.conn != nil {
+		held = c.conn.ReserveN(now, cost)
+		if !held.OK() || held.DelayFrom(now) > 0 {
+			d := Decision{Allowed: false}
+			if held.OK() {
+				d.RetryAfter = held.DelayFrom(now)
+			}
+			held.CancelAt(now)
+			held = nil
+			if !l.shadows(c.key, nil) {
+				atomic.AddUint64(&l.denied, 1)
+				return d
+			}
+		}
+	}
+	l.updateState()
+	d := l.allowN(l.getVisitor(c.key), cost)
+	if !d.Allowed && !l.shadows(c.key, nil) {
+		if held != nil {
+			held.CancelAt(now) // The connection's tokens are not spent on a denied command
+		}
+		atomic.AddUint64(&l.denied, 1)
+		return d
+	}
+	l.notifyAllow(c.key)
+	return Decision{Allowed: true, Remaining: d.Remaining}
+}
@@ -1,22 +1,183 @@
 package common
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// List file formats
+const (
+	FormatLines = "lines" // Newline delimited ips (the legacy format)
+	FormatJSON  = "json"  // JSON array of entries
+	FormatYAML  = "yaml"  // YAML sequence of entries
 )
 
+// A list entry with its metadata
+type Entry struct {
+	IP      string    `json:"ip" yaml:"ip"`
+	Expires time.Time `json:"expires,omitempty" yaml:"expires,omitempty"` // Entry is ignored after this time (zero- never)
+	Reason  string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	AddedBy string    `json:"added_by,omitempty" yaml:"added_by,omitempty"`
+	Level   int       `json:"level,omitempty" yaml:"level,omitempty"` // Level assigned to the ip's visitor (0- unchanged)
+}
+
 // Function for reading in newline delimited list from file
+// Structured (JSON/YAML) list files are also read, by their extension;
+// malformed, duplicate and expired entries are left out (see ParseEntries)
 func ReadList(loc string) (list []string, err error) {
-	raw, err := ioutil.ReadFile(loc)
+	entries, err := ReadEntries(loc, "")
 	if err != nil {
 		return
 	}
-	list = strings.Split(string(raw), "\n")
+	list = make([]string, len(entries))
+	for i, e := range entries {
+		list[i] = e.IP
+	}
 	return
 }
 
+// Function for reading in list entries from file in the given format
+// If format is empty it is chosen by the file's extension (.json, .yaml or .yml,
+// otherwise lines); malformed, duplicate and expired entries are left out
+func ReadEntries(loc string, format string) (entries []Entry, err error) {
+	raw, err := ioutil.ReadFile(loc)
+	if err != nil {
+		return
+	}
+	if format == "" {
+		format = FormatByExt(loc)
+	}
+	return DecodeEntries(raw, format)
+}
+
+// Function for decoding list entries in the given format
+// Malformed, duplicate and expired entries are left out
+func DecodeEntries(raw []byte, format string) (entries []Entry, err error) {
+	res, err := ParseEntries(raw, format)
+	return res.Entries, err
+}
+
+// Result of parsing a list file
+type ParseResult struct {
+	Entries    []Entry      // Well-formed entries that haven't expired, without duplicates
+	Malformed  []ParseError // Entries that are not an ip address or cidr, which are left out
+	Duplicates int          // Entries left out for repeating an earlier entry's ip
+}
+
+// A malformed list entry
+type ParseError struct {
+	Line int    // Line of the entry; for JSON lists its position in the array (both from 1)
+	Text string // The entry's ip as written
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d: %q is not an ip address or cidr", e.Line, e.Text)
+}
+
+// Function for parsing list entries in the given format
+// In the lines format blank lines are skipped, whitespace is trimmed and
+// "#" starts a comment; in every format malformed entries are reported,
+// duplicates are counted and both are left out, as are expired entries
+// The error is only set if the list as a whole couldn't be decoded
+func ParseEntries(raw []byte, format string) (res ParseResult, err error) {
+	var entries []Entry
+	var lines []int
+	switch format {
+	case FormatLines:
+		for i, line := range strings.Split(string(raw), "\n") {
+			if c := strings.IndexByte(line, '#'); c >= 0 {
+				line = line[:c]
+			}
+			if line = strings.TrimSpace(line); line != "" { // Also trims the \r of CRLF line endings
+				entries = append(entries, Entry{IP: line})
+				lines = append(lines, i+1)
+			}
+		}
+	case FormatJSON:
+		err = json.Unmarshal(raw, &entries)
+		for i := range entries {
+			lines = append(lines, i+1)
+		}
+	case FormatYAML:
+		var nodes []yaml.Node
+		if err = yaml.Unmarshal(raw, &nodes); err == nil {
+			entries = make([]Entry, len(nodes))
+			for i := range nodes {
+				if err = nodes[i].Decode(&entries[i]); err != nil {
+					break
+				}
+				lines = append(lines, nodes[i].Line)
+			}
+		}
+	default:
+		return res, fmt.Errorf("unknown list format %q", format)
+	}
+	if err != nil {
+		return res, fmt.Errorf("decoding %s list: %v", format, err)
+	}
+	now := time.Now()
+	seen := make(map[string]bool, len(entries))
+	for i, e := range entries {
+		e.IP = strings.TrimSpace(e.IP)
+		if !ValidEntry(e.IP) {
+			res.Malformed = append(res.Malformed, ParseError{Line: lines[i], Text: e.IP})
+			continue
+		}
+		if seen[e.IP] {
+			res.Duplicates++
+			continue
+		}
+		seen[e.IP] = true
+		if e.Expires.IsZero() || e.Expires.After(now) {
+			res.Entries = append(res.Entries, e)
+		}
+	}
+	return res, nil
+}
+
+// Checks whether a list entry is an ip address or cidr
+func ValidEntry(ip string) bool {
+	_, _, err := parseKey(ip)
+	return err == nil
+}
+
+// Function for encoding list entries in the given format
+func EncodeEntries(entries []Entry, format string) ([]byte, error) {
+	switch format {
+	case FormatLines:
+		var b strings.Builder
+		for _, e := range entries {
+			b.WriteString(e.IP + "\n")
+		}
+		return []byte(b.String()), nil
+	case FormatJSON:
+		return json.MarshalIndent(entries, "", "  ")
+	case FormatYAML:
+		return yaml.Marshal(entries)
+	}
+	return nil, fmt.Errorf("unknown list format %q", format)
+}
+
+// Returns the list format for a file by its extension
+func FormatByExt(loc string) string {
+	switch strings.ToLower(filepath.Ext(loc)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return FormatLines
+}
+
 // Common function to check if string is in array and return it's index
 // If there are duplicates it returns the first found (lowest index)
+// Deprecated: scans the whole array; use an IPSet for ip lists
 func InArray(array []string, val string) (exists bool, index int) {
 	exists = false
 	for i, v := range array {
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns the ips of the entries
+func ips(entries []Entry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.IP
+	}
+	return out
+}
+
+func TestParseLines(t *testing.T) {
+	raw := "# blocked ips\r\n10.0.0.1\r\n\n  10.0.0.2  # trailing comment\n10.0.0.1\nnot-an-ip\n\t\n192.0.2.0/24\n10.0.0.300\n"
+	res, err := ParseEntries([]byte(raw), FormatLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ips(res.Entries); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2", "192.0.2.0/24"}) {
+		t.Errorf("entries %q", got)
+	}
+	if res.Duplicates != 1 {
+		t.Errorf("%d duplicates, want 1", res.Duplicates)
+	}
+	want := []ParseError{{Line: 6, Text: "not-an-ip"}, {Line: 9, Text: "10.0.0.300"}}
+	if !reflect.DeepEqual(res.Malformed, want) {
+		t.Errorf("malformed %+v, want %+v", res.Malformed, want)
+	}
+}
+
+func TestParseStructured(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	for format, raw := range map[string]string{
+		FormatJSON: `[{"ip": "10.0.0.1", "reason": "abuse"}, {"ip": "bogus"}, {"ip": " 10.0.0.2 "},
+			{"ip": "10.0.0.1"}, {"ip": "10.0.0.3", "expires": "` + past + `"}]`,
+		FormatYAML: "- ip: 10.0.0.1\n  reason: abuse\n- ip: bogus\n- ip: ' 10.0.0.2 '\n- ip: 10.0.0.1\n- ip: 10.0.0.3\n  expires: " + past + "\n",
+	} {
+		res, err := ParseEntries([]byte(raw), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got := ips(res.Entries); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+			t.Errorf("%s: entries %q", format, got)
+		}
+		if res.Entries[0].Reason != "abuse" || res.Duplicates != 1 {
+			t.Errorf("%s: got %+v", format, res)
+		}
+		// JSON entries are numbered by position, YAML ones by line
+		line := map[string]int{FormatJSON: 2, FormatYAML: 3}[format]
+		if len(res.Malformed) != 1 || res.Malformed[0] != (ParseError{Line: line, Text: "bogus"}) {
+			t.Errorf("%s: malformed %+v", format, res.Malformed)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := ParseEntries([]byte("10.0.0.1"), "csv"); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if _, err := ParseEntries([]byte(`{"ip": "10.0.0.1"}`), FormatJSON); err == nil {
+		t.Error("json that isn't an array accepted")
+	}
+	if _, err := ParseEntries([]byte("ip: 10.0.0.1"), FormatYAML); err == nil {
+		t.Error("yaml that isn't a sequence accepted")
+	}
+	if res, err := ParseEntries(nil, FormatLines); err != nil || len(res.Entries) != 0 {
+		t.Errorf("empty list: %+v, %v", res, err)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	entries := []Entry{{IP: "10.0.0.1", Reason: "abuse"}, {IP: "2001:db8::/32", Level: 2}}
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		raw, err := EncodeEntries(entries, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := ParseEntries(raw, format)
+		if err != nil || !reflect.DeepEqual(res.Entries, entries) {
+			t.Errorf("%s: decoded %+v, %v", format, res.Entries, err)
+		}
+	}
+	raw, _ := EncodeEntries(entries, FormatLines)
+	if string(raw) != "10.0.0.1\n2001:db8::/32\n" {
+		t.Errorf("lines: %q", raw)
+	}
+}
+
+func TestFormatByExt(t *testing.T) {
+	for loc, format := range map[string]string{
+		"/etc/blacklist":               FormatLines,
+		"list.txt":                     FormatLines,
+		"list.JSON":                    FormatJSON,
+		"list.yml":                     FormatYAML,
+		"https://lists.example/a.yaml": FormatYAML,
+	} {
+		if got := FormatByExt(loc); got != format {
+			t.Errorf("FormatByExt(%q) = %q, want %q", loc, got, format)
+		}
+	}
+}
+
+func TestReadList(t *testing.T) {
+	loc := filepath.Join(t.TempDir(), "blacklist.txt")
+	if err := os.WriteFile(loc, []byte("10.0.0.1\r\n# comment\n\n10.0.0.1\ngarbage\n10.0.0.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ReadList(loc)
+	if err != nil || !reflect.DeepEqual(list, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("ReadList = %q, %v", list, err)
+	}
+	if _, err := ReadList(loc + ".missing"); err == nil {
+		t.Fatal("missing file read")
+	}
+}
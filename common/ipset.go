@@ -0,0 +1,209 @@
+package common
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// IPSet is a set of list entries keyed by ip address or cidr, with O(1)
+// lookups of addresses and longest-prefix matching of cidrs
+// A set is not safe for concurrent changes; instead take a Snapshot, which
+// can be read concurrently while the set keeps changing (copy-on-write)
+type IPSet struct {
+	addrs  map[netip.Addr]Entry
+	nets   map[int]map[netip.Prefix]Entry // Cidrs by their prefix length
+	bits   []int                          // Prefix lengths in nets, longest first
+	shared bool                           // Whether the maps are shared with a snapshot and must be copied before a change
+}
+
+// Creates a set of the entries
+// Entries that are not an ip address or cidr are left out
+func NewIPSet(entries []Entry) *IPSet {
+	s := &IPSet{addrs: make(map[netip.Addr]Entry, len(entries)), nets: make(map[int]map[netip.Prefix]Entry)}
+	for _, e := range entries {
+		s.Add(e)
+	}
+	return s
+}
+
+// Parses an entry's ip as an address, or otherwise as a cidr
+func parseKey(ip string) (netip.Addr, netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Unmap(), netip.Prefix{}, nil
+	}
+	p, err := netip.ParsePrefix(ip)
+	if err != nil {
+		return netip.Addr{}, netip.Prefix{}, fmt.Errorf("%q is not an ip address or cidr", ip)
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return netip.Addr{}, p.Masked(), nil
+}
+
+// Adds the entry, replacing any entry with the same ip or cidr
+func (s *IPSet) Add(e Entry) error {
+	addr, p, err := parseKey(e.IP)
+	if err != nil {
+		return err
+	}
+	s.own()
+	if addr.IsValid() {
+		s.addrs[addr] = e
+		return nil
+	}
+	if s.nets[p.Bits()] == nil {
+		s.nets[p.Bits()] = make(map[netip.Prefix]Entry)
+		s.bits = append(s.bits, p.Bits())
+		sort.Sort(sort.Reverse(sort.IntSlice(s.bits)))
+	}
+	s.nets[p.Bits()][p] = e
+	return nil
+}
+
+// Removes the entry with the ip or cidr; addresses covered by
+// another cidr in the set are not removed
+// Returns whether there was such an entry
+func (s *IPSet) Remove(ip string) bool {
+	if _, ok := s.Get(ip); !ok {
+		return false
+	}
+	addr, p, _ := parseKey(ip)
+	s.own()
+	if addr.IsValid() {
+		delete(s.addrs, addr)
+		return true
+	}
+	delete(s.nets[p.Bits()], p)
+	if len(s.nets[p.Bits()]) == 0 {
+		delete(s.nets, p.Bits())
+		for i, b := range s.bits {
+			if b == p.Bits() {
+				s.bits = append(s.bits[:i:i], s.bits[i+1:]...)
+				break
+			}
+		}
+	}
+	return true
+}
+
+// Returns the entry with exactly the ip or cidr, whether or not it has expired
+func (s *IPSet) Get(ip string) (Entry, bool) {
+	if s == nil {
+		return Entry{}, false
+	}
+	addr, p, err := parseKey(ip)
+	if err != nil {
+		return Entry{}, false
+	}
+	if addr.IsValid() {
+		e, ok := s.addrs[addr]
+		return e, ok
+	}
+	e, ok := s.nets[p.Bits()][p]
+	return e, ok
+}
+
+// Returns the entry matching the ip address that hasn't expired: the address's
+// own entry, or else the entry of the longest cidr containing it
+func (s *IPSet) Lookup(ip string) (Entry, bool) {
+	if s == nil {
+		return Entry{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Entry{}, false
+	}
+	addr = addr.Unmap()
+	now := time.Now()
+	if e, ok := s.addrs[addr]; ok && live(e, now) {
+		return e, true
+	}
+	for _, b := range s.bits {
+		if b > addr.BitLen() {
+			continue
+		}
+		p, _ := addr.Prefix(b)
+		if e, ok := s.nets[b][p]; ok && live(e, now) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Checks whether the ip address is in the set (see Lookup); a nil set is empty
+func (s *IPSet) Contains(ip string) bool {
+	_, ok := s.Lookup(ip)
+	return ok
+}
+
+// Returns the number of entries, including expired ones
+func (s *IPSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	n := len(s.addrs)
+	for _, nets := range s.nets {
+		n += len(nets)
+	}
+	return n
+}
+
+// Returns the entries that haven't expired, ordered by ip
+func (s *IPSet) Entries() []Entry {
+	entries := make([]Entry, 0, s.Len())
+	if s == nil {
+		return entries
+	}
+	now := time.Now()
+	for _, e := range s.addrs {
+		if live(e, now) {
+			entries = append(entries, e)
+		}
+	}
+	for _, nets := range s.nets {
+		for _, e := range nets {
+			if live(e, now) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+	return entries
+}
+
+// Returns a copy of the set; the two share their data until either changes
+// A snapshot of a nil set is empty
+func (s *IPSet) Snapshot() *IPSet {
+	if s == nil {
+		return NewIPSet(nil)
+	}
+	s.shared = true
+	return &IPSet{addrs: s.addrs, nets: s.nets, bits: s.bits, shared: true}
+}
+
+// Copies the set's data before a change if it is shared with a snapshot
+func (s *IPSet) own() {
+	if !s.shared {
+		return
+	}
+	addrs := make(map[netip.Addr]Entry, len(s.addrs)+1)
+	for k, e := range s.addrs {
+		addrs[k] = e
+	}
+	nets := make(map[int]map[netip.Prefix]Entry, len(s.nets))
+	for b, m := range s.nets {
+		nets[b] = make(map[netip.Prefix]Entry, len(m))
+		for k, e := range m {
+			nets[b][k] = e
+		}
+	}
+	s.addrs, s.nets, s.bits, s.shared = addrs, nets, append([]int(nil), s.bits...), false
+}
+
+// Checks whether the entry hasn't expired
+func live(e Entry, now time.Time) bool {
+	return e.Expires.IsZero() || now.Before(e.Expires)
+}
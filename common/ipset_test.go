@@ -0,0 +1,99 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPSetLookup(t *testing.T) {
+	s := NewIPSet([]Entry{
+		{IP: "10.0.0.0/8", Reason: "wide"},
+		{IP: "10.1.0.0/16", Reason: "narrow"},
+		{IP: "10.1.2.3", Reason: "exact"},
+		{IP: "2001:db8::/32", Reason: "v6"},
+		{IP: "192.0.2.0/24", Reason: "expired", Expires: time.Now().Add(-time.Minute)},
+		{IP: "garbage"}, // Left out
+	})
+	if s.Len() != 5 {
+		t.Fatalf("%d entries, want 5", s.Len())
+	}
+	for ip, reason := range map[string]string{
+		"10.1.2.3":          "exact",
+		"10.1.2.4":          "narrow", // The longest cidr containing it
+		"10.2.0.1":          "wide",
+		"::ffff:10.1.2.3":   "exact", // IPv4-mapped addresses match their IPv4 entries
+		"2001:db8::1":       "v6",
+		"2001:db9::1":       "",
+		"192.0.2.1":         "", // Expired
+		"172.16.0.1":        "",
+		"not an ip":         "",
+		"10.1.0.0/16":       "", // Lookup takes addresses, not cidrs
+		"2001:db8:ffff::ff": "v6",
+	} {
+		e, ok := s.Lookup(ip)
+		if ok != (reason != "") || e.Reason != reason {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", ip, e.Reason, ok, reason)
+		}
+	}
+	if _, ok := s.Get("10.1.0.0/16"); !ok {
+		t.Error("Get of a cidr entry failed")
+	}
+	if _, ok := s.Get("10.1.0.1"); ok {
+		t.Error("Get matched an address by its cidr")
+	}
+}
+
+func TestIPSetRemove(t *testing.T) {
+	s := NewIPSet([]Entry{{IP: "10.0.0.0/8"}, {IP: "10.1.0.0/16"}, {IP: "10.1.2.3"}})
+	if s.Remove("10.1.2.4") {
+		t.Fatal("removed an address only covered by a cidr")
+	}
+	if !s.Remove("10.1.2.3") || !s.Contains("10.1.2.3") {
+		t.Fatal("address removed along with its cidrs")
+	}
+	if !s.Remove("10.1.0.0/16") || !s.Remove("10.0.0.0/8") || s.Contains("10.1.2.3") {
+		t.Fatal("cidrs not removed")
+	}
+	if s.Len() != 0 || len(s.bits) != 0 {
+		t.Fatalf("%d entries and prefix lengths %v left", s.Len(), s.bits)
+	}
+	var empty *IPSet
+	if empty.Contains("10.0.0.1") || empty.Len() != 0 || len(empty.Entries()) != 0 {
+		t.Fatal("nil set not empty")
+	}
+}
+
+func TestIPSetSnapshot(t *testing.T) {
+	s := NewIPSet([]Entry{{IP: "10.0.0.1"}, {IP: "10.1.0.0/16"}})
+	snap := s.Snapshot()
+	s.Add(Entry{IP: "10.0.0.2"})
+	s.Add(Entry{IP: "192.168.0.0/16"})
+	s.Remove("10.1.0.0/16")
+	// The snapshot keeps the entries it was taken with
+	if !snap.Contains("10.1.2.3") || snap.Contains("10.0.0.2") || snap.Contains("192.168.1.1") || snap.Len() != 2 {
+		t.Fatalf("snapshot changed with the set: %v", snap.Entries())
+	}
+	if s.Contains("10.1.2.3") || !s.Contains("10.0.0.2") || !s.Contains("192.168.1.1") || s.Len() != 3 {
+		t.Fatalf("set entries %v", s.Entries())
+	}
+	// Changes to the snapshot don't reach the set either
+	snap.Add(Entry{IP: "10.9.9.9"})
+	if s.Contains("10.9.9.9") {
+		t.Fatal("snapshot change reached the set")
+	}
+	var nilSet *IPSet
+	if nilSet.Snapshot().Len() != 0 {
+		t.Fatal("snapshot of a nil set not empty")
+	}
+}
+
+func TestIPSetEntriesOrdered(t *testing.T) {
+	s := NewIPSet([]Entry{{IP: "10.0.0.2"}, {IP: "10.0.0.0/24"}, {IP: "10.0.0.1"}, {IP: "10.0.0.3", Expires: time.Now().Add(-time.Second)}})
+	var got []string
+	for _, e := range s.Entries() {
+		got = append(got, e.IP)
+	}
+	if len(got) != 3 || got[0] != "10.0.0.0/24" || got[1] != "10.0.0.1" || got[2] != "10.0.0.2" {
+		t.Fatalf("entries %v", got)
+	}
+}
@@ -0,0 +1,121 @@
+package golimiter
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Error returned by LimitListener's Accept when Conns.Max connections are
+// open; it is temporary, so servers that back off and retry on temporary
+// errors (as http.Server does) keep serving
+var ErrTooManyConns net.Error = connLimitError{}
+
+type connLimitError struct{}
+
+func (connLimitError) Error() string   { return "golimiter: too many open connections" }
+func (connLimitError) Timeout() bool   { return false }
+func (connLimitError) Temporary() bool { return true }
+
+// Connections open through LimitListener
+type connCounts struct {
+	sync.Mutex
+	total int            // Open connections
+	ips   map[string]int // Open connections by ip
+}
+
+// Returns a listener bounding the connections open at once, like
+// golang.org/x/net/netutil.LimitListener, both overall (Conns.Max) and
+// per ip (Conns.PerIP)
+// Connections over an ip's limit are closed and Accept moves on to the
+// next; connections over the overall limit are closed and Accept returns
+// ErrTooManyConns, a temporary error, so the server backs off until some
+// close. Closed connections are counted in Stats.EarlyDrops
+func (l *Limiter) LimitListener(ln net.Listener) net.Listener {
+	return &limitListener{Listener: ln, l: l}
+}
+
+type limitListener struct {
+	net.Listener
+	l *Limiter
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ll.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		c, err := ll.l.openConn(conn)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			return c, nil
+		}
+	}
+}
+
+// Counts the accepted connection as open, returning it wrapped to be
+// uncounted on Close
+// Returns a nil conn if it was closed for its ip's limit, and
+// ErrTooManyConns if it was closed for the overall limit
+func (l *Limiter) openConn(conn net.Conn) (net.Conn, error) {
+	if l.Mode() == AllowAll || (l.Conns.Max <= 0 && l.Conns.PerIP <= 0) {
+		return conn, nil
+	}
+	ip := RemoteIP(conn.RemoteAddr().String())
+	perIP := l.Conns.PerIP > 0 && !(l.whitelistOn() && l.whitelisted(ip))
+	cc := &l.conns
+	cc.Lock()
+	rule := ""
+	switch {
+	case l.Conns.Max > 0 && cc.total >= l.Conns.Max:
+		rule = "max_conns"
+	case perIP && cc.ips[ip] >= l.Conns.PerIP:
+		rule = "ip_conns"
+	default:
+		if cc.ips == nil {
+			cc.ips = make(map[string]int)
+		}
+		cc.total++
+		cc.ips[ip]++
+		cc.Unlock()
+		return &openConn{Conn: conn, l: l, ip: ip}, nil
+	}
+	cc.Unlock()
+	if l.shadows(ip, nil) {
+		atomic.AddUint64(&l.shadowed, 1)
+		return conn, nil
+	}
+	atomic.AddUint64(&l.acceptGate.drops, 1)
+	conn.Close()
+	if l.AcceptGate.OnDrop != nil {
+		l.AcceptGate.OnDrop(ip, rule)
+	}
+	if rule == "max_conns" {
+		return nil, ErrTooManyConns
+	}
+	return nil, nil
+}
+
+// Connection counted as open by LimitListener until it is closed
+type openConn struct {
+	net.Conn
+	l    *Limiter
+	ip   string
+	once sync.Once
+}
+
+func (c *openConn) Close() error {
+	c.once.Do(func() {
+		cc := &c.l.conns
+		cc.Lock()
+		cc.total--
+		if cc.ips[c.ip]--; cc.ips[c.ip] <= 0 {
+			delete(cc.ips, c.ip)
+		}
+		cc.Unlock()
+	})
+	return c.Conn.Close()
+}
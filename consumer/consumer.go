@@ -0,0 +1,90 @@
+// Package consumer throttles asynchronous message consumers (e.g. NATS
+// subscriptions or Kafka readers) with a golimiter.Limiter, so the limits
+// configured for HTTP ingress also apply to async ingestion paths
+// It does not depend on any broker client; the handlers are generic over
+// the client's message type, e.g. *nats.Msg or kafka.Message
+package consumer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Returned by a wrapped handler for a message over its limit when the
+// policy is Reject and no OnLimited func is set, so that the message is
+// left unacknowledged and redelivered
+var ErrLimited = errors.New("consumer: message over its rate limit")
+
+// What to do with messages over their limit
+type Policy int
+
+const (
+	// Block until the message's key is allowed, pausing consumption
+	// (backpressure) until the context is done
+	Wait Policy = iota
+	// Don't handle the message; call OnLimited (e.g. to Nak it with a delay)
+	Reject
+)
+
+// A message handler
+type Handler[M any] func(ctx context.Context, msg M) error
+
+// Throttle settings
+type Throttle[M any] struct {
+	Limiter *golimiter.Limiter // Limiter the messages are checked against (must be initialized)
+	// Returns the key a message is limited under, e.g. its subject or tenant
+	Key    func(msg M) string
+	Policy Policy // Wait (default) or Reject
+	// Called instead of the handler for messages over their limit under
+	// the Reject policy, with the time until their key is allowed again
+	// (e.g. func(m *nats.Msg, d time.Duration) error { return m.NakWithDelay(d) })
+	OnLimited func(msg M, retryAfter time.Duration) error
+}
+
+// Wraps the handler so that each message is checked against the limiter
+// under its key before being handled
+func (t *Throttle[M]) Wrap(next Handler[M]) Handler[M] {
+	return func(ctx context.Context, msg M) error {
+		key := t.Key(msg)
+		if t.Policy == Wait {
+			if err := t.Limiter.WaitKey(ctx, key); err != nil {
+				return err
+			}
+			return next(ctx, msg)
+		}
+		d := t.Limiter.AllowBatch([]string{key})[0]
+		if !d.Allowed {
+			return t.limited(msg, d.RetryAfter)
+		}
+		return next(ctx, msg)
+	}
+}
+
+// Checks a fetched batch of messages with a single limiter call and
+// splits it into the messages that are allowed and those over their limit
+// Limited messages are not passed to OnLimited
+func (t *Throttle[M]) Filter(msgs []M) (allowed, limited []M) {
+	keys := make([]string, len(msgs))
+	for i, msg := range msgs {
+		keys[i] = t.Key(msg)
+	}
+	for i, d := range t.Limiter.AllowBatch(keys) {
+		if d.Allowed {
+			allowed = append(allowed, msgs[i])
+		} else {
+			limited = append(limited, msgs[i])
+		}
+	}
+	return
+}
+
+// Handles a message over its limit
+func (t *Throttle[M]) limited(msg M, retry time.Duration) error {
+	if t.OnLimited == nil {
+		return ErrLimited
+	}
+	return t.OnLimited(msg, retry)
+}
@@ -0,0 +1,69 @@
+package golimiter
+
+import (
+	"net/http"
+)
+
+// How the limiter charges requests against a visitor's bucket
+type CostMode int
+
+const (
+	// Each request costs one token
+	CostRequests CostMode = iota
+	// Requests cost a token per BytesPerToken of their Content-Length
+	// Requests larger than the visitor's burst are always denied
+	CostRequestBytes
+	// Requests need one token to be let through, and are then charged a token
+	// per BytesPerToken written in the response, putting the visitor into debt
+	CostResponseBytes
+)
+
+// Returns the number of tokens a request costs up front
+func (l *Limiter) requestCost(r *http.Request) int {
+	if l.Cost.Mode != CostRequestBytes || r.ContentLength <= 0 {
+		return 1
+	}
+	return int(l.bytesToTokens(r.ContentLength))
+}
+
+// Converts a number of bytes to tokens, rounded up, at least one
+func (l *Limiter) bytesToTokens(n int64) int64 {
+	per := l.Cost.BytesPerToken
+	if per <= 0 {
+		per = 1024
+	}
+	tokens := (n + per - 1) / per
+	if tokens < 1 {
+		tokens = 1
+	}
+	return tokens
+}
+
+// Serves the request, then charges the visitor for the response's size
+// beyond the token already taken to let it through
+func (l *Limiter) serveCharged(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	cw := &countingWriter{ResponseWriter: w}
+	next.ServeHTTP(cw, r)
+	if extra := l.bytesToTokens(cw.n) - 1; extra > 0 {
+		l.ChargeKey(key, int(extra))
+	}
+}
+
+// Counts the bytes written in a response
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Lets handlers flush through the counting writer
+func (cw *countingWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,131 @@
+package golimiter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A point on a load curve, giving the per visitor rate and burst
+// enforced when the load is at Load * capacity
+type Breakpoint struct {
+	Load  float64    // Fraction of the capacity (e.g. 0.5 for 50% load)
+	Rate  rate.Limit // Per visitor rate at this load
+	Burst int        // Per visitor burst/bucket size at this load
+}
+
+// Weight given to the most recent second when smoothing the measured load
+const loadSmoothing = 0.5
+
+// Measures the load on the limiter in events per second
+type loadMeter struct {
+	start time.Time // Start of the current one second window
+	count int       // Events in the current window
+	rate  float64   // Exponentially smoothed events per second
+}
+
+// Records an event and returns the smoothed load
+func (m *loadMeter) add(now time.Time) float64 {
+	if m.start.IsZero() {
+		m.start = now
+	}
+	if elapsed := now.Sub(m.start); elapsed >= time.Second {
+		m.rate = loadSmoothing*float64(m.count) + (1-loadSmoothing)*m.rate
+		// Windows without any events decay the load
+		for idle := int(elapsed/time.Second) - 1; idle > 0 && m.rate > 0; idle-- {
+			m.rate *= 1 - loadSmoothing
+		}
+		m.start = now
+		m.count = 0
+	}
+	m.count++
+	return m.rate
+}
+
+// Sets a load curve that continuously interpolates the per visitor rate and burst
+// between the breakpoints, instead of stepping between discrete states
+// Capacity is the load, in events per second across all visitors, considered 100%
+// Below the first breakpoint its rate and burst are used, above the last the last's
+// While a curve is set it replaces Rate, Burst and the states; a nil curve removes it
+func (l *Limiter) SetCurve(points []Breakpoint, capacity float64) error {
+	if len(points) > 0 && capacity <= 0 {
+		return errors.New("curve capacity must be positive")
+	}
+	for i, p := range points {
+		if p.Rate < 0 {
+			return fmt.Errorf("breakpoint %d: rate must not be negative", i)
+		}
+		if p.Burst <= 0 {
+			return fmt.Errorf("breakpoint %d: burst must be positive", i)
+		}
+		if i > 0 && p.Load <= points[i-1].Load {
+			return errors.New("breakpoint loads must be strictly increasing")
+		}
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.curve = append([]Breakpoint(nil), points...)
+	l.capacity = capacity
+	if len(points) > 0 {
+		l.curRate, l.curBurst = points[0].Rate, points[0].Burst
+	}
+	return nil
+}
+
+// Records the event on the load meter and recomputes the curve's rate and burst
+// Must be called while holding the lock
+func (l *Limiter) updateCurve(now time.Time) {
+	if len(l.curve) == 0 {
+		return
+	}
+	load := l.meter.add(now) / l.capacity
+	l.curRate, l.curBurst = interpolate(l.curve, load)
+}
+
+// Returns the rate and burst at the given load
+func interpolate(points []Breakpoint, load float64) (rate.Limit, int) {
+	first, last := points[0], points[len(points)-1]
+	if load <= first.Load {
+		return first.Rate, first.Burst
+	}
+	if load >= last.Load {
+		return last.Rate, last.Burst
+	}
+	for i := 1; i < len(points); i++ {
+		lo, hi := points[i-1], points[i]
+		if load > hi.Load {
+			continue
+		}
+		f := (load - lo.Load) / (hi.Load - lo.Load)
+		r := lo.Rate + rate.Limit(f)*(hi.Rate-lo.Rate)
+		b := int(math.Round(float64(lo.Burst) + f*float64(hi.Burst-lo.Burst)))
+		if b < 1 {
+			b = 1
+		}
+		return r, b
+	}
+	return last.Rate, last.Burst
+}
+
+// Brings the visitor's default limiter in line with the curve
+// Fixed params (policies, dimensions, subnets, ...) replace the curve
+// Must be called while holding the lock
+func (l *Limiter) applyCurve(v *visitor) {
+	if len(l.curve) == 0 || v.fixed != nil {
+		return
+	}
+	if _, ok := l.overridden(v); ok { // Overrides replace the curve
+		return
+	}
+	r, b := l.scale(l.curRate, l.curBurst)
+	now := time.Now()
+	if v.limiter.Limit() != r {
+		v.limiter.SetLimitAt(now, r)
+	}
+	if v.limiter.Burst() != b {
+		v.limiter.SetBurstAt(now, b)
+	}
+}
@@ -0,0 +1,33 @@
+package golimiter
+
+import "testing"
+
+func TestCurveSkipsFixedBuckets(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Cleanup.Off = true
+	l.Policies = map[string]Plan{"search": {Rate: 0.001, Burst: 2}}
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetCurve([]Breakpoint{{Load: 0, Rate: 1000, Burst: 50}}, 100); err != nil {
+		t.Fatal(err)
+	}
+	// The policy's bucket keeps its own burst of 2, not the curve's 50
+	p := l.Policy("search")
+	for i := 0; i < 2; i++ {
+		if !p.AllowKey("a") {
+			t.Fatalf("policy request %d denied", i)
+		}
+	}
+	if p.AllowKey("a") {
+		t.Fatal("policy bucket was given the curve's burst")
+	}
+	// Default buckets follow the curve
+	l.AllowKey("a")
+	l.Lock()
+	burst := l.visitors["a"].limiter.Burst()
+	l.Unlock()
+	if burst != 50 {
+		t.Fatalf("default bucket burst %d, want the curve's 50", burst)
+	}
+}
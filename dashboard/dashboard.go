@@ -0,0 +1,121 @@
+// Package dashboard serves a single page dashboard for a golimiter.Limiter
+// showing its live allow/deny rates, state transitions, top visitors and
+// list contents, for teams without a metrics stack
+package dashboard
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/i-norden/golimiter"
+	c "github.com/i-norden/golimiter/common"
+)
+
+//go:embed index.html
+var index []byte
+
+// Dashboard settings
+type Dashboard struct {
+	Limiter *golimiter.Limiter // Limiter that is shown
+	Top     int                // Number of top visitors shown (default 10)
+	History int                // Number of recent events shown (default 50)
+	mux     *http.ServeMux
+	mu      sync.Mutex
+	events  []event // Recent events, oldest first
+}
+
+// An event as shown on the dashboard
+type event struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Key    string    `json:"key,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Err    string    `json:"error,omitempty"`
+}
+
+// Creates a dashboard for the given (initialized) limiter
+// The dashboard is hooked into the limiter's event hook to record its
+// recent events, chaining any hook already set
+// Mount it under a prefix with http.StripPrefix, e.g.
+// mux.Handle("/limiter/", http.StripPrefix("/limiter", d))
+func New(l *golimiter.Limiter) *Dashboard {
+	d := &Dashboard{Limiter: l, mux: http.NewServeMux()}
+	next := l.EventHook()
+	l.SetOnEvent(func(e golimiter.Event) {
+		if next != nil {
+			next(e)
+		}
+		d.record(e)
+	})
+	d.mux.HandleFunc("/", d.page)
+	d.mux.HandleFunc("/api/stats", d.stats)
+	d.mux.HandleFunc("/api/lists", d.lists)
+	return d
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// Keeps the event in the history
+func (d *Dashboard) record(e golimiter.Event) {
+	ev := event{Time: e.Time, Kind: string(e.Kind), Key: e.Key, Detail: e.Detail}
+	if e.Err != nil {
+		ev.Err = e.Err.Error()
+	}
+	max := d.History
+	if max == 0 {
+		max = 50 // Use default history if none provided
+	}
+	d.mu.Lock()
+	d.events = append(d.events, ev)
+	if len(d.events) > max {
+		d.events = append([]event(nil), d.events[len(d.events)-max:]...)
+	}
+	d.mu.Unlock()
+}
+
+// Serves the page itself
+func (d *Dashboard) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(index)
+}
+
+// Serves the limiter's stats, top visitors and recent events, polled by the page
+// Rates are derived by the page from successive counter values
+func (d *Dashboard) stats(w http.ResponseWriter, r *http.Request) {
+	top := d.Top
+	if top == 0 {
+		top = 10 // Use default top visitors if none provided
+	}
+	d.mu.Lock()
+	events := append([]event(nil), d.events...)
+	d.mu.Unlock()
+	writeJSON(w, struct {
+		Time   time.Time                `json:"time"`
+		Stats  golimiter.Stats          `json:"stats"`
+		Top    []golimiter.VisitorStats `json:"top"`
+		Events []event                  `json:"events"`
+	}{time.Now(), d.Limiter.Stats(), d.Limiter.TopVisitors(top), events})
+}
+
+// Serves the white/blacklist entries
+func (d *Dashboard) lists(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		Whitelist []c.Entry `json:"whitelist"`
+		Blacklist []c.Entry `json:"blacklist"`
+	}{d.Limiter.WhitelistEntries(), d.Limiter.BlacklistEntries()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
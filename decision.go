@@ -0,0 +1,95 @@
+package golimiter
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The outcome of limiting an event
+type Decision struct {
+	Allowed    bool          // Whether or not the event was allowed
+	RetryAfter time.Duration // If denied, time until the visitor's bucket will have a token (0 if unknown)
+	Degraded   bool          // If denied, whether the load state rather than the visitor's own rate caused it
+	Remaining  int           // If allowed by the local limiters, whole tokens left in the visitor's bucket
+	Key        string        // Visitor key the request was limited under, for requests passed on by the middleware
+	// If denied, whether the API key's quota was exhausted rather than its rate
+	QuotaExceeded bool
+	// If allowed, whether the event was borrowed from the global bucket's spare capacity
+	Borrowed bool
+	// If allowed, the warning given for having used SoftLimit.Threshold of the
+	// limit (e.g. "85% of rate limit used"), "" if none
+	Warning string
+	// If allowed, the state of each of the limiter's dimensions
+	Dimensions []DimensionState
+	// Set by Check: if denied, the rule that denied the event (as in the audit log),
+	// its reason code and the status the middleware would have responded with
+	Rule   string
+	Reason Reason
+	Status int
+	// Set by Check: the headers the middleware would have set on the response
+	Header http.Header
+}
+
+// Returns the response status for a denied decision
+func (l *Limiter) deniedStatus(d Decision) int {
+	if l.Backpressure.Status != 0 && l.overloaded() {
+		return l.Backpressure.Status
+	}
+	if d.Degraded && l.Responses.DegradedStatus != 0 {
+		return l.Responses.DegradedStatus
+	}
+	if l.Responses.LimitedStatus != 0 {
+		return l.Responses.LimitedStatus
+	}
+	return http.StatusTooManyRequests
+}
+
+// Returns the response status for ips denied by the white/blacklists or bans
+func (l *Limiter) listedStatus() int {
+	if l.Responses.ListedStatus != 0 {
+		return l.Responses.ListedStatus
+	}
+	return http.StatusForbidden
+}
+
+// Unexported type for the context key, to avoid collisions
+type decisionKey struct{}
+
+// Returns a copy of the context carrying the decision
+func NewContext(ctx context.Context, d Decision) context.Context {
+	return context.WithValue(ctx, decisionKey{}, d)
+}
+
+// Returns the decision carried by the context, if any
+// Requests passed downstream by LimitHTTPHandler carry the decision made for them
+func FromContext(ctx context.Context) (Decision, bool) {
+	d, ok := ctx.Value(decisionKey{}).(Decision)
+	return d, ok
+}
+
+// Returns the time until the limiter will have n tokens,
+// found by reserving them and cancelling the reservation
+func retryAfter(lim *rate.Limiter, n int) time.Duration {
+	now := time.Now()
+	r := lim.ReserveN(now, n)
+	if !r.OK() {
+		return 0 // The limiter can never allow the event
+	}
+	d := r.DelayFrom(now)
+	r.CancelAt(now)
+	return d
+}
+
+// Sets the Retry-After header in whole seconds, rounded up
+// Nothing is set if the retry time is unknown
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	secs := int64((d + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+}
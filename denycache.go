@@ -0,0 +1,35 @@
+package golimiter
+
+import "time"
+
+// A denial cached for a visitor until its retry time
+type cachedDenial struct {
+	until    time.Time
+	degraded bool
+}
+
+// Returns the visitor's cached denial if caching is on and it hasn't expired
+func (l *Limiter) cachedDenial(v *visitor) (Decision, bool) {
+	if !l.DenyCache {
+		return Decision{}, false
+	}
+	c := v.denied.Load()
+	if c == nil {
+		return Decision{}, false
+	}
+	retry := time.Until(c.until)
+	if retry <= 0 {
+		v.denied.CompareAndSwap(c, nil)
+		return Decision{}, false
+	}
+	return Decision{RetryAfter: retry, Degraded: c.degraded}, true
+}
+
+// Caches a denial with a known retry time, so that the visitor's events
+// until then are denied without consulting its limiters or the store
+func (l *Limiter) cacheDenial(v *visitor, d Decision) {
+	if !l.DenyCache || d.Allowed || d.RetryAfter <= 0 {
+		return
+	}
+	v.denied.Store(&cachedDenial{until: time.Now().Add(d.RetryAfter), degraded: d.Degraded})
+}
@@ -0,0 +1,157 @@
+package golimiter
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A limit applied to one dimension of a request (e.g. its ip, API key or endpoint)
+// Dimensions are enforced in addition to the visitor's own limit; a request
+// is rejected if any of them is exhausted
+type Dimension struct {
+	Name  string                       // Dimension name, used in headers and stats
+	Key   func(r *http.Request) string // Extracts the request's key for the dimension; empty skips it
+	Rate  rate.Limit                   // Rate per key
+	Burst int                          // Burst/bucket size per key
+	// Optional pattern of the requests the dimension applies to, as [host][/path]
+	// e.g. "api.example.com/*", "*.example.com/v1/*" or "/login" (default all requests)
+	Match string
+}
+
+// The state of a dimension after a request was evaluated against it
+type DimensionState struct {
+	Name      string
+	Key       string
+	Allowed   bool
+	Remaining int // Whole tokens left in the key's bucket
+}
+
+// Returns the request's path as the dimension key
+func PathKey(r *http.Request) string {
+	return r.URL.Path
+}
+
+// Returns the request's remote ip as the dimension key
+func IPKey(r *http.Request) string {
+	return RemoteIP(r.RemoteAddr)
+}
+
+// Returns a key func reading the named request header
+func HeaderKey(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// Evaluates the request against all of the limiter's dimensions
+// Tokens are only taken if every dimension allows the request; the returned
+// func gives them back, for requests the visitor's own limits then deny
+func (l *Limiter) allowDimensions(r *http.Request) (Decision, []DimensionState, func()) {
+	if len(l.Dimensions) == 0 {
+		return Decision{Allowed: true}, nil, func() {}
+	}
+	now := time.Now()
+	states := make([]DimensionState, 0, len(l.Dimensions))
+	reservations := make([]*rate.Reservation, 0, len(l.Dimensions))
+	limiters := make([]*rate.Limiter, 0, len(l.Dimensions))
+	d := Decision{Allowed: true}
+	for i := range l.Dimensions {
+		name := l.Dimensions[i].Name // Rate and Burst may be changed by SetRoute, so are only read under the lock
+		if !l.routes[i].matches(r) {
+			continue
+		}
+		key := l.Dimensions[i].Key(r)
+		if key == "" {
+			continue
+		}
+		lim := l.dimensionLimiter(i, key)
+		res := lim.ReserveN(now, 1)
+		st := DimensionState{Name: name, Key: key, Allowed: true}
+		if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+			st.Allowed = false
+			if d.Allowed {
+				atomic.AddUint64(&l.dimDenials[i], 1)
+			}
+			d.Allowed = false
+			if delay > d.RetryAfter && delay != rate.InfDuration {
+				d.RetryAfter = delay
+			}
+		}
+		states = append(states, st)
+		reservations = append(reservations, res)
+		limiters = append(limiters, lim)
+	}
+	for i, res := range reservations {
+		if !d.Allowed {
+			res.CancelAt(now) // Return the tokens taken from the dimensions that allowed it
+		}
+		states[i].Remaining = int(limiters[i].TokensAt(now))
+	}
+	if !d.Allowed {
+		return d, states, func() {}
+	}
+	return d, states, func() {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+}
+
+// Returns the number of requests each dimension was the first to deny, by name
+func (l *Limiter) DimensionDenials() map[string]uint64 {
+	denials := make(map[string]uint64, len(l.Dimensions))
+	for i, dim := range l.Dimensions {
+		denials[dim.Name] = atomic.LoadUint64(&l.dimDenials[i])
+	}
+	return denials
+}
+
+// Returns the rule naming the first dimension that denied a request
+func deniedDimension(states []DimensionState) string {
+	for _, st := range states {
+		if !st.Allowed {
+			return "dimension:" + st.Name
+		}
+	}
+	return "dimension"
+}
+
+// Sets the remaining tokens of each dimension as X-RateLimit-Remaining-<Name> headers
+func setDimensionHeaders(w http.ResponseWriter, states []DimensionState) {
+	for _, st := range states {
+		w.Header().Set("X-RateLimit-Remaining-"+st.Name, strconv.Itoa(st.Remaining))
+	}
+}
+
+// Returns the limiter for the key of the i'th dimension
+func (l *Limiter) dimensionLimiter(i int, key string) *rate.Limiter {
+	l.Lock()
+	defer l.Unlock()
+	dim := l.Dimensions[i]
+	return l.getFixedVisitor(dimensionKey(dim.Name, key), params{rate: dim.Rate, burst: dim.Burst}).limiter
+}
+
+// Returns the visitor key a dimension key is limited under
+func dimensionKey(name, key string) string {
+	return "dim:" + name + ":" + key
+}
+
+// Returns the visitor for the key, limited by fixed params instead of the default ones
+// Must be called while holding the lock
+func (l *Limiter) getFixedVisitor(key string, p params) *visitor {
+	v, exists := l.visitors[key]
+	if !exists {
+		v = &visitor{key: key, fixed: &p, lastSeen: time.Now(), seen: 1}
+		v.limiter = rate.NewLimiter(p.rate, p.burst)
+		l.visitors[key] = v
+		l.track(v)
+		return v
+	}
+	v.lastSeen = time.Now()
+	v.seen++
+	return v
+}
@@ -0,0 +1,5 @@
+// Package golimiter rate limits visitors of http handlers and net connections,
+// with optional white/blacklists and load dependent limiter states
+// It is the single package of this module; the subpackages plug stores,
+// identities and protocols into its Limiter
+package golimiter
@@ -0,0 +1,42 @@
+package golimiter
+
+import (
+	"net"
+	"net/http"
+)
+
+// How denials are answered instead of with their status and a text body,
+// so hostile traffic gets as little as possible for its requests
+type DropMode int
+
+const (
+	// Respond with the status and a text body (the default)
+	DropNone DropMode = iota
+	// Respond with the status only, without a body
+	DropBody
+	// Close the connection without responding, with a reset if it is TCP
+	// (HTTP/2 streams are reset instead, leaving the connection open)
+	DropConn
+)
+
+// Answers the denial as the mode says
+// Returns false if the mode is DropNone and the denial is still to be answered
+func dropResponse(w http.ResponseWriter, status int, mode DropMode) bool {
+	switch mode {
+	case DropBody:
+		w.WriteHeader(status)
+		return true
+	case DropConn:
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			// Makes the server close the connection or reset the stream without logging
+			panic(http.ErrAbortHandler)
+		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetLinger(0) // Reset rather than close gracefully
+		}
+		conn.Close()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package golimiter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Returned (within an ErrInvalidConfig) when the whitelist file can't be read
+type ErrWhitelistUnreadable struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrWhitelistUnreadable) Error() string {
+	return fmt.Sprintf("whitelist %q is unreadable: %v", e.Path, e.Err)
+}
+
+func (e *ErrWhitelistUnreadable) Unwrap() error { return e.Err }
+
+// Returned (within an ErrInvalidConfig) when the blacklist file can't be read
+type ErrBlacklistUnreadable struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrBlacklistUnreadable) Error() string {
+	return fmt.Sprintf("blacklist %q is unreadable: %v", e.Path, e.Err)
+}
+
+func (e *ErrBlacklistUnreadable) Unwrap() error { return e.Err }
+
+// Returned by Validate and Init with every problem found in the configuration
+type ErrInvalidConfig struct {
+	Problems []error
+}
+
+func (e *ErrInvalidConfig) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return "invalid limiter configuration: " + strings.Join(msgs, "; ")
+}
+
+// Allows errors.Is and errors.As to match any of the problems
+func (e *ErrInvalidConfig) Unwrap() []error { return e.Problems }
+
+// Checks the limiter's settings and returns an *ErrInvalidConfig
+// listing all of the problems found, or nil
+// Unset (zero) settings are not problems; Init gives them their defaults
+// The lists are read (or fetched) before taking the lock, so requests
+// aren't held up while they are
+func (l *Limiter) Validate() error {
+	lists := l.readLists()
+	l.Lock()
+	defer l.Unlock()
+	return l.validate(lists)
+}
+
+// Must be called while holding the lock, with the lists read by readLists
+func (l *Limiter) validate(lists listReads) error {
+	var probs []error
+	add := func(format string, args ...interface{}) {
+		probs = append(probs, fmt.Errorf(format, args...))
+	}
+	if l.Rate < 0 {
+		add("rate must not be negative")
+	}
+	if l.Burst < 0 {
+		add("burst must not be negative")
+	}
+	if l.Whitelist.On {
+		if l.Whitelist.Filename == "" {
+			add("whitelist file path is not set")
+		} else if lists.white.err != nil {
+			probs = append(probs, &ErrWhitelistUnreadable{Path: l.Whitelist.Filename, Err: lists.white.err})
+		}
+	}
+	if l.Whitelist.UpdateFreq < 0 {
+		add("whitelist update frequency must not be negative")
+	}
+	if l.Blacklist.On {
+		if l.Blacklist.Filename == "" {
+			add("blacklist file path is not set")
+		} else if lists.black.err != nil {
+			probs = append(probs, &ErrBlacklistUnreadable{Path: l.Blacklist.Filename, Err: lists.black.err})
+		}
+	}
+	if l.Blacklist.UpdateFreq < 0 {
+		add("blacklist update frequency must not be negative")
+	}
+	if l.Cleanup.Freq < 0 || l.Cleanup.Thres < 0 || l.Cleanup.Batch < 0 {
+		add("cleanup frequency, threshold and batch must not be negative")
+	}
+	if l.Replicas.Count < 0 || l.Replicas.UpdateFreq < 0 {
+		add("replica count and update frequency must not be negative")
+	}
+	if l.Tarpit.Delay < 0 || l.Tarpit.MaxConcurrent < 0 {
+		add("tarpit delay and max concurrent must not be negative")
+	}
+	if l.Admission.Threshold < 0 || l.Admission.Window < 0 || l.Admission.Width < 0 || l.Admission.Depth < 0 {
+		add("admission settings must not be negative")
+	}
+	if l.Breaker.Failures < 0 || l.Breaker.Cooldown < 0 {
+		add("breaker failures and cooldown must not be negative")
+	}
+	if l.Breaker.Fallback < FallbackLocal || l.Breaker.Fallback > FallbackDeny {
+		add("unknown store fallback %d", l.Breaker.Fallback)
+	}
+	if l.OnInternalError < ErrorLocal || l.OnInternalError > ErrorDeny {
+		add("unknown internal error policy %d", l.OnInternalError)
+	}
+	if l.ListReload.Failures < 0 || l.ListReload.MaxStaleness < 0 {
+		add("list reload failures and max staleness must not be negative")
+	}
+	if l.ListFetch.Timeout < 0 || l.ListFetch.Retries < 0 {
+		add("list fetch timeout and retries must not be negative")
+	}
+	if l.SlowConns.HeaderTimeout < 0 || l.SlowConns.MinRate < 0 || l.SlowConns.Window < 0 || l.SlowConns.BanFor < 0 {
+		add("slow connection settings must not be negative")
+	}
+	if l.SlowConns.Blacklist && !l.Blacklist.On {
+		add("slow connections blacklist needs the blacklist on, or bans have no effect")
+	}
+	if l.Errors.Threshold < 0 || l.Errors.Threshold > 1 || l.Errors.Weight < 0 || l.Errors.Weight > 1 {
+		add("errors threshold and weight must be between 0 and 1")
+	}
+	if l.Errors.Factor < 0 {
+		add("errors factor must not be negative")
+	}
+	if l.Failures.BanAfter > 0 && !l.Blacklist.On {
+		add("failures ban after needs the blacklist on, or bans have no effect")
+	}
+	if l.Failures.Threshold < 0 || l.Failures.Block < 0 || l.Failures.BanAfter < 0 || l.Failures.BanFor < 0 || l.Failures.Forget < 0 {
+		add("failure penalty settings must not be negative")
+	}
+	if h := l.Hierarchy; h.SubnetRate < 0 || h.SubnetBurst < 0 || h.GlobalRate < 0 || h.GlobalBurst < 0 {
+		add("hierarchy rates and bursts must not be negative")
+	}
+	if h := l.Hierarchy; h.IPv4Prefix < 0 || h.IPv4Prefix > 32 || h.IPv6Prefix < 0 || h.IPv6Prefix > 128 {
+		add("hierarchy prefixes must be valid prefix lengths")
+	}
+	if l.Elastic.Cap < 0 || l.Elastic.Window < 0 {
+		add("elastic cap and window must not be negative")
+	}
+	if l.Elastic.On && l.Hierarchy.GlobalRate == 0 {
+		add("elastic borrowing needs a global rate to borrow from")
+	}
+	if l.Idempotency.Window < 0 || l.Idempotency.Size < 0 {
+		add("idempotency window and size must not be negative")
+	}
+	if l.History.SpikeRatio > 0 && !l.Blacklist.On {
+		add("history spike ratio needs the blacklist on, or spike bans have no effect")
+	}
+	if l.History.Minutes < 0 || l.History.SpikeRatio < 0 || l.History.SpikeMin < 0 || l.History.SpikeBan < 0 {
+		add("history minutes and spike settings must not be negative")
+	}
+	if l.History.SpikeRatio > 0 && l.History.Minutes == 0 {
+		add("spike bans need History.Minutes")
+	}
+	if l.Leaky.MaxQueue < 0 {
+		add("leaky queue size must not be negative")
+	}
+	if l.Queue.MaxWait < 0 || l.Queue.Size < 0 {
+		add("queue max wait and size must not be negative")
+	}
+	if l.Queue.On && l.Hierarchy.GlobalRate == 0 {
+		add("queueing needs a global rate to wait for")
+	}
+	for priority, w := range l.Queue.Weights {
+		if w <= 0 {
+			add("priority %d: queue weight must be positive", priority)
+		}
+	}
+	if l.WarmUp.Window < 0 || l.WarmUp.From < 0 || l.WarmUp.From > 1 {
+		add("warm-up window must not be negative and its fraction must be between 0 and 1")
+	}
+	if l.InitialTokens.Count < 0 || l.InitialTokens.Fraction < 0 || l.InitialTokens.Fraction > 1 {
+		add("initial token count must not be negative and its fraction must be between 0 and 1")
+	}
+	if l.Hints.PerIP < 0 {
+		add("hints per ip must not be negative")
+	}
+	if l.Fingerprint.Handshake < 0 {
+		add("fingerprint handshake timeout must not be negative")
+	}
+	if l.ListReload.Watch < 0 {
+		add("list watch interval must not be negative")
+	}
+	if l.Backpressure.From < 0 {
+		add("backpressure state must not be negative")
+	}
+	for reason, m := range l.Responses.Drop {
+		if m < DropNone || m > DropConn {
+			add("reason %q: unknown drop mode %d", reason, m)
+		}
+	}
+	if l.AcceptGate.Rate < 0 || l.AcceptGate.Burst < 0 {
+		add("accept gate rate and burst must not be negative")
+	}
+	if l.CommandLimits.Rate < 0 || l.CommandLimits.Burst < 0 {
+		add("command rate and burst must not be negative")
+	}
+	for cmd, cost := range l.CommandLimits.Costs {
+		if cost < 0 {
+			add("command %q: cost must not be negative", cmd)
+		}
+	}
+	if l.Conns.Max < 0 || l.Conns.PerIP < 0 {
+		add("max and per ip connections must not be negative")
+	}
+	if l.ProxyProtocol.Timeout < 0 {
+		add("proxy protocol timeout must not be negative")
+	}
+	for _, cidr := range l.ProxyProtocol.Trusted {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add("proxy protocol trusted %q: %v", cidr, err)
+		}
+	}
+	for _, rc := range l.Classes.Routes {
+		if _, err := parseRoute(rc.Match); err != nil {
+			add("class route %q: %v", rc.Match, err)
+		}
+		if !validClass(rc.Class) {
+			add("class route %q: unknown class %q", rc.Match, rc.Class)
+		}
+	}
+	for class, from := range l.Classes.From {
+		if !validClass(class) || from < 0 {
+			add("class %q must be known and its first state must not be negative", class)
+		}
+	}
+	if l.Coalesce.TTL < 0 || l.Coalesce.Size < 0 || l.Coalesce.MaxBytes < 0 {
+		add("coalesce ttl, size and max bytes must not be negative")
+	}
+	if l.Methods.Read.Rate < 0 || l.Methods.Read.Burst < 0 || l.Methods.Write.Rate < 0 || l.Methods.Write.Burst < 0 {
+		add("method rates and bursts must not be negative")
+	}
+	if l.Geo.BanDenials < 0 || l.Geo.BanWindow < 0 || l.Geo.BanFor < 0 {
+		add("geo ban denials, window and length must not be negative")
+	}
+	if l.Geo.BanDenials > 0 && l.Geo.GeoIP == nil {
+		add("geo bans need a GeoIP")
+	}
+	if l.Reputation.PerDenial < 0 || l.Reputation.HalfLife < 0 || l.Reputation.Maturity < 0 {
+		add("reputation demerits, half-life and maturity must not be negative")
+	}
+	for _, t := range l.Reputation.Tiers {
+		if t.Below <= 0 || t.Below > 100 || t.Factor <= 0 {
+			add("reputation tiers need a score between 0 and 100 and a positive factor")
+		}
+	}
+	if l.SoftLimit.Threshold < 0 || l.SoftLimit.Threshold > 1 {
+		add("soft limit threshold must be between 0 and 1")
+	}
+	if l.Challenge.MaxFailures < 0 || l.Challenge.Window < 0 {
+		add("challenge max failures and window must not be negative")
+	}
+	if cc, ok := l.Challenge.Challenger.(*CookieChallenger); ok && len(cc.Secret) == 0 {
+		add("cookie challenger needs a secret, or its cookies can be forged")
+	}
+	for level, m := range l.Levels {
+		if m <= 0 {
+			add("level %d: multiplier must be positive", level)
+		}
+	}
+	for level, ttl := range l.Cleanup.Levels {
+		if ttl < 0 {
+			add("level %d: cleanup ttl must not be negative", level)
+		}
+	}
+	checkWindows := func(of string, windows []WindowLimit) {
+		for i, w := range windows {
+			if w.Limit <= 0 || w.Per <= 0 {
+				add("%swindow %d: limit and length must be positive", of, i)
+			}
+		}
+	}
+	checkWindows("", l.Windows)
+	for name, p := range l.Plans {
+		if p.Rate < 0 || p.Burst < 0 {
+			add("plan %q: rate and burst must not be negative", name)
+		}
+		checkWindows(fmt.Sprintf("plan %q: ", name), p.Windows)
+		if p.TTL < 0 {
+			add("plan %q: ttl must not be negative", name)
+		}
+	}
+	for name, p := range l.Policies {
+		if p.Rate < 0 || p.Burst < 0 {
+			add("policy %q: rate and burst must not be negative", name)
+		}
+		checkWindows(fmt.Sprintf("policy %q: ", name), p.Windows)
+	}
+	names := make(map[string]bool, len(l.Dimensions))
+	for i, dim := range l.Dimensions {
+		switch {
+		case dim.Name == "":
+			add("dimension %d has no name", i)
+		case names[dim.Name]:
+			add("dimension %q is defined more than once", dim.Name)
+		}
+		names[dim.Name] = true
+		if dim.Key == nil {
+			add("dimension %q has no key func", dim.Name)
+		}
+		if dim.Rate < 0 || dim.Burst < 0 {
+			add("dimension %q: rate and burst must not be negative", dim.Name)
+		}
+		if _, err := parseRoute(dim.Match); err != nil {
+			add("dimension %q: match %q: %v", dim.Name, dim.Match, err)
+		}
+	}
+	if l.Cost.Mode < CostRequests || l.Cost.Mode > CostResponseBytes {
+		add("unknown cost mode %d", l.Cost.Mode)
+	}
+	if l.Cost.BytesPerToken < 0 {
+		add("bytes per token must not be negative")
+	}
+	for _, w := range l.Schedule.Windows {
+		if _, err := parseWindow(w); err != nil {
+			probs = append(probs, err)
+		}
+	}
+	if len(probs) == 0 {
+		return nil
+	}
+	return &ErrInvalidConfig{Problems: probs}
+}
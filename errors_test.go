@@ -0,0 +1,96 @@
+package golimiter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serves a blacklist, counting the fetches and holding each until release is closed
+func listServer(t *testing.T, fetches *int32, release <-chan struct{}) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(fetches, 1)
+		<-release
+		w.Write([]byte("10.0.0.9\n"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Waits for the blacklist's first load by its update routine, which would
+// replace entries added before it
+func awaitBlacklist(t *testing.T, l *Limiter) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); l.Blacklist.list.Load() == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("blacklist not loaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestValidateFetchesOutsideLock(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	srv := listServer(t, &fetches, release)
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Blacklist.On = true
+	l.Blacklist.Filename = srv.URL + "/blacklist.txt"
+	done := make(chan error, 1)
+	go func() { done <- l.Validate() }()
+	for atomic.LoadInt32(&fetches) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The fetch is in flight; taking the lock must not wait for it
+	locked := make(chan struct{})
+	go func() {
+		l.Lock()
+		l.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock held while fetching the list")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitFetchesListOnce(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	close(release)
+	srv := listServer(t, &fetches, release)
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Cleanup.Off = true
+	l.Blacklist.On = true
+	l.Blacklist.Filename = srv.URL + "/blacklist.txt"
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	awaitBlacklist(t, l)
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("list fetched %d times by Init, want once", n)
+	}
+	if !l.Blacklist.list.Load().Contains("10.0.0.9") {
+		t.Fatal("fetched entry not in the blacklist")
+	}
+}
+
+func TestValidateReportsUnreadableList(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Whitelist.On = true
+	l.Whitelist.Filename = t.TempDir() + "/missing.txt"
+	var unreadable *ErrWhitelistUnreadable
+	if err := l.Validate(); !errors.As(err, &unreadable) {
+		t.Fatalf("got %v, want an unreadable whitelist", err)
+	}
+}
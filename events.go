@@ -0,0 +1,54 @@
+package golimiter
+
+import "time"
+
+// Kinds of events reported to the OnEvent hook
+type EventKind string
+
+const (
+	EventListRejected    EventKind = "list_rejected"     // A list file failed verification and was not applied
+	EventListMalformed   EventKind = "list_malformed"    // A list file has malformed entries, which were left out
+	EventListWriteFailed EventKind = "list_write_failed" // A runtime list change couldn't be written back to the list file
+	EventListDegraded    EventKind = "list_degraded"     // A list's reloads failed ListReload.Failures times in a row
+	EventListStale       EventKind = "list_stale"        // A list is older than ListReload.MaxStaleness; the StalePolicy applies
+	EventListRecovered   EventKind = "list_recovered"    // A degraded list was reloaded
+	EventBreakerOpen     EventKind = "breaker_open"      // The store's circuit breaker opened
+	EventBreakerClose    EventKind = "breaker_close"     // The store's circuit breaker closed
+	EventListChange      EventKind = "list_change"       // An ip was added to or removed from a list at runtime
+	EventStateChange     EventKind = "state_change"      // The limiter moved to another load state
+	EventModeChange      EventKind = "mode_change"       // The limiter's mode was changed
+	EventKeyBlocked      EventKind = "key_blocked"       // A key was blocked for its failed authentications
+	EventSlowConn        EventKind = "slow_conn"         // A connection was closed for drip-feeding bytes
+	EventSoftLimit       EventKind = "soft_limit"        // A key reached SoftLimit.Threshold of its limit; it was warned, not denied
+	EventASNBanned       EventKind = "asn_banned"        // An ASN was banned for Geo.BanDenials denials of its ips
+	EventInternalError   EventKind = "internal_error"    // The limiter failed to decide a request; OnInternalError decided instead
+)
+
+// Something noteworthy that happened in the limiter, for alerting and dashboards
+type Event struct {
+	Time time.Time
+	Kind EventKind
+	Key  string // Key or list the event concerns, if any
+	Err  error  // Error that caused the event, if any
+	// What changed, if anything: the new state or mode, or the ip added to or removed from a list
+	Detail string
+	// Network origin of the key, if Geo.GeoIP is set and the key is an ip
+	Origin Origin
+	// Reason code of the denials the event concerns, if any (e.g. ReasonStoreError for EventInternalError)
+	Reason Reason
+}
+
+// Reports an event to the OnEvent hook if one is set
+func (l *Limiter) emit(e Event) {
+	hook := l.EventHook()
+	if hook == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Origin == (Origin{}) {
+		e.Origin = l.originOf(e.Key)
+	}
+	hook(e)
+}
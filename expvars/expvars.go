@@ -0,0 +1,20 @@
+// Package expvars publishes a golimiter.Limiter's internals under expvar,
+// for teams that don't run Prometheus
+// It is kept out of the golimiter package because importing expvar
+// registers /debug/vars on http.DefaultServeMux
+package expvars
+
+import (
+	"expvar"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Publishes the limiter's Stats under the name (served at /debug/vars
+// by net/http's default mux); like expvar.Publish, it panics if the
+// name is already in use
+func Publish(name string, l *golimiter.Limiter) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return l.Stats()
+	}))
+}
@@ -0,0 +1,67 @@
+package golimiter
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// What the limiter does with a request it failed to decide, e.g. because its
+// store is down or the request's client could not be told
+type ErrorPolicy int
+
+const (
+	// Limit with the local limiters only (degraded)
+	ErrorLocal ErrorPolicy = iota
+	// Allow the request (fail-open)
+	ErrorAllow
+	// Deny the request (fail-closed)
+	ErrorDeny
+)
+
+// Error for requests without a remote address to limit them by
+var errNoClient = errors.New("request has no client address")
+
+// Outcomes of requests the limiter failed to decide, for Stats
+type failOutcomes struct {
+	open   uint64 // Allowed by the policy
+	closed uint64 // Denied by the policy
+	local  uint64 // Left to the local limiters
+}
+
+// Returns the decision of the policy for an event the limiter failed to decide,
+// and false if the local limiters should decide instead
+func (l *Limiter) failDecision(policy ErrorPolicy, retry time.Duration) (Decision, bool) {
+	switch policy {
+	case ErrorAllow:
+		atomic.AddUint64(&l.failed.open, 1)
+		return Decision{Allowed: true}, true
+	case ErrorDeny:
+		atomic.AddUint64(&l.failed.closed, 1)
+		return Decision{RetryAfter: retry}, true
+	}
+	atomic.AddUint64(&l.failed.local, 1)
+	return Decision{}, false
+}
+
+// Handles a request the limiter failed to decide by the OnInternalError policy:
+// it is let through, denied, or limited under the key by the local limiters only
+func (l *Limiter) failRequest(w http.ResponseWriter, r *http.Request, next http.Handler, key string, err error) {
+	l.emit(Event{Kind: EventInternalError, Key: key, Err: err, Reason: ReasonStoreError})
+	d, ok := l.failDecision(l.OnInternalError, 0)
+	if !ok {
+		v := l.getVisitor(key)
+		l.Lock()
+		d = l.allowLocked(v, 1, time.Now(), false)
+		l.Unlock()
+	}
+	status := l.deniedStatus(d)
+	if ok {
+		status = http.StatusServiceUnavailable // The limiter, not the visitor, failed
+	}
+	if !d.Allowed && l.deny(w, r, key, "internal", status, d.RetryAfter) {
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), d)))
+}
@@ -0,0 +1,117 @@
+// Package forward passes the limiter's decisions on to upstream applications
+// in signed request headers, so that apps behind golimiterd (or any proxy
+// using the middleware) can trust and reuse a decision without evaluating
+// the request again
+package forward
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Headers the decision is passed in
+const (
+	HeaderKey       = "X-Golimiter-Key"       // Visitor key the request was limited under
+	HeaderRemaining = "X-Golimiter-Remaining" // Whole tokens left in the visitor's bucket
+	HeaderTime      = "X-Golimiter-Time"      // Unix time the decision was signed at
+	HeaderSignature = "X-Golimiter-Signature" // Hex HMAC-SHA256 over the other headers
+)
+
+// Errors returned when a request's decision headers can't be trusted
+var (
+	ErrUnsigned  = errors.New("forward: request carries no signed decision")
+	ErrSignature = errors.New("forward: invalid decision signature")
+	ErrExpired   = errors.New("forward: decision is too old")
+)
+
+// Signs the decisions of allowed requests into their headers
+type Signer struct {
+	Secret []byte // HMAC key shared with the upstream's Verifier
+}
+
+// Wraps the handler the limiter passes allowed requests to (e.g. the reverse
+// proxy), so the requests reach it carrying their signed decision
+// Decision headers sent by clients are always removed
+func (s *Signer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		strip(r.Header)
+		if d, ok := golimiter.FromContext(r.Context()); ok && d.Allowed {
+			s.Sign(r.Header, d.Key, d.Remaining, time.Now())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Sets the signed decision headers
+func (s *Signer) Sign(h http.Header, key string, remaining int, at time.Time) {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	rem := strconv.Itoa(remaining)
+	h.Set(HeaderKey, key)
+	h.Set(HeaderRemaining, rem)
+	h.Set(HeaderTime, ts)
+	h.Set(HeaderSignature, sign(s.Secret, key, rem, ts))
+}
+
+// Verifies the decisions passed on by a Signer
+type Verifier struct {
+	Secret []byte        // HMAC key shared with the Signer
+	MaxAge time.Duration // Age after which a decision is no longer trusted (default 1 minute)
+}
+
+// A verified decision
+type Decision struct {
+	Key       string    // Visitor key the request was limited under
+	Remaining int       // Whole tokens left in the visitor's bucket
+	Time      time.Time // When the decision was signed
+}
+
+// Returns the request's decision if its headers are signed with the secret
+// and the decision isn't older than MaxAge
+func (v *Verifier) Verify(r *http.Request) (Decision, error) {
+	key, rem, ts, sig := r.Header.Get(HeaderKey), r.Header.Get(HeaderRemaining), r.Header.Get(HeaderTime), r.Header.Get(HeaderSignature)
+	if sig == "" {
+		return Decision{}, ErrUnsigned
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign(v.Secret, key, rem, ts))) {
+		return Decision{}, ErrSignature
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return Decision{}, ErrSignature
+	}
+	remaining, err := strconv.Atoi(rem)
+	if err != nil {
+		return Decision{}, ErrSignature
+	}
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = time.Minute // Use default max age if none provided
+	}
+	at := time.Unix(unix, 0)
+	if time.Since(at) > maxAge {
+		return Decision{}, ErrExpired
+	}
+	return Decision{Key: key, Remaining: remaining, Time: at}, nil
+}
+
+// Returns the hex HMAC-SHA256 over the header values, joined by newlines
+func sign(secret []byte, values ...string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(values, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Removes any decision headers
+func strip(h http.Header) {
+	for _, name := range []string{HeaderKey, HeaderRemaining, HeaderTime, HeaderSignature} {
+		h.Del(name)
+	}
+}
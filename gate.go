@@ -0,0 +1,109 @@
+package golimiter
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Connection-attempt buckets of the ips seen by GateListener
+type acceptGate struct {
+	sync.Mutex
+	ips   map[string]*rate.Limiter // Buckets by ip
+	swept time.Time                // When full buckets were last removed
+	drops uint64                   // Connections dropped here or by LimitListener, accessed atomically
+}
+
+// Returns a listener that closes connections as they are accepted if their
+// ip is blacklisted or over AcceptGate.Rate connection attempts, before any
+// TLS handshake or read, so a flood of connections costs as little as
+// possible; wrap it in the TLS listener, e.g.
+// tls.NewListener(lim.GateListener(ln), config)
+// Drops are counted in Stats.EarlyDrops, apart from the denials of requests
+// and of connections in LimitNetConn
+func (l *Limiter) GateListener(ln net.Listener) net.Listener {
+	return &gateListener{Listener: ln, l: l}
+}
+
+type gateListener struct {
+	net.Listener
+	l *Limiter
+}
+
+// Returns the next connection the gate lets through
+func (gl *gateListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := gl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if gl.l.gate(conn) {
+			return conn, nil
+		}
+	}
+}
+
+// Reports whether the accepted connection passes the gate, closing it if not
+func (l *Limiter) gate(conn net.Conn) bool {
+	if l.Mode() == AllowAll {
+		return true
+	}
+	ip := RemoteIP(conn.RemoteAddr().String())
+	if l.whitelistOn() && l.whitelisted(ip) {
+		return true
+	}
+	rule := ""
+	switch {
+	case l.blacklistOn() && l.blacklisted(ip):
+		rule = "blacklist"
+	case !l.gateAllow(ip, time.Now()):
+		rule = "accept_rate"
+	default:
+		return true
+	}
+	if l.shadows(ip, nil) {
+		atomic.AddUint64(&l.shadowed, 1)
+		return true
+	}
+	atomic.AddUint64(&l.acceptGate.drops, 1)
+	conn.Close()
+	if l.AcceptGate.OnDrop != nil {
+		l.AcceptGate.OnDrop(ip, rule)
+	}
+	return false
+}
+
+// Takes a connection attempt from the ip's bucket
+// Buckets that have refilled are removed once a minute
+func (l *Limiter) gateAllow(ip string, now time.Time) bool {
+	if l.AcceptGate.Rate <= 0 {
+		return true
+	}
+	burst := l.AcceptGate.Burst
+	if burst == 0 {
+		burst = 1 // Use default burst if none provided
+	}
+	g := &l.acceptGate
+	g.Lock()
+	defer g.Unlock()
+	if g.ips == nil {
+		g.ips = make(map[string]*rate.Limiter)
+	}
+	if now.Sub(g.swept) >= time.Minute {
+		for k, lim := range g.ips {
+			if lim.TokensAt(now) >= float64(burst) {
+				delete(g.ips, k)
+			}
+		}
+		g.swept = now
+	}
+	lim, ok := g.ips[ip]
+	if !ok {
+		lim = rate.NewLimiter(l.AcceptGate.Rate, burst)
+		g.ips[ip] = lim
+	}
+	return lim.AllowN(now, 1)
+}
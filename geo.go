@@ -0,0 +1,214 @@
+package golimiter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The network origin of an ip
+type Origin struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	ASN     uint32 `json:"asn,omitempty"`     // Autonomous system number
+}
+
+// Resolves the network origin of ips, e.g. backed by a MaxMind database
+type GeoIP interface {
+	Origin(ip string) (Origin, bool)
+}
+
+// A GeoIP of cidr ranges, with longest-prefix matching
+// e.g. loaded from an ip-to-asn CSV export with LoadGeoCSV
+type GeoTable struct {
+	nets map[int]map[netip.Prefix]Origin // Ranges by their prefix length
+	bits []int                           // Prefix lengths in nets, longest first
+}
+
+// Creates an empty table
+func NewGeoTable() *GeoTable {
+	return &GeoTable{nets: make(map[int]map[netip.Prefix]Origin)}
+}
+
+// Adds the origin of the cidr (or single ip), replacing any origin it had
+// Not safe to use concurrently with lookups; fill the table before using it
+func (t *GeoTable) Add(cidr string, o Origin) error {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		addr, aerr := netip.ParseAddr(cidr)
+		if aerr != nil {
+			return fmt.Errorf("%q is not an ip address or cidr", cidr)
+		}
+		p = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	} else if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	p = p.Masked()
+	if t.nets[p.Bits()] == nil {
+		t.nets[p.Bits()] = make(map[netip.Prefix]Origin)
+		t.bits = append(t.bits, p.Bits())
+		sort.Sort(sort.Reverse(sort.IntSlice(t.bits)))
+	}
+	t.nets[p.Bits()][p] = o
+	return nil
+}
+
+// Returns the origin of the longest range containing the ip
+func (t *GeoTable) Origin(ip string) (Origin, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Origin{}, false
+	}
+	addr = addr.Unmap()
+	for _, b := range t.bits {
+		if b > addr.BitLen() {
+			continue
+		}
+		p, _ := addr.Prefix(b)
+		if o, ok := t.nets[b][p]; ok {
+			return o, true
+		}
+	}
+	return Origin{}, false
+}
+
+// Reads a table from CSV rows of cidr,country,asn (e.g. "192.0.2.0/24,US,64496")
+// Rows starting with # are skipped; the asn can carry an "AS" prefix
+func LoadGeoCSV(r io.Reader) (*GeoTable, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	t := NewGeoTable()
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("geo csv: line %d: want cidr,country,asn", line)
+		}
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rec[2])), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("geo csv: line %d: asn: %v", line, err)
+		}
+		o := Origin{Country: strings.ToUpper(strings.TrimSpace(rec[1])), ASN: uint32(asn)}
+		if err = t.Add(strings.TrimSpace(rec[0]), o); err != nil {
+			return nil, fmt.Errorf("geo csv: line %d: %v", line, err)
+		}
+	}
+}
+
+// Returns the origin of the key if Geo.GeoIP is set and the key is an ip
+func (l *Limiter) originOf(key string) Origin {
+	if l.Geo.GeoIP == nil {
+		return Origin{}
+	}
+	o, _ := l.Geo.GeoIP.Origin(key)
+	return o
+}
+
+// Denials and bans of ASNs, for banning whole networks
+type asnBans struct {
+	sync.Mutex
+	denials map[uint32]asnWindow // Denials in the current window, by ASN
+	banned  map[uint32]time.Time // End of the ban, by ASN
+}
+
+// Denials of an ASN counted in a window
+type asnWindow struct {
+	start time.Time
+	n     int
+}
+
+// Counts a denial of the ip against its ASN, and bans the ASN once it has
+// been denied Geo.BanDenials times within Geo.BanWindow
+func (l *Limiter) countASNDenial(ip string) {
+	if l.Geo.BanDenials <= 0 {
+		return
+	}
+	o := l.originOf(ip)
+	if o.ASN == 0 {
+		return
+	}
+	window, banFor := l.Geo.BanWindow, l.Geo.BanFor
+	if window == 0 {
+		window = time.Minute // Use default window if none provided
+	}
+	if banFor == 0 {
+		banFor = time.Hour // Use default ban if none provided
+	}
+	now := time.Now()
+	l.asns.Lock()
+	if l.asns.denials == nil {
+		l.asns.denials = make(map[uint32]asnWindow)
+		l.asns.banned = make(map[uint32]time.Time)
+	}
+	w := l.asns.denials[o.ASN]
+	if now.Sub(w.start) >= window {
+		w = asnWindow{start: now}
+	}
+	w.n++
+	l.asns.denials[o.ASN] = w
+	banned := w.n >= l.Geo.BanDenials && now.After(l.asns.banned[o.ASN])
+	if banned {
+		l.asns.banned[o.ASN] = now.Add(banFor)
+		delete(l.asns.denials, o.ASN)
+	}
+	l.asns.Unlock()
+	if banned {
+		l.emit(Event{Kind: EventASNBanned, Key: ip, Detail: "AS" + strconv.FormatUint(uint64(o.ASN), 10), Origin: o})
+	}
+}
+
+// Checks whether the ip's ASN is banned
+func (l *Limiter) asnBanned(ip string) bool {
+	if l.Geo.BanDenials <= 0 {
+		return false
+	}
+	o := l.originOf(ip)
+	if o.ASN == 0 {
+		return false
+	}
+	l.asns.Lock()
+	defer l.asns.Unlock()
+	until, ok := l.asns.banned[o.ASN]
+	if ok && time.Now().After(until) {
+		delete(l.asns.banned, o.ASN)
+		return false
+	}
+	return ok
+}
+
+// Returns the ASNs that are banned, with the end of their bans
+func (l *Limiter) BannedASNs() map[uint32]time.Time {
+	l.asns.Lock()
+	defer l.asns.Unlock()
+	now := time.Now()
+	bans := make(map[uint32]time.Time, len(l.asns.banned))
+	for asn, until := range l.asns.banned {
+		if now.Before(until) {
+			bans[asn] = until
+		}
+	}
+	return bans
+}
+
+// Lifts the ban of the ASN
+func (l *Limiter) UnbanASN(asn uint32) error {
+	l.asns.Lock()
+	defer l.asns.Unlock()
+	if _, ok := l.asns.banned[asn]; !ok {
+		return errors.New("asn is not banned")
+	}
+	delete(l.asns.banned, asn)
+	return nil
+}
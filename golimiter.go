@@ -1,6 +1,5 @@
 /* TO DO
 Write and perform proper tests
-Add ability to preferentialy treat certain vistors/ips (give them better rates)
 Add ability to add bad actors to blacklist/remove from whitelist on the go
 Refine metric used to define and  measure server load
 Handling of X-Forwarded-For or X-Real-IP headers
@@ -10,13 +9,15 @@ Reading white/blacklist from external sql or redis dbs
 package golimiter
 
 import (
-	"errors"
-	c "github.com/i-norden/golimiter/common"
+	"context"
+	"crypto/ed25519"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	c "github.com/i-norden/golimiter/common"
 	"golang.org/x/time/rate"
 )
 
@@ -24,45 +25,338 @@ type Limiter struct { // Limiter settings
 	sync.Mutex                 // Embedded mutex for syncing access to shared internal data
 	Rate       rate.Limit      // Default limiter rate
 	Burst      int             // Default limiter burst/bucket size
+	Windows    []WindowLimit   // Further limits enforced together with the default rate and burst (e.g. 300 per minute and 5000 per day)
 	params     []params        // Limiter params enforced at user defined thresholds
 	triggers   []*rate.Limiter // User defined limiters to monitor load and trigger state shift
 	Whitelist  struct {        // Whitelist settings
-		On         bool          // On or off (default false- off)
-		Filename   string        // File location
-		UpdateFreq time.Duration // Update frequency (how often it reads file to check for changes; in minutes)
-		quitChan   chan bool     // Channel used to stop the background goroutine
-		list       []string      // The whitelist as an array
+		On         bool                    // On or off (default false- off)
+		Filename   string                  // File location, or an http(s) URL fetched on every update
+		Format     string                  // File format: "lines", "json" or "yaml" (default by file extension)
+		UpdateFreq time.Duration           // Update frequency (how often it reads file to check for changes; in minutes)
+		Persist    bool                    // Keep runtime changes across reloads and write them back to the file (default false- off)
+		Level      int                     // Level assigned to whitelisted ips whose entries don't set one (see Levels)
+		list       atomic.Pointer[c.IPSet] // The whitelist, replaced as a whole on changes
+		reload     listReload              // Reload state of the whitelist
+		enabled    atomic.Bool             // Whether the whitelist is on, as switched at runtime by SetWhitelist
 	}
 	Blacklist struct { // Blacklist settings
-		On         bool          // On or off (default false- off)
-		Filename   string        // File location
-		UpdateFreq time.Duration // Update frequency (in minutes)
-		quitChan   chan bool     // Channel used to stop the background goroutine
-		list       []string      // The blacklist as an array
+		On         bool                    // On or off (default false- off)
+		Filename   string                  // File location, or an http(s) URL
+		Format     string                  // File format: "lines", "json" or "yaml" (default by file extension)
+		UpdateFreq time.Duration           // Update frequency (in minutes)
+		Persist    bool                    // Keep runtime changes across reloads and write them back to the file
+		list       atomic.Pointer[c.IPSet] // The blacklist, replaced as a whole on changes
+		reload     listReload              // Reload state of the blacklist
+		enabled    atomic.Bool             // Whether the blacklist is on, as switched at runtime by SetBlacklist
 	}
 	Cleanup struct { // Background cleanup process settings
-		Off      bool          // On or off (default false- on)
-		Thres    time.Duration // Time before visitor expires and is removed (in minutes)
-		Freq     time.Duration // Cleanup frequency (in minutes)
-		quitChan chan bool     // Channel used to stop the background goroutine
+		Off   bool          // On or off (default false- on)
+		Thres time.Duration // Time before visitor expires and is removed (in minutes)
+		Freq  time.Duration // Cleanup frequency (in minutes)
+		Batch int           // Visitors checked per hold of the lock, yielding in between (default 1000)
+		// Inactivity before visitors at each level are removed, instead of Thres
+		// Plans' TTLs take precedence over these
+		Levels map[int]time.Duration
+	}
+	Replicas struct { // Settings for sharing the limits across replicas of a service
+		Count      int                 // Number of replicas; each enforces 1/Count of the rates and bursts (default 1)
+		Discover   func() (int, error) // Optional func polled for the current replica count (e.g. from service discovery)
+		UpdateFreq time.Duration       // Discovery poll frequency (in minutes)
+	}
+	Responses struct { // Settings for responses to denied requests
+		LimitedStatus  int // Status for visitors over their own limit (default 429)
+		DegradedStatus int // Status for visitors denied only because of the load state (default 429; 503 for load-shedding semantics)
+		ListedStatus   int // Status for ips denied by the white/blacklists or bans (default 403)
+		// Handlers writing the responses to denials with each reason, instead of the
+		// status and a text body (e.g. a JSON error, or a page for blocked clients)
+		// The X-RateLimit-Reason and Retry-After headers are set before they are called
+		Handlers map[Reason]http.Handler
+		// Reasons whose denials are answered without a body, or not at all, instead
+		// (e.g. {ReasonBlacklisted: DropConn}), saving bandwidth spent on attackers
+		Drop map[Reason]DropMode
+	}
+	Backpressure struct { // Settings for signalling overload to reverse proxies and load balancers (e.g. for outlier detection), so they shift traffic away
+		On     bool   // On or off (default false- off)
+		From   int    // Index of the load state (as returned by CurrentState) from which responses signal overload (default 0, the first state)
+		Header string // Header set to "1" on every response while overloaded (default "X-Backend-Overloaded")
+		Status int    // Status of requests denied while overloaded, e.g. 503 for load balancers ejecting hosts on 5xx (default unchanged)
+	}
+	Tarpit struct { // Settings for holding denied requests/connections before responding
+		On            bool          // On or off (default false- off)
+		Delay         time.Duration // How long each denial is held (e.g. 10 * time.Second; default 10 seconds)
+		MaxConcurrent int           // Maximum denials held at once; any more are answered immediately (default 100)
+		slots         chan struct{} // Semaphore bounding the concurrent tarpits
+	}
+	SlowConns struct { // Settings for closing connections that drip-feed bytes (Slowloris), for LimitNetConn
+		HeaderTimeout time.Duration // Time a connection has to end its first headers with a blank line, as in HTTP (0- off)
+		MinRate       float64       // Bytes per second a connection must send in a window in which it sends at all (0- off)
+		Window        time.Duration // Window the rate is measured over (default 10 seconds)
+		Blacklist     bool          // Blacklist the ips of closed connections (needs Blacklist.On)
+		BanFor        time.Duration // How long closed connections' ips stay blacklisted (0- until removed)
+	}
+	AcceptGate struct { // Settings for dropping connections of ips over a connection-attempt rate as GateListener accepts them, before any TLS handshake
+		Rate   rate.Limit            // Connection attempts allowed per second per ip (0- off; blacklisted ips are dropped either way)
+		Burst  int                   // Connection attempts allowed at once per ip (default 1)
+		OnDrop func(ip, rule string) // Optional hook called with the ip and rule ("accept_rate", "blacklist", or LimitListener's "max_conns" or "ip_conns") of each dropped connection; keep it fast, it runs in Accept
+	}
+	CommandLimits struct { // Settings for the per-connection command limiters returned by Commands
+		Rate  rate.Limit     // Commands allowed per second on each connection, on top of its visitor's rate (0- off)
+		Burst int            // Commands allowed at once on each connection (default 1)
+		Costs map[string]int // Tokens charged for each command by name, e.g. {"RCPT": 1, "DATA": 5} (default 1)
+	}
+	Conns struct { // Settings for the connections LimitListener lets be open at once
+		Max   int // Connections open at once across all ips (0- no limit)
+		PerIP int // Connections open at once per ip; whitelisted ips are exempt (0- no limit)
+	}
+	Errors struct { // Settings for reducing the rate of visitors whose requests keep failing
+		On        bool    // On or off (default false- off)
+		Threshold float64 // Ratio of error (4xx/5xx) responses above which a visitor is penalized (default 0.5)
+		Factor    float64 // Factor a penalized visitor's rate and burst are multiplied by (default 0.1)
+		Weight    float64 // Weight of each response in a visitor's moving error ratio (default 0.05)
+	}
+	Failures struct { // Settings for penalizing the failed authentications reported with ReportFailure
+		Threshold float64       // Failure weight at which a key is blocked (default 5)
+		Block     time.Duration // How long the first block lasts; each further block doubles it, up to a day (default 1 minute)
+		BanAfter  int           // Blocks after which the key is also blacklisted, if it is an ip (0- never; needs Blacklist.On)
+		BanFor    time.Duration // How long a banned key stays blacklisted (0- until removed)
+		Forget    time.Duration // Time without failures after which a key's failures are forgotten (default 1 hour)
+	}
+	Hierarchy struct { // Settings for buckets shared by each subnet's ips and by all ips, which requests must pass on top of their ip's own
+		SubnetRate  rate.Limit // Rate of each subnet's bucket (0- off)
+		SubnetBurst int        // Burst of each subnet's bucket
+		IPv4Prefix  int        // Prefix length of IPv4 subnets (default 24)
+		IPv6Prefix  int        // Prefix length of IPv6 subnets (default 64)
+		GlobalRate  rate.Limit // Rate of the bucket shared by all ips (0- off)
+		GlobalBurst int        // Burst of the bucket shared by all ips
+	}
+	Elastic struct { // Settings for letting visitors over their own rate borrow the global bucket's spare capacity (needs Hierarchy.GlobalRate)
+		On     bool          // On or off (default false- off)
+		Cap    int           // Tokens each visitor can borrow per window (default 10)
+		Window time.Duration // Window the borrowed tokens are counted in (default 1 minute)
+	}
+	Idempotency struct { // Settings for charging retries carrying the same idempotency key only once
+		On     bool          // On or off (default false- off)
+		Header string        // Header carrying the key (default "Idempotency-Key")
+		Window time.Duration // Time after a key was charged in which its retries are free (default 1 minute)
+		Size   int           // Keys remembered per visitor, the least recently used are forgotten first (default 16)
+	}
+	Queue struct { // Settings for queueing requests over the global rate by priority instead of denying them (needs Hierarchy.GlobalRate)
+		On       bool                      // On or off (default false- off)
+		MaxWait  time.Duration             // Longest a request waits before it is denied (default 5 seconds)
+		Size     int                       // Requests queued at most; any more are denied (default 1000)
+		Priority func(r *http.Request) int // Optional; returns a request's priority, e.g. PriorityHeader("X-Priority")
+		Plans    map[string]int            // Priorities of identities' plans, if Priority is nil (default 0)
+		Weights  map[int]float64           // Share of the global rate each priority gets, relative to the others (default 1)
+	}
+	History struct { // Settings for keeping each visitor's allowed and denied requests per minute, for trends (off by default)
+		Minutes    int           // Minutes kept per visitor besides the current one, see VisitorHistory (0- off)
+		SpikeRatio float64       // Ban visitors whose requests in the current minute reach this multiple of their mean minute (0- off; needs Blacklist.On)
+		SpikeMin   int           // Requests in a minute below which a visitor is never banned for spiking (default 60)
+		SpikeBan   time.Duration // How long a spiking visitor stays blacklisted (0- until removed; needs Blacklist.On)
+	}
+	Leaky struct { // Settings for pacing WaitKey callers at an exact steady rate (leaky bucket) instead of letting bursts through
+		On       bool // On or off (default false- off)
+		MaxQueue int  // Callers that can wait per key; any more get ErrQueueFull (0- unbounded)
+	}
+	WarmUp struct { // Settings for ramping the rates up after startup, protecting cold downstreams from reconnecting clients
+		Window        time.Duration // Time over which the rates and bursts ramp up to 100% (0- off)
+		From          float64       // Fraction of the rates and bursts allowed at the start (default 0.1)
+		AfterRecovery bool          // Also ramp up again when the limiter returns from a load state to the default rates
+	}
+	InitialTokens struct { // Settings for how full new visitors' buckets start, so rotating ips gets no free bursts (default full)
+		On       bool    // On or off (default false- off)
+		Count    int     // Tokens new visitors start with, if Fraction is 0 (e.g. 0 for an empty bucket)
+		Fraction float64 // Fraction of the burst new visitors start with (e.g. 0.5)
+	}
+	Hints struct { // Settings for keying clients behind shared NATs by their ip and a client hint (off by default)
+		Func  HintFunc // Returns the request's client hint, e.g. HeaderHint or Fingerprints.Hint; requests without one are keyed by ip (nil- off)
+		PerIP int      // Hints an ip can be keyed under at once, so rotating hints gets no fresh buckets (0- unlimited)
+	}
+	// Optional; connections it identifies are limited in LimitNetConn under their identity instead of their ip
+	ConnKeyFunc ConnKeyFunc
+	Fingerprint struct { // Settings for keying and blocking connections in LimitNetConn by their client's TLS fingerprint
+		Func      ConnFingerprint // Returns a connection's fingerprint, e.g. Fingerprints.Conn (nil- off)
+		Handshake time.Duration   // Timeout of the TLS handshake completed before limiting, so the fingerprint is captured (0- off)
+		Key       bool            // Limit connections by ip and fingerprint, counted against Hints.PerIP, instead of by ip alone
+		Blocked   []string        // Fingerprints of known bots, whose connections are denied whatever their ip (set before Init)
+	}
+	ProxyProtocol struct { // Settings for reading the PROXY protocol (v1 or v2) header load balancers in TCP mode (e.g. HAProxy, AWS NLB) send ahead of each connection
+		On      bool          // Key connections in LimitNetConn and ProxyListener on the client address from the header (default false- off)
+		Trusted []string      // Cidrs of the load balancers; headers of connections from other addresses are not read (default all, set before Init)
+		Timeout time.Duration // Time allowed for the header to arrive (default 5 seconds)
+		nets    []*net.IPNet  // Parsed Trusted cidrs
+	}
+	Classes struct { // Settings for shedding endpoint classes in order as the load state escalates (off unless Routes are set)
+		Routes []RouteClass   // Classes of the endpoints; the first matching route wins, unmatched requests are ClassNormal
+		From   map[string]int // Index of the first state each class is throttled in (default expensive 0, normal 1, critical the last)
+	}
+	Coalesce struct { // Settings for serving identical GETs denied by the load state a shared response instead (off by default)
+		TTL      time.Duration // Time the response let through for a uri is served to the identical requests that follow (0- off)
+		Size     int           // Responses cached at once (default 100)
+		MaxBytes int64         // Largest response body shared (default 1 MiB)
+	}
+	Geo struct { // Settings for the network origin of ips in stats, events and the audit log, and ASN bans (off unless GeoIP is set)
+		GeoIP      GeoIP         // Resolves the country and ASN of ips, e.g. a GeoTable (nil- off)
+		BanDenials int           // Denials of an ASN's ips within BanWindow after which the whole ASN is banned (0- off)
+		BanWindow  time.Duration // Window the denials of an ASN are counted in (default 1 minute)
+		BanFor     time.Duration // Length of ASN bans (default 1 hour)
+	}
+	Reputation struct { // Settings for scoring visitors (0-100) by their denials, error ratio, lists and age (off by default)
+		On        bool             // On or off (default false- off)
+		PerDenial float64          // Points a visitor loses for each denial (default 10)
+		HalfLife  time.Duration    // Time in which lost points are halved (default 10 minutes)
+		Maturity  time.Duration    // Age at which visitors stop gaining points for it (default 1 hour)
+		Tiers     []ReputationTier // Multipliers of the rate and burst of visitors below a score; the lowest tier below it applies
+	}
+	SoftLimit struct { // Settings for warning visitors nearing their limit before they are denied
+		Threshold float64 // Fraction of the burst (or of an API key's quota) used at which a warning header and event are given, e.g. 0.8 (0- off)
+	}
+	Methods struct { // Settings for separate read and write buckets per visitor, by HTTP method (off by default)
+		On    bool // On or off (default false- off)
+		Read  Plan // Bucket of GET, HEAD and OPTIONS requests (default the visitor's own bucket, with its plans and states)
+		Write Plan // Bucket of every other method, e.g. stricter for POST, PUT and DELETE (default Rate and Burst)
+	}
+	// Bring existing visitors' limiters up to date with changed params (e.g. Rate set
+	// directly, or new states) on their next event, keeping their usage, instead of
+	// only new visitors using them; SetRate, SetBurst and SetPlan always update them
+	ApplyToExisting bool
+	// What is done with requests the limiter fails to decide, e.g. because its store
+	// is down or they have no client address: ErrorLocal (default), ErrorAllow or ErrorDeny
+	OnInternalError ErrorPolicy
+	Challenge       struct { // Settings for challenging visitors over their limit before blocking them
+		Challenger  Challenger    // Issues and verifies challenges (nil- off)
+		MaxFailures int           // Challenges an ip can be issued per Window before it is hard-blocked (default 3)
+		Window      time.Duration // Window an ip's challenges are counted over (default 1 hour)
+	}
+	KeyFunc    KeyFunc         // Optional; requests it identifies are limited under their identity instead of their ip
+	Plans      map[string]Plan // Named rate plans identities can be limited by
+	Policies   map[string]Plan // Rates and bursts of the named policies returned by Policy
+	Levels     map[int]float64 // Multipliers of the default rate and burst of visitors at each level (e.g. 10 for 10x)
+	Dimensions []Dimension     // Additional limits, all of which a request must pass (set before Init)
+	Keys       *KeyRegistry    // Optional registry of API keys with individual limits
+	Cost       struct {        // Settings for charging requests by size instead of one token each
+		Mode          CostMode // CostRequests (default), CostRequestBytes or CostResponseBytes
+		BytesPerToken int64    // Bytes charged as one token (default 1024)
+	}
+	Schedule struct { // Settings for varying the default rate by time of day/calendar
+		Windows  []Window       // Windows replacing Rate and Burst while active; the first active one wins
+		Location *time.Location // Time zone the windows are evaluated in (default time.Local)
+	}
+	Streams struct { // Settings for bounding the requests each connection has in flight
+		MaxPerConn int // Concurrent requests (HTTP/2 streams) allowed per connection; needs ConnContext set on the http.Server (0- off)
+	}
+	Admission struct { // Settings for the pre-filter that admits unknown ips as visitors (off by default)
+		Threshold int           // Times an ip must be seen within the window before it gets a visitor (0- off)
+		Window    time.Duration // Window the sightings are counted in (default 10 seconds)
+		Width     int           // Counters per row of the count-min sketch (default 4096)
+		Depth     int           // Rows of the count-min sketch (default 4)
+	}
+	Breaker struct { // Settings for the circuit breaker on store failures
+		Failures int                        // Consecutive store failures that open the breaker (default 5)
+		Cooldown time.Duration              // Time the breaker stays open before the store is probed (default 10 seconds)
+		Fallback StoreFallback              // What decides while the store is unavailable (default FallbackLocal, which defers to OnInternalError)
+		OnChange func(open bool, err error) // Optional hook called when the breaker opens or closes
+	}
+	ListReload struct { // Settings for lists whose reloads fail; the last good list keeps being used
+		Failures     int           // Consecutive failed reloads after which the list (and health) is degraded (default 3)
+		MaxStaleness time.Duration // Time since the last good reload after which the StalePolicy applies (0- never)
+		StalePolicy  StalePolicy   // StaleKeep (default), StaleOpen or StaleClosed
+		Watch        time.Duration // How often local list files are checked for changes, reloading them at once; follows ConfigMap symlink swaps and atomic renames (0- off)
+	}
+	ListFetch struct { // Settings for lists whose Filename is an http(s) URL
+		Timeout time.Duration // Timeout of each fetch (default 10 seconds)
+		Retries int           // Retries of a failed fetch, with exponential backoff from 1 second (default 2)
+		Client  *http.Client  // Optional client used instead of one with the Timeout
 	}
+	ListSigning struct { // Settings for verifying list files before they are applied (off unless a key is set)
+		Secret    []byte            // HMAC-SHA256 key for embedded or detached (<file>.sig) MACs
+		PublicKey ed25519.PublicKey // Key for detached (<file>.sig) ed25519 signatures
+	}
+	Audit      *AuditLog           // Optional log recording every denial, and a sample of allowed events
+	Store      Store               // Optional shared store; when set, limits are enforced across all limiters using it
+	Rollout    RolloutProvider     // Optional; decides per key whether denials are enforced or shadowed in Enforce mode, e.g. by a feature flag
+	DenyCache  bool                // Cache denials until their retry time, so hot keys skip the limiters and store until then
+	OnEvent    func(e Event)       // Optional hook called with noteworthy events (e.g. for alerting); set before Init, or use SetOnEvent
+	OnAllow    func(key string)    // Optional hook called with the visitor key after each allowed event; set before Init, or use SetOnAllow
 	visitors   map[string]*visitor // Map to hold the visitor structs for each ip
+	expiries   expiryHeap          // Keys of the visitors, by the time cleanup checks them
+	overrideAt expiryHeap          // Keys of the overrides, by the time they expire
+	failureAt  expiryHeap          // Keys of the failure records, by the time they are forgotten
+	retryAt    expiryHeap          // Visitor keys of the idempotency keys, by the time they leave the window
+	breaker    breaker             // Circuit breaker state for the store
+	remote     remoteLists         // Last fetched copies of remote list files
+	listMu     sync.Mutex          // Serializes changes to the white/blacklists
+	changes    listChanges         // Runtime changes of persisted lists not yet reflected by their files
+	sketch     *sketch             // Counts sightings of unknown ips for the admission pre-filter
+	levels     map[string]int      // Levels assigned to visitor keys
+	overrides  map[string]override // Temporary limits assigned to visitor keys by SetVisitorLimit
+	failures   failureLog          // Failed authentications reported by key
+	retries    retryLog            // Idempotency keys recently charged, by visitor key
+	hinted     map[string]int      // Visitors keyed by a client hint, by ip
+	botPrints  map[string]bool     // Set of the blocked fingerprints
+	dimDenials []uint64            // Requests each dimension was the first to deny
+	routes     []route             // Parsed Match patterns of the dimensions
+	classes    []classRoute        // Parsed routes of the endpoint classes
+	flights    coalescer           // Responses shared by identical GETs, if Coalesce is on
+	namespaces namespaceMap        // Namespaces by name
+	warned     map[string]bool     // Keys warned for nearing their limit, until they are below it again
+	asns       asnBans             // Denials and bans of ASNs, if Geo.BanDenials is set
+	failed     failOutcomes        // Outcomes of requests the limiter failed to decide
+	acceptGate acceptGate          // Connection-attempt buckets of GateListener
+	conns      connCounts          // Connections open through LimitListener
+	windows    []window            // Parsed schedule windows
+	window     int                 // Index of the active schedule window, -1 if none
+	winMinute  int64               // Minute the active window was last evaluated at
+	curve      []Breakpoint        // Load curve used instead of the states, if set
+	capacity   float64             // Load considered 100% by the curve
+	meter      loadMeter           // Measures the load for the curve
+	curRate    rate.Limit          // Rate at the current point on the curve
+	curBurst   int                 // Burst at the current point on the curve
+	cancel     context.CancelFunc  // Stops the background processes
+	ctx        context.Context     // Context of the background processes, for those started after Init
+	hooks      hooks               // Hooks set at runtime with SetOnEvent and SetOnAllow
+	queue      fairQueue           // Requests waiting for the global bucket, if Queue is on
+	warmFrom   time.Time           // When the current warm-up started, zero if not warming up
+	monitor    monitor             // Records background process runs and store calls for Health
+	mode       int32               // Mode the limiter is operating in (see SetMode), accessed atomically
+	shadowed   uint64              // Denials let through in shadow mode, accessed atomically
+	allowed    uint64              // Events allowed, accessed atomically
+	denied     uint64              // Events denied, accessed atomically
 	useDefault bool                // Bool indicating whether or not to use default params
 	state      int                 // State variable for the limiter
 }
 
 // Class of visitor with limiter settings for default and user defined load conditions
 type visitor struct {
+	key      string          // Key the visitor is stored under
 	limiter  *rate.Limiter   // Limiter used under default conditions
 	limiters []*rate.Limiter // Limiters used under variable load conditions
 	lastSeen time.Time       // Used to know when to clear from list
+	seen     uint64          // Times the visitor was seen, for TopVisitors
+	plan     string          // Rate plan used instead of the default Rate and Burst, if set
+	fixed    *params         // Params used instead of any others, for dimension keys
 	level    int             // Used to treating visitors differently
+	failures int             // Challenges issued in the current challenge window
+	chalAt   time.Time       // Start of the visitor's challenge window
+	errRatio float64         // Moving average of the visitor's error responses, if Errors is on
+	penalty  bool            // Whether the visitor's rate is reduced for its errors
+	borrowed int             // Tokens borrowed from the global bucket in the current window, if Elastic is on
+	borrowAt time.Time       // Start of the visitor's borrowing window
+	hintIP   string          // Ip the visitor's client hint is counted against, if keyed by one
+	ns       *Namespace      // Namespace the visitor is in, whose plans it uses, if any
+	rep      reputation      // Reputation of the visitor, if Reputation is on
+	windows  []*rate.Limiter // Limiters of the visitor's windows (Windows, or its plan's)
+	drip     leakyQueue      // Slots of the visitor's WaitKey callers, if Leaky is on
+	hist     history         // Requests of the visitor per minute, if History is on
+	// Denial cached until its retry time, if DenyCache is set; accessed atomically
+	denied atomic.Pointer[cachedDenial]
 }
 
 // Params for a rate.Limiter
 type params struct {
-	rate  rate.Limit
-	burst int
+	rate   rate.Limit
+	burst  int
+	exempt []int // Visitor levels that keep the default params
 }
 
 //Initialization function for exported limiter object
@@ -72,48 +366,67 @@ type params struct {
 //  - Cleanup turned on at a freq and thres of 3 minutes
 //  - Rate of 1 per second
 //  - Bucket size (max burst) of 5
+//Returns an *ErrInvalidConfig listing every problem if the parameters are invalid
 func (l *Limiter) Init() (err error) {
+	return l.InitContext(context.Background())
+}
+
+// Initializes the limiter like Init, with background processes
+// that stop when the context is done or Stop is called
+func (l *Limiter) InitContext(ctx context.Context) (err error) {
+	lists := l.readLists() // Read (or fetched) once, before taking the lock
 	l.Lock()
 	defer l.Unlock()
-	if l.Whitelist.On { // If using whitelist, read in list and initialize update process
-		if l.Whitelist.Filename == "" { // Return error if no file path is given
-			err = errors.New("Whitelist configuration file path is not set")
-			return
-		}
-		_, err = c.ReadList(l.Whitelist.Filename)
-		if err != nil { // Return error if list can't be read in
-			return
-		}
+	if err = l.validate(lists); err != nil { // Return every problem with the settings at once
+		return
+	}
+	if err = l.parseSchedule(); err != nil {
+		return
+	}
+	ctx, l.cancel = context.WithCancel(ctx)
+
+	l.ctx = ctx
+
+	if l.Whitelist.On { // If using whitelist, initialize update process
 		if l.Whitelist.UpdateFreq == 0 {
 			l.Whitelist.UpdateFreq = 3 // Use default freq if none provided
 		}
-		var qWL chan bool
-		go l.updateWhitelist(qWL)
-		l.Whitelist.quitChan = qWL
+		l.Whitelist.reload.running = true
+		l.Whitelist.reload.first = lists.white
+		go l.updateWhitelist(ctx)
 	}
+	l.Whitelist.enabled.Store(l.Whitelist.On)
 
-	if l.Blacklist.On { // If using blacklist, read in list and initialize update process
-		if l.Blacklist.Filename == "" { // Return error if no file path is given
-			if l.Whitelist.On {
-				l.Whitelist.On = false
-				l.Whitelist.quitChan <- true // and shut down whitelist process if it exists
-			}
-			return errors.New("Blacklist configuration file path is not set")
-		}
-		_, err = c.ReadList(l.Blacklist.Filename)
-		if err != nil { // Return error if list can't be read in
-			if l.Whitelist.On {
-				l.Whitelist.On = false
-				l.Whitelist.quitChan <- true // and shut down whitelist process if it exists
-			}
-			return
-		}
+	if l.Blacklist.On { // If using blacklist, initialize update process
 		if l.Blacklist.UpdateFreq == 0 {
 			l.Blacklist.UpdateFreq = 3 // Use default freq if none provided
 		}
-		var qBL chan bool
-		go l.updateBlacklist(qBL)
-		l.Blacklist.quitChan = qBL
+		l.Blacklist.reload.running = true
+		l.Blacklist.reload.first = lists.black
+		go l.updateBlacklist(ctx)
+	}
+	l.Blacklist.enabled.Store(l.Blacklist.On)
+
+	if l.Queue.On { // If queueing, start the dispatcher serving the queue
+		l.queue.wake = make(chan struct{}, 1)
+		go l.serveQueue(ctx)
+	}
+
+	if l.Replicas.Discover != nil { // If discovering replicas, initialize update process
+		if l.Replicas.UpdateFreq == 0 {
+			l.Replicas.UpdateFreq = 1 // Use default freq if none provided
+		}
+		go l.updateReplicas(ctx)
+	}
+
+	if l.Tarpit.On { // If tarpitting, set up the bound on concurrent tarpits
+		if l.Tarpit.Delay == 0 {
+			l.Tarpit.Delay = 10 * time.Second // Use default delay if none provided
+		}
+		if l.Tarpit.MaxConcurrent == 0 {
+			l.Tarpit.MaxConcurrent = 100 // Use default bound if none provided
+		}
+		l.Tarpit.slots = make(chan struct{}, l.Tarpit.MaxConcurrent)
 	}
 
 	if !l.Cleanup.Off { // Visitor cleanup is on by default
@@ -123,9 +436,30 @@ func (l *Limiter) Init() (err error) {
 		if l.Cleanup.Thres == 0 {
 			l.Cleanup.Thres = 3 // Use default thres if none provided
 		}
-		var qCU chan bool
-		go l.cleanupVisitors(qCU)
-		l.Cleanup.quitChan = qCU
+		l.monitor.ran("cleanup", l.Cleanup.Freq*time.Minute, nil)
+		go l.cleanupVisitors(ctx)
+	}
+
+	if l.Admission.Threshold > 0 { // If pre-filtering unknown ips, set up the sketch
+		if l.Admission.Window == 0 {
+			l.Admission.Window = 10 * time.Second // Use default window if none provided
+		}
+		if l.Admission.Width == 0 {
+			l.Admission.Width = 4096 // Use default width if none provided
+		}
+		if l.Admission.Depth == 0 {
+			l.Admission.Depth = 4 // Use default depth if none provided
+		}
+		l.sketch = newSketch(l.Admission.Width, l.Admission.Depth, l.Admission.Window)
+	}
+
+	if l.Store != nil { // If using a store, set up its circuit breaker
+		if l.Breaker.Failures == 0 {
+			l.Breaker.Failures = 5 // Use default failures if none provided
+		}
+		if l.Breaker.Cooldown == 0 {
+			l.Breaker.Cooldown = 10 * time.Second // Use default cooldown if none provided
+		}
 	}
 
 	if l.Rate == 0 {
@@ -136,11 +470,37 @@ func (l *Limiter) Init() (err error) {
 		l.Burst = 5 // Use default burst if none provided
 	}
 
+	l.dimDenials = make([]uint64, len(l.Dimensions))
+	l.routes = make([]route, len(l.Dimensions))
+	for i, dim := range l.Dimensions {
+		l.routes[i], _ = parseRoute(dim.Match) // Validated above
+	}
+
+	l.botPrints = make(map[string]bool, len(l.Fingerprint.Blocked))
+	for _, fp := range l.Fingerprint.Blocked {
+		l.botPrints[fp] = true
+	}
+
+	l.ProxyProtocol.nets = make([]*net.IPNet, 0, len(l.ProxyProtocol.Trusted))
+	for _, cidr := range l.ProxyProtocol.Trusted {
+		_, n, _ := net.ParseCIDR(cidr) // Validated above
+		l.ProxyProtocol.nets = append(l.ProxyProtocol.nets, n)
+	}
+
+	l.classes = make([]classRoute, len(l.Classes.Routes))
+	for i, rc := range l.Classes.Routes {
+		rt, _ := parseRoute(rc.Match) // Validated above
+		l.classes[i] = classRoute{route: rt, class: rc.Class}
+	}
+
 	if l.visitors == nil { // Initialize visitors map if none exists
 		l.visitors = make(map[string]*visitor)
 	}
 
 	l.useDefault = true
+	if l.WarmUp.Window > 0 { // Start warming up
+		l.warmFrom = time.Now()
+	}
 	return
 }
 
@@ -148,41 +508,166 @@ func (l *Limiter) Init() (err error) {
 // to check each incoming request's IP against their
 // limiter, and optionally against an IP whitelist and/or blacklist
 func (l *Limiter) LimitHTTPHandler(next http.Handler) http.Handler {
+	return l.limitHTTP(next, "", nil)
+}
+
+// Returns the middleware, limiting visitors by the named policy's buckets if one
+// is given, and in the namespace if one is given
+func (l *Limiter) limitHTTP(next http.Handler, policy string, ns *Namespace) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Bypass the limiter entirely if it is switched off
+		mode := l.Mode()
+		if mode == AllowAll {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Panics raised while deciding the request (e.g. by a KeyFunc) are recovered
+		// and the request is handled by the OnInternalError policy instead
+		deciding := true
+		defer l.recoverRequest(w, r, next, &deciding)
+		next := decided(next, &deciding)
 		// First update the state of the limiter
 		l.updateState()
-		// If whitelist flag is set, check if incoming ip is on whitelist
-		if l.Whitelist.On {
-			l.Lock()
-			in, _ := c.InArray(l.Whitelist.list, r.RemoteAddr)
-			l.Unlock()
-			// If not on whitelist return 401 status
+		// In high load states responses tell the load balancer to shift traffic away
+		l.signalOverload(w)
+		// Get remote ip from the request, without its port
+		ip := RemoteIP(r.RemoteAddr)
+		if ip == "" { // Requests that can't be told apart are left to the failure policy
+			l.failRequest(w, r, next, ip, errNoClient)
+			return
+		}
+		// If whitelist flag is set, or in maintenance, check if incoming ip is on whitelist
+		if l.whitelistOn() || mode == DenyAll {
+			in := l.whitelisted(ip)
+			// If not on whitelist return 403 status (503 in maintenance)
 			if !in {
-				http.Error(w, http.StatusText(401), http.StatusUnauthorized)
-				return
+				status, rule := l.listedStatus(), "whitelist"
+				if mode == DenyAll {
+					status, rule = http.StatusServiceUnavailable, "maintenance"
+				}
+				if l.deny(w, r, ip, rule, status, 0) {
+					return
+				}
 			}
 		}
 		// If blacklist flag is set, check if incoming ip is on blacklist
-		if l.Blacklist.On {
-			l.Lock()
-			in, _ := c.InArray(l.Blacklist.list, r.RemoteAddr)
-			l.Unlock()
-			// If on blacklist return 401 status
-			if in {
-				http.Error(w, http.StatusText(401), http.StatusUnauthorized)
+		if l.blacklistOn() {
+			in := l.blacklisted(ip)
+			// If on blacklist return 403 status
+			if in && l.deny(w, r, ip, "blacklist", l.listedStatus(), 0) {
 				return
 			}
 		}
-		// Call the getVisitor method to create or retreive
-		// the visitor struct with the limiters for the current user.
-		visitor := l.getVisitor(r.RemoteAddr)
-		// If they have exceeded their limit at the current state, return 429 status
-		if !l.allow(visitor) {
-			http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
+		// Ips of networks (ASNs) banned for their denials are rejected too
+		if l.asnBanned(ip) && l.deny(w, r, ip, "asn", l.listedStatus(), 0) {
 			return
 		}
+		// Namespaces have lists of their own
+		if ns != nil {
+			if rule := ns.listed(ip); rule != "" && l.deny(w, r, ip, rule, l.listedStatus(), 0) {
+				return
+			}
+		}
+		// Connections can only have so many requests (HTTP/2 streams) in flight
+		release, ok := l.acquireStream(r)
+		defer release()
+		if !ok && l.deny(w, r, ip, "streams", http.StatusTooManyRequests, 0) {
+			return
+		}
+		// Requests carrying an API key are limited by the key's limits, and
+		// like any other request by every dimension's
+		if l.Keys != nil && policy == "" && ns == nil {
+			if key := l.Keys.requestKey(r); key != "" {
+				l.limitAPIKey(w, r, next, key)
+				return
+			}
+		}
+		// Identified visitors are limited under their identity's key and plan,
+		// visitors that have passed a challenge under their own key
+		key, plan, verified := l.identify(r)
+		if ns != nil {
+			key = ns.key(key)
+		}
+		cost := l.requestCost(r)
+		// Retries of a request already charged within the window are not charged again
+		idem, chargeKey := l.idempotencyKey(r), key
+		if policy != "" {
+			chargeKey = policyKey(policy, key)
+		}
+		repeat := idem != "" && l.repeated(chargeKey, idem)
+		if repeat {
+			cost = 0
+		}
+		// The request must pass every dimension's limit; their tokens are given
+		// back if the request's other limits then deny it
+		dd, dims, dimsBack := l.allowDimensions(r)
+		setDimensionHeaders(w, dims)
+		if !dd.Allowed && l.deny(w, r, key, deniedDimension(dims), http.StatusTooManyRequests, dd.RetryAfter) {
+			return
+		}
+		// The request must pass its subnet's and the global bucket, so ips
+		// spread across a subnet are limited collectively; with the queue on,
+		// requests over the global rate wait their turn by priority instead
+		d, rule, giveBack := l.reserveQueued(r, ip, plan, cost)
+		if !d.Allowed {
+			dimsBack()
+			if l.deny(w, r, key, rule, http.StatusTooManyRequests, d.RetryAfter) {
+				return
+			}
+		}
+		d = Decision{Allowed: true}
+		// Unknown ips are let through by the admission pre-filter until they
+		// have been seen often enough to be given a visitor
+		if verified || l.admit(key, cost) {
+			// Call the getVisitor method to create or retreive
+			// the visitor struct with the limiters for the current user.
+			visitor, rule := l.requestVisitor(r, key, plan, policy)
+			// If they have exceeded their limit at the current state, return
+			// 429 status (or the configured statuses)
+			// Endpoint classes the load state does not yet apply to keep the default rate
+			d = l.allowClassN(visitor, cost, l.sheltered(r))
+			// Visitors over their own rate can borrow the global bucket's spare capacity
+			if !d.Allowed && l.borrow(visitor, cost, d) {
+				d = Decision{Allowed: true, Borrowed: true}
+			}
+			if !d.Allowed {
+				giveBack()
+				dimsBack()
+				// Unless they can still be challenged instead of hard-blocked
+				if !verified && mode == Enforce && l.challenge(w, r, visitor) {
+					return
+				}
+				// Or, if only the load state denied them, served a shared response
+				if d.Degraded && mode == Enforce && l.coalesce(w, r, next, key) {
+					return
+				}
+				if d.Degraded {
+					rule = "state" // Only the load state denied them
+				}
+				if l.deny(w, r, key, rule, l.deniedStatus(d), d.RetryAfter) {
+					return
+				}
+			}
+		}
+		d.Dimensions, d.Key = dims, key
+		l.warnSoftLimit(w, key, d.Warning)
+		l.notifyAllow(key)
+		if idem != "" && !repeat {
+			l.remember(chargeKey, idem)
+		}
+		// Visitors whose requests keep failing have their rate reduced
+		if l.Errors.On && !checking(w) { // Check never sees the response's status
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			defer func() { l.observeStatus(key, sw.status) }()
+			w = sw
+		}
 		// If they pass all limits, call the downstream handler function
-		next.ServeHTTP(w, r)
+		// with the decision in the request's context
+		if l.Cost.Mode == CostResponseBytes {
+			l.serveCharged(w, r.WithContext(NewContext(r.Context(), d)), next, key)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), d)))
 	})
 }
 
@@ -194,84 +679,435 @@ func (l *Limiter) LimitHTTPFunc(nextFunc func(http.ResponseWriter, *http.Request
 // Limiter middleware method for lower level net connections
 // Both the accepted conn and your downstream handler need to be passed
 func (l *Limiter) LimitNetConn(conn net.Conn, connHandler func(net.Conn)) {
+	l.limitConn(conn, nil, connHandler)
+}
+
+// Like LimitNetConn, but limits the connection under the identity instead of
+// its ip (or ConnKeyFunc's identity), for protocols that authenticate early
+// (e.g. after SMTP AUTH, or by SSH user) so accounts are limited wherever
+// they connect from; white/blacklists are still checked by ip
+func (l *Limiter) LimitNetConnAs(conn net.Conn, id Identity, connHandler func(net.Conn)) {
+	l.limitConn(conn, &id, connHandler)
+}
+
+// Limits the connection under the identity if set, else ConnKeyFunc's, else its ip
+func (l *Limiter) limitConn(conn net.Conn, id *Identity, connHandler func(net.Conn)) {
+	// Bypass the limiter entirely if it is switched off
+	mode := l.Mode()
+	if mode == AllowAll {
+		connHandler(conn)
+		return
+	}
 	// First update the state of the limiter
 	l.updateState()
-	// Get remote ip from connection
-	addr := conn.RemoteAddr()
-	ip := addr.String()
-	// If whitelist flag is set, check if incoming ip is on whitelist
-	if l.Whitelist.On {
-		l.Lock()
-		in, _ := c.InArray(l.Whitelist.list, ip)
-		l.Unlock()
+	// Behind a load balancer in TCP mode, the client's address is read
+	// from the PROXY protocol header ahead of the connection's data
+	conn = l.proxyConn(conn)
+	// Get remote ip from connection, without its port
+	ip := RemoteIP(conn.RemoteAddr().String())
+	if pc, ok := conn.(*proxyConn); ok && pc.err != nil {
+		conn.Close()
+		return
+	}
+	// If whitelist flag is set, or in maintenance, check if incoming ip is on whitelist
+	if l.whitelistOn() || mode == DenyAll {
+		in := l.whitelisted(ip)
 		// If not on whitelist close the connection and return
-		if !in {
-			conn.Close()
+		if !in && l.denyConn(conn, ip, "whitelist") {
 			return
 		}
 	}
 	// If blacklist flag is set, check if incoming ip is on blacklist
-	if l.Blacklist.On {
-		l.Lock()
-		in, _ := c.InArray(l.Blacklist.list, ip)
-		l.Unlock()
+	if l.blacklistOn() {
+		in := l.blacklisted(ip)
 		// If on blacklist close the connection and return
-		if in {
-			conn.Close()
+		if in && l.denyConn(conn, ip, "blacklist") {
 			return
 		}
 	}
-	// Call the getVisitor method to create or retreive
-	// the visitor struct with the limiters for the current user.
-	visitor := l.getVisitor(ip)
-	// If they have exceeded their limit at the current state,
-	// close the connection and return
-	if !l.allow(visitor) {
+	// Ips of networks (ASNs) banned for their denials are closed too
+	if l.asnBanned(ip) && l.denyConn(conn, ip, "asn") {
+		return
+	}
+	// TLS connections can be keyed by their client's fingerprint, and
+	// known bot fingerprints are denied whatever their ip
+	if !l.handshake(conn) {
 		conn.Close()
 		return
 	}
+	key, blocked := l.connKey(conn, ip)
+	if blocked && l.denyConn(conn, ip, "fingerprint") {
+		return
+	}
+	// Identified connections are limited under their identity's key and plan
+	if id == nil && l.ConnKeyFunc != nil {
+		if cid, ok := l.ConnKeyFunc(conn); ok {
+			id = &cid
+		}
+	}
+	plan, verified := "", false
+	if id != nil && id.Key != "" {
+		key, plan, verified = id.Key, id.Plan, true
+	}
+	// The connection must pass its subnet's and the global bucket
+	hd, rule, giveBack := l.reserveHierarchy(ip, 1, true)
+	if !hd.Allowed && l.denyConn(conn, ip, rule) {
+		return
+	}
+	// Unknown ips are let through by the admission pre-filter until they
+	// have been seen often enough to be given a visitor
+	if verified || l.admit(key, 1) {
+		// Call the getVisitor method to create or retreive
+		// the visitor struct with the limiters for the current user.
+		visitor := l.getPlanVisitor(key, plan)
+		// If they have exceeded their limit at the current state,
+		// close the connection and return
+		if d := l.allow(visitor); !d.Allowed && !l.borrow(visitor, 1, d) {
+			giveBack()
+			rule := "rate"
+			if d.Degraded {
+				rule = "state"
+			}
+			if l.denyConn(conn, key, rule) {
+				return
+			}
+		}
+	}
+	l.notifyAllow(key)
+	// Connections that drip-feed bytes are closed
+	conn, stop := l.watchConn(conn, ip)
+	defer stop()
 	// If they pass all limits, pass the connection to the handler func
 	connHandler(conn)
 }
 
-// Creates a load threshold using the given limit that triggers
-// the transition to a new limiter state that uses the given
-// vRate and vBurst instead of Limiter.Rate and Limiter.Burst
-// When multiple state are triggered the highest order state becomes active
-func (l *Limiter) AddState(order int, limit int, vRate rate.Limit, vBurst int) {
-	sRate := rate.Limit(limit)
-	l.triggers[order] = rate.NewLimiter(sRate, limit)
-	l.params[order] = params{rate: vRate, burst: vBurst}
+// Checks whether or not the visitor identified by key is allowed a single
+// event at the current limiter state, for use outside of the middleware
+// (e.g. in worker pools or to throttle outbound clients)
+// The key can be any string; white/blacklists are not consulted
+func (l *Limiter) AllowKey(key string) bool {
+	switch l.Mode() {
+	case AllowAll:
+		return true
+	case DenyAll:
+		return false
+	}
+	l.updateState()
+	if !l.allow(l.getVisitor(key)).Allowed && !l.shadows(key, nil) {
+		atomic.AddUint64(&l.denied, 1)
+		return false
+	}
+	l.notifyAllow(key)
+	return true
+}
+
+// Checks whether or not the visitor identified by key is allowed n events
+// at once at the current limiter state; like AllowKey otherwise
+func (l *Limiter) AllowN(key string, n int) bool {
+	return l.CheckN(key, n).Allowed
 }
 
-// Update state variable based on limiters global limiter states
-// Depending on the state
-func (l *Limiter) updateState() {
-	l.Lock()
-	l.useDefault = true
-	for i, t := range l.triggers {
-		if t.Allow() == false {
-			l.state = i
-			l.useDefault = false
+// Checks n events for the visitor identified by key like AllowN, returning
+// the decision (e.g. the tokens remaining in its bucket) instead of a bool
+func (l *Limiter) CheckN(key string, n int) Decision {
+	switch l.Mode() {
+	case AllowAll:
+		return Decision{Allowed: true}
+	case DenyAll:
+		return Decision{Allowed: false}
+	}
+	l.updateState()
+	d := l.allowN(l.getVisitor(key), n)
+	if !d.Allowed {
+		if !l.shadows(key, nil) {
+			atomic.AddUint64(&l.denied, 1)
+			return d
+		}
+		d = Decision{Allowed: true} // Shadowed denials are let through
+	}
+	l.notifyAllow(key)
+	return d
+}
+
+// Checks a single event for each of the keys (e.g. the messages of a
+// fetched batch) with one lock acquisition instead of one per key
+// The decisions are returned in the order of the keys; keys appearing
+// several times are charged once per appearance
+// With a Store the keys are checked against it one at a time
+func (l *Limiter) AllowBatch(keys []string) []Decision {
+	ds := make([]Decision, len(keys))
+	mode := l.Mode()
+	if mode == AllowAll || mode == DenyAll {
+		for i := range ds {
+			ds[i].Allowed = mode == AllowAll
 		}
+		return ds
+	}
+	l.updateState()
+	if l.Store != nil {
+		for i, key := range keys {
+			ds[i] = l.allow(l.getVisitor(key))
+		}
+	} else {
+		l.Lock()
+		now := time.Now()
+		for i, key := range keys {
+			v, exists := l.visitors[key]
+			if !exists {
+				v = l.addVisitor(key, "")
+			}
+			v.lastSeen = now
+			v.seen++
+			if d, ok := l.cachedDenial(v); ok {
+				ds[i] = d
+				continue
+			}
+			ds[i] = l.allowLocked(v, 1, now, false)
+			l.cacheDenial(v, ds[i])
+		}
+		l.Unlock()
+	}
+	for i, key := range keys {
+		if !ds[i].Allowed {
+			if !l.shadows(key, nil) {
+				atomic.AddUint64(&l.denied, 1)
+				continue
+			}
+			ds[i].Allowed = true // Shadowed denials are let through
+		}
+		l.notifyAllow(key)
+	}
+	return ds
+}
+
+// Returns a reservation for a single event from the key's limiter
+// at the current limiter state
+// The caller is expected to wait for Delay() or Cancel() the reservation
+// The event is taken from the key's windows too; if they don't allow it now
+// the reservation is not OK, and Cancel gives back only the key's own token
+// Modes apply as in AllowKey: the reservation is OK without delay in AllowAll
+// mode or when a denial is shadowed, and never OK in DenyAll mode
+func (l *Limiter) ReserveKey(key string) *rate.Reservation {
+	now := time.Now()
+	switch l.Mode() {
+	case AllowAll:
+		return rate.NewLimiter(rate.Inf, 0).ReserveN(now, 1) // OK without delay
+	case DenyAll:
+		return rate.NewLimiter(0, 0).ReserveN(now, 1) // Never OK
+	}
+	l.updateState()
+	v := l.getVisitor(key)
+	r := rate.NewLimiter(0, 0).ReserveN(now, 1)
+	held, _, ok := reserveWindows(v, 1, now)
+	if ok {
+		l.Lock()
+		r = l.activeLimiter(v).ReserveN(now, 1)
+		l.Unlock()
+	}
+	if (!r.OK() || r.DelayFrom(now) > 0) && l.shadows(key, nil) { // Shadowed callers aren't held up
+		r.CancelAt(now)
+		cancelWindows(held, now)
+		l.notifyAllow(key)
+		return rate.NewLimiter(rate.Inf, 0).ReserveN(now, 1)
+	}
+	if !r.OK() {
+		cancelWindows(held, now)
+		return r
+	}
+	l.notifyAllow(key)
+	return r
+}
+
+// Blocks until the key's limiter at the current limiter state, and its
+// windows, permit a single event or the context is done, in which case
+// its error is returned
+// With Leaky on, callers are paced at exactly the key's rate instead
+// Modes apply as in AllowKey: it returns at once in AllowAll mode or when a
+// wait is shadowed, and ErrDenyAll in DenyAll mode
+func (l *Limiter) WaitKey(ctx context.Context, key string) error {
+	switch l.Mode() {
+	case AllowAll:
+		return nil
+	case DenyAll:
+		return ErrDenyAll
+	}
+	l.updateState()
+	v := l.getVisitor(key)
+	l.Lock()
+	lims := v.windows
+	if !l.Leaky.On { // With Leaky on, the drip paces the key instead of its limiter
+		lims = append([]*rate.Limiter{l.activeLimiter(v)}, lims...)
 	}
 	l.Unlock()
+	now := time.Now()
+	held, wait, err := reserveAll(lims, 1, now)
+	shadow := l.shadows(key, nil)
+	if shadow && (err != nil || wait > 0) { // Shadowed callers aren't held up
+		cancelWindows(held, now)
+		l.notifyAllow(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if l.Leaky.On && !shadow { // Callers are paced one interval apart instead
+		if err := l.drip(ctx, v); err != nil {
+			for _, res := range held {
+				res.Cancel()
+			}
+			return err
+		}
+		wait -= time.Since(now) // The windows may still need waiting for after the slot
+	}
+	if err := waitReserved(ctx, held, wait); err != nil {
+		return err
+	}
+	l.notifyAllow(key)
+	return nil
+}
+
+// Removes n tokens from all of the key's limiters without making a decision,
+// e.g. to account for usage reported by other instances of a distributed limiter
+// The key's limiters are allowed to go into debt, delaying its future events
+func (l *Limiter) ChargeKey(key string, n int) {
+	v := l.getVisitor(key)
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	charge := func(lim *rate.Limiter) {
+		m := n
+		if m > lim.Burst() { // ReserveN refuses to reserve more than the burst size
+			m = lim.Burst()
+		}
+		lim.ReserveN(now, m)
+	}
+	charge(v.limiter)
+	for _, lim := range v.limiters {
+		charge(lim)
+	}
+}
+
+// Counts the allowed event and calls the OnAllow hook if one is set
+func (l *Limiter) notifyAllow(key string) {
+	atomic.AddUint64(&l.allowed, 1)
+	l.audit(AuditEntry{Key: key, Allow: true})
+	l.recordHistory(key, false)
+	if hook := l.AllowHook(); hook != nil {
+		hook(key)
+	}
 }
 
 // Checks whether or not a visitor (ip) is allowed
 // at the current limiter state
-func (l *Limiter) allow(v *visitor) bool {
+// If not, the decision carries the time until the visitor's bucket has a token
+func (l *Limiter) allow(v *visitor) Decision {
+	return l.allowN(v, 1)
+}
+
+// Checks whether or not a visitor is allowed n events at once
+func (l *Limiter) allowN(v *visitor, n int) Decision {
+	return l.allowClassN(v, n, false)
+}
+
+// Checks whether or not a visitor is allowed n events at once,
+// exempt from the load state if sheltered
+func (l *Limiter) allowClassN(v *visitor, n int, sheltered bool) Decision {
+	// Hot keys skip the limiters until they may retry, unless only the load state denied them
+	if d, ok := l.cachedDenial(v); ok && !(sheltered && d.Degraded) {
+		return d
+	}
+	// The visitor's windows must all allow the events too
+	now := time.Now()
+	held, d, ok := reserveWindows(v, n, now)
+	if ok {
+		d = l.decideN(v, n, sheltered)
+		if !d.Allowed {
+			cancelWindows(held, now)
+		} else if held != nil {
+			d = windowsRemaining(v, d)
+		}
+	}
+	l.cacheDenial(v, d)
+	return d
+}
+
+// Decides whether or not a visitor is allowed n events at once, using the store if set
+func (l *Limiter) decideN(v *visitor, n int, sheltered bool) Decision {
+	if l.Store != nil {
+		if l.storeAvailable() {
+			ok, retry, err := l.storeAllow(v, n, sheltered)
+			l.storeResult(err)
+			if err == nil {
+				return Decision{Allowed: ok, RetryAfter: retry}
+			}
+		}
+		// The store is unavailable or its breaker is open
+		if d, ok := l.fallbackDecision(); ok {
+			return d
+		}
+	}
 	l.Lock()
 	defer l.Unlock()
-	dflt := v.limiter.Allow()
-	var levels []bool
+	return l.allowLocked(v, n, time.Now(), sheltered)
+}
+
+// Checks whether or not a visitor is allowed n events at once using the local
+// limiters, exempt from the load state if sheltered
+// Must be called while holding the lock
+func (l *Limiter) allowLocked(v *visitor, n int, now time.Time, sheltered bool) Decision {
+	l.expireOverride(v)
+	if len(l.curve) > 0 { // The curve replaces the states
+		l.applyCurve(v)
+		d := l.decision(v.limiter, v.limiter.AllowN(now, n), n)
+		d.Degraded = !d.Allowed && v.fixed == nil && l.curRate < l.curve[0].Rate
+		return d
+	}
+	l.applySchedule(v)
+	l.applyWarmUp(v, now, false)
+	l.applyExisting(v, now)
+	l.applyReputation(v, now)
+	dflt := v.limiter.AllowN(now, n)
+	levels := make([]bool, len(v.limiters))
 	for i, l := range v.limiters { //it needs to iterate and update all of the
-		levels[i] = l.Allow() // limiters no matter the current state
+		levels[i] = l.AllowN(now, n) // limiters no matter the current state
+	}
+	if l.useDefault || l.state >= len(levels) || sheltered || l.exempt(v) {
+		return l.decision(v.limiter, dflt, n)
+	}
+	d := l.decision(v.limiters[l.state], levels[l.state], n)
+	d.Degraded = !d.Allowed && dflt // Only the load state denied the visitor
+	return d
+}
+
+// Builds the decision for a limiter that has just been asked to allow n events
+// Must be called while holding the lock
+func (l *Limiter) decision(lim *rate.Limiter, allowed bool, n int) Decision {
+	if allowed {
+		d := Decision{Allowed: true, Remaining: int(lim.Tokens())}
+		if b := lim.Burst(); b > 0 {
+			d.Warning = softWarning(1-lim.Tokens()/float64(b), l.SoftLimit.Threshold, "rate limit")
+		}
+		return d
+	}
+	return Decision{RetryAfter: retryAfter(lim, n)}
+}
+
+// Returns the visitor's limiter for the current limiter state
+// Must be called while holding the lock
+func (l *Limiter) activeLimiter(v *visitor) *rate.Limiter {
+	l.expireOverride(v)
+	if len(l.curve) > 0 {
+		l.applyCurve(v)
+		return v.limiter
 	}
-	if l.useDefault {
-		return dflt
+	l.applySchedule(v)
+	now := time.Now()
+	l.applyWarmUp(v, now, false)
+	l.applyExisting(v, now)
+	if l.useDefault || l.state >= len(v.limiters) || l.exempt(v) {
+		return v.limiter
 	}
-	return levels[l.state]
+	return v.limiters[l.state]
 }
 
 // Check for current visitor's rate limiter and return it if they have one
@@ -281,122 +1117,234 @@ func (l *Limiter) getVisitor(ip string) *visitor {
 	defer l.Unlock()
 	v, exists := l.visitors[ip]
 	if !exists {
-		return l.addVisitor(ip)
+		return l.addVisitor(ip, "")
 	}
 	// Update the last seen time for the visitor.
 	v.lastSeen = time.Now()
+	v.seen++
 	return v
 }
 
 // Creates a new limiter and adds it to the visitors map
 // with the user's IP address as the key.
-func (l *Limiter) addVisitor(ip string) (v *visitor) {
-	l.Lock()
-	v.limiter = rate.NewLimiter(l.Rate, l.Burst)
+// Must be called while holding the lock
+func (l *Limiter) addVisitor(ip string, plan string) *visitor {
+	v := &visitor{
+		key:      ip,
+		plan:     plan,
+		limiters: make([]*rate.Limiter, len(l.params)),
+		lastSeen: time.Now(),
+		seen:     1,
+		level:    l.levels[ip],
+		ns:       l.namespaceOf(ip),
+	}
+	v.rep.since = v.lastSeen
+	v.limiter = rate.NewLimiter(l.scale(l.defaultParams(v)))
+	v.windows = l.windowLimiters(l.visitorWindows(v))
 	for i, p := range l.params {
-		v.limiters[i] = rate.NewLimiter(p.rate, p.burst)
+		v.limiters[i] = rate.NewLimiter(l.scale(p.rate, p.burst))
 	}
-	v.lastSeen = time.Now()
+	l.prefill(v)
+	l.countHint(v)
 	l.visitors[ip] = v
-	l.Unlock()
-	return
+	l.track(v)
+	return v
+}
+
+// Divides a rate and burst by the replica count, after reducing them while warming up
+// Bursts are rounded up so that every replica allows at least one event
+// Must be called while holding the lock
+func (l *Limiter) scale(r rate.Limit, b int) (rate.Limit, int) {
+	r, b = warm(r, b, l.warmth(time.Now())) // Reduced while warming up
+	n := l.Replicas.Count
+	if n <= 1 {
+		return r, b
+	}
+	return r / rate.Limit(n), (b + n - 1) / n
+}
+
+// Sets the number of replicas sharing the limits and rescales
+// the limiters of existing visitors accordingly
+func (l *Limiter) SetReplicaCount(n int) {
+	l.Lock()
+	defer l.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	if n == l.Replicas.Count {
+		return
+	}
+	l.Replicas.Count = n
+	now := time.Now()
+	for _, v := range l.visitors {
+		r, b := l.scale(l.defaultParams(v))
+		v.limiter.SetLimitAt(now, r)
+		v.limiter.SetBurstAt(now, b)
+		for i, p := range l.params {
+			if i >= len(v.limiters) {
+				break
+			}
+			r, b = l.scale(p.rate, p.burst)
+			v.limiters[i].SetLimitAt(now, r)
+			v.limiters[i].SetBurstAt(now, b)
+		}
+	}
+}
+
+// Function to poll the replica count from the discovery func
+func (l *Limiter) updateReplicas(ctx context.Context) {
+	period := time.Minute * l.Replicas.UpdateFreq
+	defer l.monitor.stopped("replicas")
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		n, err := l.Replicas.Discover()
+		if err == nil {
+			l.SetReplicaCount(n)
+		}
+		l.monitor.ran("replicas", period, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // Every minute check the map for visitors that haven't been
 // seen for more than x minutes and remove them.
-func (l *Limiter) cleanupVisitors(quit chan bool) {
+func (l *Limiter) cleanupVisitors(ctx context.Context) {
+	period := l.Cleanup.Freq * time.Minute
+	defer l.monitor.stopped("cleanup")
+	timer := time.NewTimer(jitter(period))
+	defer timer.Stop()
 	for {
 		select {
-		case <-quit:
+		case <-ctx.Done():
 			return
-		default:
-			time.Sleep(l.Cleanup.Freq * time.Minute)
-			l.Lock()
-			for ip, v := range l.visitors {
-				if time.Now().Sub(v.lastSeen) > l.Cleanup.Thres*time.Minute {
-					delete(l.visitors, ip)
-				}
-			}
-			l.Unlock()
+		case <-timer.C:
+			l.sweepVisitors(ctx)
+			l.sweepOverrides(ctx)
+			l.sweepFailures(ctx)
+			l.sweepRetries(ctx)
+			l.monitor.ran("cleanup", period+period/10, nil)
+			timer.Reset(jitter(period))
 		}
 	}
 }
 
+// Returns the inactivity after which the visitor is removed, by its plan,
+// then its level, then the cleanup threshold
+// Visitors are kept at least until their longest window has refilled
+// Must be called while holding the lock
+func (l *Limiter) visitorTTL(v *visitor) time.Duration {
+	ttl := l.Cleanup.Thres * time.Minute
+	if p, ok := l.Plans[v.plan]; ok && v.plan != "" && p.TTL > 0 {
+		ttl = p.TTL
+	} else if t, ok := l.Cleanup.Levels[v.level]; ok && t > 0 {
+		ttl = t
+	}
+	for _, lim := range v.windows {
+		if refill := refillTime(lim); refill > ttl {
+			ttl = refill
+		}
+	}
+	if pending := v.drip.last.Sub(v.lastSeen); pending > ttl { // Kept while callers wait for it
+		ttl = pending
+	}
+	return ttl
+}
+
 // Function to update whitelist from a file
-func (l *Limiter) updateWhitelist(quit chan bool) {
+func (l *Limiter) updateWhitelist(ctx context.Context) {
+	period := time.Minute * l.Whitelist.UpdateFreq
+	defer l.monitor.stopped("whitelist")
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
 	for {
-		select {
-		case <-quit:
+		read := l.Whitelist.reload.first
+		if l.Whitelist.reload.first = nil; read == nil {
+			read = l.readAhead(l.Whitelist.Filename, l.Whitelist.Format)
+		}
+		newList, err := l.loadRead("whitelist", read)
+		if err == nil {
+			l.replaceList("whitelist", newList)
+		}
+		l.reloaded("whitelist", &l.Whitelist.reload, err)
+		l.monitor.ran("whitelist", period, err)
+		if !l.nextReload(ctx, ticker.C, l.Whitelist.Filename, read.version) {
 			return
-		default:
-			newList, err := c.ReadList(l.Whitelist.Filename)
-			if err == nil {
-				l.Lock()
-				l.Whitelist.list = newList
-				l.Unlock()
-			}
-			time.Sleep(time.Minute * l.Whitelist.UpdateFreq)
 		}
 	}
 }
 
 // Function to update blacklist from a file
-func (l *Limiter) updateBlacklist(quit chan bool) {
+func (l *Limiter) updateBlacklist(ctx context.Context) {
+	period := time.Minute * l.Blacklist.UpdateFreq
+	defer l.monitor.stopped("blacklist")
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
 	for {
-		select {
-		case <-quit:
+		read := l.Blacklist.reload.first
+		if l.Blacklist.reload.first = nil; read == nil {
+			read = l.readAhead(l.Blacklist.Filename, l.Blacklist.Format)
+		}
+		newList, err := l.loadRead("blacklist", read)
+		if err == nil {
+			l.replaceList("blacklist", newList)
+		}
+		l.reloaded("blacklist", &l.Blacklist.reload, err)
+		l.monitor.ran("blacklist", period, err)
+		if !l.nextReload(ctx, ticker.C, l.Blacklist.Filename, read.version) {
 			return
-		default:
-			newList, err := c.ReadList(l.Blacklist.Filename)
-			if err == nil {
-				l.Lock()
-				l.Blacklist.list = newList
-				l.Unlock()
-			}
-			time.Sleep(time.Minute * l.Blacklist.UpdateFreq)
 		}
 	}
 }
 
-// Function to add ip to blacklist
-func (l *Limiter) AddToBlacklist(ip string) {
+// Stops the limiter's background processes
+// The limiter keeps limiting with the lists and replica count it last loaded
+func (l *Limiter) Stop() {
 	l.Lock()
-	in, _ := c.InArray(l.Blacklist.list, ip)
-	if !in {
-		l.Blacklist.list = append(l.Blacklist.list, ip)
+	defer l.Unlock()
+	if l.cancel != nil {
+		l.cancel()
 	}
-	l.Unlock()
-	return
+}
+
+// Function to add ip to blacklist
+// Returns an error if ip is not an ip or cidr
+func (l *Limiter) AddToBlacklist(ip string) error {
+	if _, err := l.setListEntry("blacklist", c.Entry{IP: ip}, true); err != nil {
+		return err
+	}
+	l.persistEntry("blacklist", ip, true)
+	return nil
 }
 
 // Function to remove ip from blacklist
 func (l *Limiter) RemoveFromBlackList(ip string) {
-	l.Lock()
-	in, i := c.InArray(l.Blacklist.list, ip)
-	if in {
-		l.Blacklist.list = append(l.Blacklist.list[:i], l.Blacklist.list[i+1:]...)
-	}
-	l.Unlock()
-	return
+	l.setListEntry("blacklist", c.Entry{IP: ip}, false)
+	l.persistEntry("blacklist", ip, false)
 }
 
 // Function to add ip to whitelist
-func (l *Limiter) AddToWhitelist(ip string) {
-	l.Lock()
-	in, _ := c.InArray(l.Whitelist.list, ip)
-	if !in {
-		l.Whitelist.list = append(l.Whitelist.list, ip)
+// Returns an error if ip is not an ip or cidr
+func (l *Limiter) AddToWhitelist(ip string) error {
+	if _, err := l.setListEntry("whitelist", c.Entry{IP: ip, Level: l.Whitelist.Level}, true); err != nil {
+		return err
 	}
-	l.Unlock()
-	return
+	l.persistEntry("whitelist", ip, true)
+	if l.Whitelist.Level != 0 {
+		l.SetLevel(ip, l.Whitelist.Level)
+	}
+	return nil
 }
 
 // Function to remove ip from whitelist
 func (l *Limiter) RemoveFromWhiteList(ip string) {
-	l.Lock()
-	in, i := c.InArray(l.Whitelist.list, ip)
-	if in {
-		l.Whitelist.list = append(l.Whitelist.list[:i], l.Whitelist.list[i+1:]...)
+	l.setListEntry("whitelist", c.Entry{IP: ip}, false)
+	l.persistEntry("whitelist", ip, false)
+	if l.Whitelist.Level != 0 {
+		l.SetLevel(ip, 0)
 	}
-	l.Unlock()
 }
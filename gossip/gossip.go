@@ -0,0 +1,174 @@
+// Package gossip coordinates golimiter.Limiters across instances without
+// shared storage: each instance periodically sends the per-key usage it allowed
+// since the last exchange to its peers over hashicorp/memberlist, and peers charge
+// that usage against their own buckets for the key. The result is an approximate
+// global limit whose staleness is bounded by the exchange interval.
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/i-norden/golimiter"
+)
+
+// Node settings
+type Node struct {
+	sync.Mutex
+	Limiter *golimiter.Limiter // Limiter whose usage is shared (must be initialized)
+	// How often usage deltas are sent to peers (default 1 second)
+	// Shorter intervals tighten the global limit at the cost of more traffic
+	Interval time.Duration
+	// Deltas for at most this many keys are sent per interval, the busiest first
+	// Zero sends every key
+	MaxKeys int
+	// memberlist configuration; if nil memberlist.DefaultLANConfig() is used
+	Config   *memberlist.Config
+	list     *memberlist.Memberlist
+	deltas   map[string]int // Usage allowed locally since the last exchange
+	next     func(string)   // Allow hook set before Start, called after recording and restored by Stop
+	quitChan chan bool      // Channel used to stop the background goroutine
+}
+
+// Creates the memberlist, joins the given peers and starts
+// the background process that exchanges usage deltas
+func (n *Node) Start(peers []string) (err error) {
+	if n.Limiter == nil {
+		return errors.New("gossip node has no limiter")
+	}
+	if n.Interval == 0 {
+		n.Interval = time.Second // Use default interval if none provided
+	}
+	conf := n.Config
+	if conf == nil {
+		conf = memberlist.DefaultLANConfig()
+	}
+	conf.Delegate = &delegate{node: n}
+	n.deltas = make(map[string]int)
+	if n.list, err = memberlist.Create(conf); err != nil {
+		return
+	}
+	if len(peers) > 0 {
+		if _, err = n.list.Join(peers); err != nil {
+			n.list.Shutdown()
+			return
+		}
+	}
+	n.next = n.Limiter.AllowHook()
+	n.Limiter.SetOnAllow(n.record)
+	n.quitChan = make(chan bool)
+	go n.exchange(n.quitChan)
+	return
+}
+
+// Stops exchanging usage and leaves the cluster
+func (n *Node) Stop() error {
+	n.Limiter.SetOnAllow(n.next)
+	close(n.quitChan)
+	if err := n.list.Leave(n.Interval); err != nil {
+		return err
+	}
+	return n.list.Shutdown()
+}
+
+// Returns the number of live members in the cluster, including this node
+func (n *Node) Members() int {
+	return n.list.NumMembers()
+}
+
+// Records usage allowed by the local limiter, then calls the hook set before Start
+func (n *Node) record(key string) {
+	n.Lock()
+	n.deltas[key]++
+	n.Unlock()
+	if n.next != nil {
+		n.next(key)
+	}
+}
+
+// Every interval send the collected deltas to all other members
+func (n *Node) exchange(quit chan bool) {
+	ticker := time.NewTicker(n.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			msg := n.flush()
+			if msg == nil {
+				continue
+			}
+			for _, m := range n.list.Members() {
+				if m.Name == n.list.LocalNode().Name {
+					continue
+				}
+				n.list.SendReliable(m, msg) // Lost deltas only loosen the limit until the next exchange
+			}
+		}
+	}
+}
+
+// Swaps out the collected deltas and encodes them, returns nil if there are none
+func (n *Node) flush() []byte {
+	n.Lock()
+	deltas := n.deltas
+	n.deltas = make(map[string]int, len(deltas))
+	n.Unlock()
+	if len(deltas) == 0 {
+		return nil
+	}
+	if n.MaxKeys > 0 && len(deltas) > n.MaxKeys {
+		deltas = busiest(deltas, n.MaxKeys)
+	}
+	msg, err := json.Marshal(deltas)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// Returns the max keys with the largest deltas
+func busiest(deltas map[string]int, max int) map[string]int {
+	top := make(map[string]int, max)
+	for k, d := range deltas {
+		if len(top) < max {
+			top[k] = d
+			continue
+		}
+		minKey, minD := "", -1
+		for tk, td := range top {
+			if minD == -1 || td < minD {
+				minKey, minD = tk, td
+			}
+		}
+		if d > minD {
+			delete(top, minKey)
+			top[k] = d
+		}
+	}
+	return top
+}
+
+// memberlist.Delegate that applies usage received from peers
+type delegate struct {
+	node *Node
+}
+
+func (d *delegate) NotifyMsg(msg []byte) {
+	var deltas map[string]int
+	if err := json.Unmarshal(msg, &deltas); err != nil {
+		return
+	}
+	for key, used := range deltas {
+		d.node.Limiter.ChargeKey(key, used)
+	}
+}
+
+func (d *delegate) NodeMeta(limit int) []byte                  { return nil }
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *delegate) LocalState(join bool) []byte                { return nil }
+func (d *delegate) MergeRemoteState(buf []byte, join bool)     {}
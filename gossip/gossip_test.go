@@ -0,0 +1,127 @@
+package gossip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/i-norden/golimiter"
+)
+
+// Starts a node on a random loopback port
+func startNode(t *testing.T, name string, peers ...string) *Node {
+	t.Helper()
+	lim := &golimiter.Limiter{Rate: 1, Burst: 100}
+	lim.Cleanup.Off = true
+	if err := lim.Init(); err != nil {
+		t.Fatal(err)
+	}
+	conf := memberlist.DefaultLocalConfig()
+	conf.Name = name
+	conf.BindAddr = "127.0.0.1"
+	conf.BindPort = 0
+	conf.LogOutput = io.Discard
+	n := &Node{Limiter: lim, Interval: 10 * time.Millisecond, Config: conf}
+	if err := n.Start(peers); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Returns the node's address for others to join
+func addr(n *Node) string {
+	return n.list.LocalNode().Address()
+}
+
+func TestAllowHookChained(t *testing.T) {
+	lim := &golimiter.Limiter{Rate: 10, Burst: 10}
+	lim.Cleanup.Off = true
+	var calls int32
+	lim.OnAllow = func(key string) { atomic.AddInt32(&calls, 1) }
+	if err := lim.Init(); err != nil {
+		t.Fatal(err)
+	}
+	conf := memberlist.DefaultLocalConfig()
+	conf.Name = "hook"
+	conf.BindAddr = "127.0.0.1"
+	conf.BindPort = 0
+	conf.LogOutput = io.Discard
+	n := &Node{Limiter: lim, Config: conf}
+	if err := n.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	lim.AllowKey("a")
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatal("hook set before Start was not called")
+	}
+	n.Lock()
+	recorded := n.deltas["a"]
+	n.Unlock()
+	if recorded != 1 {
+		t.Fatal("usage was not recorded")
+	}
+	if err := n.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	lim.AllowKey("a")
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatal("hook was not restored by Stop")
+	}
+}
+
+func TestStressGossip(t *testing.T) {
+	a := startNode(t, "a")
+	b := startNode(t, "b", addr(a))
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, n := range []*Node{a, b} {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(n *Node, g int) {
+				defer wg.Done()
+				for i := 0; ctx.Err() == nil; i++ {
+					n.Limiter.AllowKey(fmt.Sprint("key", (g+i)%8))
+				}
+			}(n, g)
+		}
+		wg.Add(1)
+		go func(n *Node) { // Runtime changes while usage is exchanged
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				n.Limiter.SetBurst(50 + i%50)
+				n.Limiter.SetOnEvent(func(e golimiter.Event) {})
+				n.Members()
+			}
+		}(n)
+	}
+	<-ctx.Done()
+	wg.Wait()
+	b.Stop() // The leave may time out within the short interval, which doesn't matter here
+	a.Stop()
+}
+
+func TestUsageShared(t *testing.T) {
+	a := startNode(t, "a2")
+	b := startNode(t, "b2", addr(a))
+	defer a.Stop()
+	defer b.Stop()
+	for i := 0; i < 100; i++ {
+		a.Limiter.AllowKey("shared")
+	}
+	time.Sleep(200 * time.Millisecond) // Several exchanges
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if b.Limiter.AllowKey("shared") {
+			allowed++
+		}
+	}
+	if allowed > 10 {
+		t.Fatalf("usage allowed on one node was not charged on the other: %d allowed", allowed)
+	}
+}
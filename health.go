@@ -0,0 +1,163 @@
+package golimiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health of the limiter's background processes and store
+type HealthReport struct {
+	Healthy  bool                     `json:"healthy"`  // False if a process is stalled, a list is stale or the store's last call failed
+	Degraded bool                     `json:"degraded"` // True if a process has failed ListReload.Failures times in a row
+	Mode     string                   `json:"mode"`     // Mode the limiter is operating in
+	State    int                      `json:"state"`    // Index of the active load state (-1 for the default)
+	Visitors int                      `json:"visitors"` // Number of tracked visitors
+	Routines map[string]RoutineHealth `json:"routines"` // Background processes by name (whitelist, blacklist, replicas, cleanup, and those reported with ReportRun)
+	Store    *StoreHealth             `json:"store,omitempty"`
+}
+
+// Health of a background process
+type RoutineHealth struct {
+	Alive     bool      `json:"alive"`                // Whether the process has run within twice its period
+	LastRun   time.Time `json:"last_run"`             // When the process last ran
+	LastOK    time.Time `json:"last_ok,omitempty"`    // When the process last succeeded (e.g. reloaded its list)
+	LastError string    `json:"last_error,omitempty"` // Error of the last run, if it failed
+	Failures  int       `json:"failures,omitempty"`   // Consecutive failed runs
+	Stale     bool      `json:"stale,omitempty"`      // Whether the process's list is older than ListReload.MaxStaleness
+}
+
+// Health of the shared store
+type StoreHealth struct {
+	Calls     uint64        `json:"calls"`
+	Errors    uint64        `json:"errors"`
+	Latency   time.Duration `json:"latency"` // Average latency of the calls
+	LastError string        `json:"last_error,omitempty"`
+	Failing   bool          `json:"failing"`      // Whether the last call failed
+	Open      bool          `json:"breaker_open"` // Whether the circuit breaker is open
+}
+
+// Records the runs of the background processes and the store's calls
+type monitor struct {
+	sync.Mutex
+	routines map[string]*routine
+	store    StoreHealth
+	storeDur time.Duration // Total latency of the store calls
+}
+
+// A background process's runs
+type routine struct {
+	period time.Duration
+	RoutineHealth
+}
+
+// Records a run of the named background process, which runs every period
+func (m *monitor) ran(name string, period time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.routines == nil {
+		m.routines = make(map[string]*routine)
+	}
+	r, ok := m.routines[name]
+	if !ok {
+		r = &routine{}
+		m.routines[name] = r
+	}
+	r.period = period
+	r.LastRun = time.Now()
+	r.LastError = ""
+	if err != nil {
+		r.LastError = err.Error()
+		r.Failures++
+		return
+	}
+	r.LastOK = r.LastRun
+	r.Failures = 0
+}
+
+// Forgets the named background process once it has stopped
+func (m *monitor) stopped(name string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.routines, name)
+}
+
+// Records a run of a background process kept outside the limiter (e.g. a
+// watcher of its configuration), which runs every period, so it is reported
+// in Health's Routines; err is the run's error, nil if it succeeded
+func (l *Limiter) ReportRun(name string, period time.Duration, err error) {
+	l.monitor.ran(name, period, err)
+}
+
+// Removes the named process reported with ReportRun from Health once it has stopped
+func (l *Limiter) ReportStopped(name string) {
+	l.monitor.stopped(name)
+}
+
+// Records a call to the store
+func (m *monitor) storeCall(d time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.store.Calls++
+	m.storeDur += d
+	m.store.Failing = err != nil
+	if err != nil {
+		m.store.Errors++
+		m.store.LastError = err.Error()
+	}
+}
+
+// Returns the health of the limiter's background processes and store
+func (l *Limiter) Health() HealthReport {
+	rep := HealthReport{
+		Healthy:  true,
+		Mode:     l.Mode().String(),
+		State:    l.CurrentState(),
+		Routines: make(map[string]RoutineHealth),
+	}
+	l.Lock()
+	rep.Visitors = len(l.visitors)
+	hasStore := l.Store != nil
+	l.Unlock()
+	breakerOpen := l.StoreBreakerOpen()
+	stale := map[string]bool{
+		"whitelist": l.Whitelist.reload.stale.Load(),
+		"blacklist": l.Blacklist.reload.stale.Load(),
+	}
+	m := &l.monitor
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for name, r := range m.routines {
+		h := r.RoutineHealth
+		h.Alive = now.Sub(h.LastRun) <= 2*r.period
+		h.Stale = stale[name]
+		rep.Healthy = rep.Healthy && h.Alive && !h.Stale
+		rep.Degraded = rep.Degraded || h.Failures >= l.reloadFailures()
+		rep.Routines[name] = h
+	}
+	if hasStore {
+		st := m.store
+		if st.Calls > 0 {
+			st.Latency = m.storeDur / time.Duration(st.Calls)
+		}
+		st.Open = breakerOpen
+		rep.Healthy = rep.Healthy && !st.Failing && !st.Open
+		rep.Store = &st
+	}
+	return rep
+}
+
+// Returns a handler serving the limiter's health report as JSON,
+// with status 503 if it is unhealthy
+func (l *Limiter) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := l.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if !rep.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(rep)
+	})
+}
@@ -0,0 +1,127 @@
+package golimiter
+
+import (
+	"net/netip"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A shared bucket above the ip's own, in the limit hierarchy
+type tier struct {
+	rule    string // Rule reported when the bucket denies a request (subnet or global)
+	limiter *rate.Limiter
+}
+
+// Reserves n tokens for the ip from its subnet's bucket and, unless global is
+// false, the global bucket
+// Tokens are only taken if both allow it; the returned func gives them back,
+// for when the ip's own bucket denies the request
+func (l *Limiter) reserveHierarchy(ip string, n int, global bool) (Decision, string, func()) {
+	now := time.Now()
+	var reservations []*rate.Reservation
+	d, rule := Decision{Allowed: true}, ""
+	for _, t := range l.hierarchyTiers(ip, global) {
+		res := t.limiter.ReserveN(now, n)
+		if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+			if d.Allowed {
+				rule = t.rule
+			}
+			d.Allowed = false
+			if delay > d.RetryAfter && delay != rate.InfDuration {
+				d.RetryAfter = delay
+			}
+		}
+		reservations = append(reservations, res)
+	}
+	cancel := func() {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+	if !d.Allowed {
+		cancel()
+		return d, rule, func() {}
+	}
+	return d, rule, cancel
+}
+
+// Returns the buckets of the ip's subnet and of all ips, those switched on
+func (l *Limiter) hierarchyTiers(ip string, global bool) []tier {
+	l.Lock()
+	defer l.Unlock()
+	h := l.Hierarchy
+	var tiers []tier
+	if h.SubnetRate > 0 {
+		if subnet, ok := l.subnetOf(ip); ok {
+			v := l.getFixedVisitor("subnet:"+subnet, params{rate: h.SubnetRate, burst: h.SubnetBurst})
+			tiers = append(tiers, tier{rule: "subnet", limiter: v.limiter})
+		}
+	}
+	if h.GlobalRate > 0 && global {
+		tiers = append(tiers, tier{rule: "global", limiter: l.globalLimiter()})
+	}
+	return tiers
+}
+
+// Returns the limiter of the bucket shared by all ips
+// Must be called while holding the lock
+func (l *Limiter) globalLimiter() *rate.Limiter {
+	h := l.Hierarchy
+	return l.getFixedVisitor("global", params{rate: h.GlobalRate, burst: h.GlobalBurst}).limiter
+}
+
+// Returns the subnet the ip is limited in, e.g. 203.0.113.0/24
+// Must be called while holding the lock
+func (l *Limiter) subnetOf(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+	bits := l.Hierarchy.IPv4Prefix
+	if bits == 0 {
+		bits = 24 // Use default prefix if none provided
+	}
+	if addr.Is6() {
+		bits = l.Hierarchy.IPv6Prefix
+		if bits == 0 {
+			bits = 64 // Use default prefix if none provided
+		}
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "", false
+	}
+	return prefix.String(), true
+}
+
+// Lets the visitor borrow the n tokens its own bucket denied from the spare
+// capacity of the global bucket, which the request was already charged to
+// Visitors can borrow up to Elastic.Cap tokens per window
+func (l *Limiter) borrow(v *visitor, n int, d Decision) bool {
+	if !l.Elastic.On || d.Degraded || d.QuotaExceeded {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.Hierarchy.GlobalRate <= 0 {
+		return false
+	}
+	limit, window := l.Elastic.Cap, l.Elastic.Window
+	if limit == 0 {
+		limit = 10 // Use default cap if none provided
+	}
+	if window == 0 {
+		window = time.Minute // Use default window if none provided
+	}
+	now := time.Now()
+	if now.Sub(v.borrowAt) >= window {
+		v.borrowed, v.borrowAt = 0, now
+	}
+	if v.borrowed+n > limit {
+		return false
+	}
+	v.borrowed += n
+	return true
+}
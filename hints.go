@@ -0,0 +1,156 @@
+package golimiter
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefix of the keys of visitors keyed by their ip and client hint
+const hintPrefix = "hint:"
+
+// Returns a stable hint of the client app behind a request (e.g. a TLS
+// fingerprint or a device id header), or "" if the request carries none
+type HintFunc func(r *http.Request) string
+
+// Returns a HintFunc using a hash of the named header's value
+func HeaderHint(name string) HintFunc {
+	return func(r *http.Request) string {
+		val := r.Header.Get(name)
+		if val == "" {
+			return ""
+		}
+		h := fnv.New64a()
+		h.Write([]byte(val))
+		return strconv.FormatUint(h.Sum64(), 16)
+	}
+}
+
+// Returns the key an unidentified request is limited under: its ip, or its ip
+// and client hint if Hints.Func is set and the request carries one
+// Once an ip is keyed under Hints.PerIP hints, requests with new hints share its ip key
+func (l *Limiter) clientKey(r *http.Request) string {
+	ip := RemoteIP(r.RemoteAddr)
+	if l.Hints.Func == nil {
+		return ip
+	}
+	return l.hintedKey(ip, l.Hints.Func(r))
+}
+
+// Returns the key of the ip with the hint, or the ip if the hint is empty or
+// the ip is already keyed under Hints.PerIP other hints
+func (l *Limiter) hintedKey(ip, hint string) string {
+	if hint == "" {
+		return ip
+	}
+	key := hintPrefix + ip + "|" + hint
+	if l.Hints.PerIP <= 0 {
+		return key
+	}
+	l.Lock()
+	defer l.Unlock()
+	if _, exists := l.visitors[key]; exists || l.hinted[ip] < l.Hints.PerIP {
+		return key
+	}
+	return ip
+}
+
+// Counts a new visitor keyed by a client hint against its ip
+// Must be called while holding the lock
+func (l *Limiter) countHint(v *visitor) {
+	rest, ok := strings.CutPrefix(v.key, hintPrefix)
+	if !ok {
+		return
+	}
+	v.hintIP, _, _ = strings.Cut(rest, "|")
+	if l.hinted == nil {
+		l.hinted = make(map[string]int)
+	}
+	l.hinted[v.hintIP]++
+}
+
+// Releases the count of a removed visitor keyed by a client hint
+// Must be called while holding the lock
+func (l *Limiter) releaseHint(v *visitor) {
+	if v.hintIP == "" {
+		return
+	}
+	if l.hinted[v.hintIP]--; l.hinted[v.hintIP] <= 0 {
+		delete(l.hinted, v.hintIP)
+	}
+}
+
+// Records a JA3 style fingerprint of each TLS client's hello, so that the
+// apps behind a shared NAT can be told apart by their TLS stacks
+// Set GetConfigForClient on the tls.Config, then either ConnState on the
+// http.Server and Hint as the limiter's Hints.Func, or Conn as its Fingerprint.Func
+type Fingerprints struct {
+	Func  func(hello *tls.ClientHelloInfo) string // Computes a hello's fingerprint, e.g. JA4 (default a JA3 style hash)
+	mu    sync.Mutex
+	conns map[string]string // Fingerprints by the remote address of the connection
+}
+
+// Hook for tls.Config.GetConfigForClient that records the hello's fingerprint
+// Returns a nil config, so the handshake continues with the original one
+func (f *Fingerprints) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if hello.Conn == nil {
+		return nil, nil
+	}
+	compute := fingerprint
+	if f.Func != nil {
+		compute = f.Func
+	}
+	fp := compute(hello)
+	f.mu.Lock()
+	if f.conns == nil {
+		f.conns = make(map[string]string)
+	}
+	f.conns[hello.Conn.RemoteAddr().String()] = fp
+	f.mu.Unlock()
+	return nil, nil
+}
+
+// Hook for http.Server.ConnState that forgets the fingerprints of closed connections
+func (f *Fingerprints) ConnState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	f.mu.Lock()
+	delete(f.conns, c.RemoteAddr().String())
+	f.mu.Unlock()
+}
+
+// HintFunc returning the fingerprint of the request's connection
+func (f *Fingerprints) Hint(r *http.Request) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conns[r.RemoteAddr]
+}
+
+// ConnFingerprint returning the fingerprint of a connection, for LimitNetConn
+// Each fingerprint is forgotten once looked up, as raw connections have no ConnState
+func (f *Fingerprints) Conn(c net.Conn) string {
+	addr := c.RemoteAddr().String()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fp := f.conns[addr]
+	delete(f.conns, addr)
+	return fp
+}
+
+// Hashes the versions, cipher suites, curves, point formats, signature
+// schemes and protocols offered in the hello, which are stable for a client
+// app but differ between TLS stacks
+func fingerprint(hello *tls.ClientHelloInfo) string {
+	h := sha256.New()
+	fmt.Fprint(h, hello.SupportedVersions, hello.CipherSuites, hello.SupportedCurves,
+		hello.SupportedPoints, hello.SignatureSchemes, hello.SupportedProtos)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
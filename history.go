@@ -0,0 +1,122 @@
+package golimiter
+
+import "time"
+
+// A visitor's requests in one minute, as kept by History
+type MinuteCount struct {
+	Minute  time.Time `json:"minute"` // Start of the minute
+	Allowed int       `json:"allowed"`
+	Denied  int       `json:"denied"`
+}
+
+// Ring of a visitor's counts for its last History.Minutes minutes
+type history struct {
+	slots []MinuteCount // Counts by minute, indexed by the minute modulo the ring's size
+	first time.Time     // First minute the visitor was counted in
+	base  float64       // Mean requests per minute over the completed minutes, -1 until the ring has been filled
+	cur   time.Time     // Minute the base was computed for
+	spike bool          // Whether the visitor was banned for spiking in the current minute
+}
+
+// Records a request at now in the ring of the given size
+// Returns the counts of the current minute
+func (h *history) record(now time.Time, denied bool, size int) MinuteCount {
+	minute := now.Truncate(time.Minute)
+	if len(h.slots) != size {
+		h.slots, h.first = make([]MinuteCount, size), minute
+	}
+	s := &h.slots[int(minute.Unix()/60)%size]
+	if !s.Minute.Equal(minute) {
+		*s = MinuteCount{Minute: minute}
+	}
+	if denied {
+		s.Denied++
+	} else {
+		s.Allowed++
+	}
+	if !h.cur.Equal(minute) { // A new minute started; the baseline moves on
+		h.cur, h.spike = minute, false
+		h.base = -1
+		if span := len(h.slots) - 1; span > 0 && !h.first.After(minute.Add(-time.Duration(span)*time.Minute)) {
+			total := 0
+			for _, c := range h.counts(minute) {
+				if c.Minute.Before(minute) {
+					total += c.Allowed + c.Denied
+				}
+			}
+			h.base = float64(total) / float64(span)
+		}
+	}
+	return *s
+}
+
+// Returns the counts of the minutes in the ring up to the given minute, oldest first
+// Minutes without requests are included with zero counts
+func (h *history) counts(upTo time.Time) []MinuteCount {
+	size := len(h.slots)
+	counts := make([]MinuteCount, 0, size)
+	for i := size - 1; i >= 0; i-- {
+		minute := upTo.Add(-time.Duration(i) * time.Minute)
+		if minute.Before(h.first) {
+			continue
+		}
+		c := h.slots[int(minute.Unix()/60)%size]
+		if !c.Minute.Equal(minute) {
+			c = MinuteCount{Minute: minute}
+		}
+		counts = append(counts, c)
+	}
+	return counts
+}
+
+// Records an allowed or denied request in the history of the key's visitor,
+// banning the key if its requests in the current minute spike to
+// History.SpikeRatio times its mean minute
+func (l *Limiter) recordHistory(key string, denied bool) {
+	if l.History.Minutes <= 0 {
+		return
+	}
+	l.Lock()
+	v, ok := l.visitors[key]
+	if !ok || v.fixed != nil {
+		l.Unlock()
+		return
+	}
+	cur := v.hist.record(time.Now(), denied, l.History.Minutes+1) // The current minute and the kept ones
+	spiked := l.spiking(v, cur)
+	l.Unlock()
+	if spiked {
+		l.Ban(key, l.History.SpikeBan, "request spike")
+	}
+}
+
+// Reports whether the visitor's current minute is a spike it has not yet been banned for
+// Must be called while holding the lock
+func (l *Limiter) spiking(v *visitor, cur MinuteCount) bool {
+	if l.History.SpikeRatio <= 0 || v.hist.spike || v.hist.base <= 0 {
+		return false
+	}
+	floor := l.History.SpikeMin
+	if floor == 0 {
+		floor = 60 // Use default floor if none provided
+	}
+	n := cur.Allowed + cur.Denied
+	if n < floor || float64(n) < l.History.SpikeRatio*v.hist.base {
+		return false
+	}
+	v.hist.spike = true
+	return true
+}
+
+// Returns the key's requests per minute over the kept History, oldest first,
+// ending with the current minute, and false if the key has no visitor or
+// History is off
+func (l *Limiter) VisitorHistory(key string) ([]MinuteCount, bool) {
+	l.Lock()
+	defer l.Unlock()
+	v, ok := l.visitors[key]
+	if !ok || len(v.hist.slots) == 0 {
+		return nil, false
+	}
+	return v.hist.counts(time.Now().Truncate(time.Minute)), true
+}
@@ -0,0 +1,46 @@
+package golimiter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpikeBanned(t *testing.T) {
+	l := &Limiter{Rate: 1000, Burst: 1000}
+	l.Cleanup.Off = true
+	l.Blacklist.On = true
+	l.Blacklist.Filename = filepath.Join(t.TempDir(), "blacklist")
+	os.WriteFile(l.Blacklist.Filename, nil, 0644)
+	l.History.Minutes = 3
+	l.History.SpikeRatio = 10
+	l.History.SpikeMin = 5
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	awaitBlacklist(t, l)
+	l.AllowKey("10.0.0.1")
+	l.Lock()
+	l.visitors["10.0.0.1"].hist.base = 0.5 // Half a request a minute so far
+	l.Unlock()
+	for i := 0; i < 3; i++ {
+		l.AllowKey("10.0.0.1")
+	}
+	if l.blacklisted("10.0.0.1") {
+		t.Fatal("banned below the spike floor")
+	}
+	l.AllowKey("10.0.0.1") // The fifth request this minute
+	if !l.blacklisted("10.0.0.1") {
+		t.Fatal("spike not banned")
+	}
+}
+
+func TestSpikeBanNeedsBlacklist(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.History.Minutes = 3
+	l.History.SpikeRatio = 10
+	if err := l.Validate(); err == nil {
+		t.Fatal("spike ratio accepted without the blacklist on")
+	}
+}
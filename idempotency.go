@@ -0,0 +1,98 @@
+package golimiter
+
+import (
+	"container/heap"
+	"net/http"
+	"time"
+)
+
+// Idempotency keys recently charged, by visitor key
+type retryLog map[string]recentKeys
+
+// Idempotency keys recently charged to a visitor, least recently used first
+type recentKeys []chargedKey
+
+// An idempotency key and the time it was charged
+type chargedKey struct {
+	key string
+	at  time.Time
+}
+
+// Returns the request's idempotency key, if Idempotency is on
+func (l *Limiter) idempotencyKey(r *http.Request) string {
+	if !l.Idempotency.On {
+		return ""
+	}
+	header := l.Idempotency.Header
+	if header == "" {
+		header = "Idempotency-Key" // Use default header if none provided
+	}
+	return r.Header.Get(header)
+}
+
+// Checks whether the idempotency key was charged to the visitor key within
+// the window, and if so marks it as the most recently used
+func (l *Limiter) repeated(key, idem string) bool {
+	l.Lock()
+	defer l.Unlock()
+	recent := l.retries[key]
+	for i, ck := range recent {
+		if ck.key != idem {
+			continue
+		}
+		if time.Since(ck.at) > l.idempotencyWindow() {
+			return false
+		}
+		l.retries[key] = append(append(recent[:i:i], recent[i+1:]...), ck)
+		return true
+	}
+	return false
+}
+
+// Records that the idempotency key was charged to the visitor key, evicting
+// the least recently used keys once the visitor has Idempotency.Size of them
+func (l *Limiter) remember(key, idem string) {
+	size := l.Idempotency.Size
+	if size == 0 {
+		size = 16 // Use default size if none provided
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.retries == nil {
+		l.retries = make(retryLog)
+	}
+	now := time.Now()
+	if _, ok := l.retries[key]; !ok {
+		heap.Push(&l.retryAt, expiry{key: key, at: now.Add(l.idempotencyWindow())})
+	}
+	recent := make(recentKeys, 0, size)
+	for _, ck := range l.retries[key] {
+		if ck.key != idem {
+			recent = append(recent, ck)
+		}
+	}
+	if len(recent) >= size {
+		recent = recent[len(recent)-size+1:]
+	}
+	l.retries[key] = append(recent, chargedKey{key: idem, at: now})
+}
+
+// Returns how long a charged idempotency key covers its retries
+func (l *Limiter) idempotencyWindow() time.Duration {
+	if l.Idempotency.Window == 0 {
+		return time.Minute // Use default window if none provided
+	}
+	return l.Idempotency.Window
+}
+
+// Returns when all of the visitor's idempotency keys will be outside the window
+// Must be called while holding the lock
+func (l *Limiter) retriesDue(recent recentKeys) time.Time {
+	var last time.Time
+	for _, ck := range recent {
+		if ck.at.After(last) {
+			last = ck.at
+		}
+	}
+	return last.Add(l.idempotencyWindow())
+}
@@ -0,0 +1,120 @@
+package golimiter
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// An identity a request is limited under instead of its ip
+type Identity struct {
+	Key  string // Visitor key
+	Plan string // Name of the rate plan in Limiter.Plans; empty or unknown plans use the default Rate and Burst
+}
+
+// Extracts the identity of a request (e.g. from a token or client certificate)
+// ok is false for unauthenticated requests, which are limited by ip
+type KeyFunc func(r *http.Request) (id Identity, ok bool)
+
+// Extracts the identity of a connection (e.g. from its client certificate)
+// ok is false for unidentified connections, which are limited by ip
+type ConnKeyFunc func(conn net.Conn) (id Identity, ok bool)
+
+// A named rate plan, replacing Limiter.Rate and Limiter.Burst for identities on it
+type Plan struct {
+	Rate    rate.Limit
+	Burst   int
+	TTL     time.Duration // Inactivity after which visitors on the plan are removed (0- Cleanup.Thres)
+	Windows []WindowLimit // Further limits enforced together with Rate and Burst, instead of the default Windows
+}
+
+// Returns the ip of a remote address (host:port), the key unidentified
+// requests and connections are limited under, so that every request and
+// connection from a client shares one visitor whatever its port or protocol
+// Addresses without a port are returned as is
+func RemoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Returns the key and plan a request is limited under, and whether
+// the request was identified or passed a challenge
+func (l *Limiter) identify(r *http.Request) (key, plan string, verified bool) {
+	if l.KeyFunc != nil {
+		if id, ok := l.KeyFunc(r); ok && id.Key != "" {
+			return id.Key, id.Plan, true
+		}
+	}
+	key, verified = l.challengeKey(r)
+	return
+}
+
+// Returns the visitor whose bucket limits the request and the rule its denials
+// are reported under: the policy's bucket if the request is limited by one,
+// else the bucket of its method class if Methods is on, else the key's own
+func (l *Limiter) requestVisitor(r *http.Request, key, plan, policy string) (*visitor, string) {
+	if policy != "" {
+		return l.getPolicyVisitor(policy, key), "policy:" + policy
+	}
+	if v, class := l.methodVisitor(r, key); v != nil {
+		return v, class
+	}
+	return l.getPlanVisitor(key, plan), "rate"
+}
+
+// Returns the visitor for the key, on the given plan
+// A visitor whose plan has changed has its default limiter updated
+func (l *Limiter) getPlanVisitor(key, plan string) *visitor {
+	l.Lock()
+	defer l.Unlock()
+	v, exists := l.visitors[key]
+	if !exists {
+		return l.addVisitor(key, plan)
+	}
+	v.lastSeen = time.Now()
+	v.seen++
+	if v.plan != plan {
+		v.plan = plan
+		r, b := l.scale(l.defaultParams(v))
+		v.limiter.SetLimitAt(v.lastSeen, r)
+		v.limiter.SetBurstAt(v.lastSeen, b)
+	}
+	return v
+}
+
+// Returns the rate and burst used under default conditions for the visitor
+// Must be called while holding the lock
+func (l *Limiter) defaultParams(v *visitor) (rate.Limit, int) {
+	if v.fixed != nil {
+		return v.fixed.rate, v.fixed.burst
+	}
+	if p, ok := l.overridden(v); ok {
+		return p.rate, p.burst
+	}
+	r, b := l.Rate, l.Burst
+	plans := l.Plans
+	if v.ns != nil { // Visitors in a namespace use its plans
+		plans = v.ns.plans
+	}
+	if p, ok := plans[v.plan]; ok && v.plan != "" {
+		r, b = p.Rate, p.Burst
+	} else if l.window >= 0 && l.window < len(l.windows) { // An active schedule window replaces the defaults
+		r, b = l.windows[l.window].params.rate, l.windows[l.window].params.burst
+	}
+	if m, ok := l.Levels[v.level]; ok && m > 0 { // Preferred levels get a multiple of the params
+		if r != rate.Inf {
+			r *= rate.Limit(m)
+		}
+		b = int(float64(b) * m)
+	}
+	r, b = v.reputed(r, b) // Visitors with a poor reputation get a fraction of the params
+	if v.penalty {
+		return l.penalize(r, b)
+	}
+	return r, b
+}
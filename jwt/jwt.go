@@ -0,0 +1,166 @@
+// Package jwt provides a golimiter.KeyFunc that validates a JSON Web Token
+// and limits the request under its subject and rate plan claims
+// Requests without a valid token are left to the limiter's ip limiting
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Errors returned when a token is invalid
+var (
+	ErrMalformed    = errors.New("jwt: malformed token")
+	ErrAlgorithm    = errors.New("jwt: unexpected signing algorithm")
+	ErrSignature    = errors.New("jwt: invalid signature")
+	ErrExpired      = errors.New("jwt: token is expired")
+	ErrNotYetValid  = errors.New("jwt: token is not valid yet")
+	ErrMissingClaim = errors.New("jwt: missing subject claim")
+)
+
+// Validator settings
+// Exactly one of Secret (HS256/HS384/HS512) or PublicKey (RS256/RS384/RS512) should be set
+type Validator struct {
+	Secret       []byte            // HMAC key
+	PublicKey    *rsa.PublicKey    // RSA public key
+	Header       string            // Header carrying the token as "Bearer <token>" (default "Authorization")
+	SubjectClaim string            // Claim used as the visitor key (default "sub")
+	PlanClaim    string            // Claim used to select the rate plan (default "plan")
+	PlanMap      map[string]string // Optional mapping of plan claim values to golimiter.Plans names
+	Leeway       time.Duration     // Clock skew allowed when checking exp and nbf
+}
+
+// Returns a golimiter.KeyFunc using the validator
+func (v *Validator) KeyFunc() golimiter.KeyFunc {
+	return func(r *http.Request) (golimiter.Identity, bool) {
+		header := v.Header
+		if header == "" {
+			header = "Authorization"
+		}
+		auth := r.Header.Get(header)
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return golimiter.Identity{}, false
+		}
+		id, err := v.Identify(strings.TrimPrefix(auth, "Bearer "))
+		return id, err == nil
+	}
+}
+
+// Validates the token and returns the identity from its claims
+func (v *Validator) Identify(token string) (golimiter.Identity, error) {
+	claims, err := v.Validate(token)
+	if err != nil {
+		return golimiter.Identity{}, err
+	}
+	subClaim, planClaim := v.SubjectClaim, v.PlanClaim
+	if subClaim == "" {
+		subClaim = "sub"
+	}
+	if planClaim == "" {
+		planClaim = "plan"
+	}
+	sub, _ := claims[subClaim].(string)
+	if sub == "" {
+		return golimiter.Identity{}, ErrMissingClaim
+	}
+	plan, _ := claims[planClaim].(string)
+	if mapped, ok := v.PlanMap[plan]; ok {
+		plan = mapped
+	}
+	return golimiter.Identity{Key: "jwt:" + sub, Plan: plan}, nil
+}
+
+// Checks the token's signature and time claims and returns its claims
+func (v *Validator) Validate(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err = v.verify(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	claims := make(map[string]interface{})
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-v.Leeway)) {
+		return nil, ErrNotYetValid
+	}
+	return claims, nil
+}
+
+// Verifies the signature over the signing input using the algorithm,
+// which must match the configured key type
+func (v *Validator) verify(alg, input string, sig []byte) error {
+	if len(alg) != 5 {
+		return ErrAlgorithm
+	}
+	var hf func() hash.Hash
+	var ch crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hf, ch = sha256.New, crypto.SHA256
+	case "384":
+		hf, ch = sha512.New384, crypto.SHA384
+	case "512":
+		hf, ch = sha512.New, crypto.SHA512
+	default:
+		return ErrAlgorithm
+	}
+	switch {
+	case strings.HasPrefix(alg, "HS") && len(v.Secret) > 0:
+		mac := hmac.New(hf, v.Secret)
+		mac.Write([]byte(input))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+		return nil
+	case strings.HasPrefix(alg, "RS") && v.PublicKey != nil:
+		h := hf()
+		h.Write([]byte(input))
+		if err := rsa.VerifyPKCS1v15(v.PublicKey, ch, h.Sum(nil), sig); err != nil {
+			return ErrSignature
+		}
+		return nil
+	}
+	return ErrAlgorithm
+}
+
+// Decodes a base64url encoded JSON segment of the token
+func decodeSegment(seg string, dst interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err = json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("jwt: decoding segment: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package golimiter
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits for an API key
+type KeyLimits struct {
+	Rate        rate.Limit    // Rate for the key
+	Burst       int           // Burst/bucket size for the key
+	Quota       int64         // Events allowed per QuotaPeriod (0- no quota)
+	QuotaPeriod time.Duration // Length of the quota period (default 24 hours)
+}
+
+// Errors returned by the KeyRegistry
+var (
+	ErrKeyExists   = errors.New("api key already exists")
+	ErrKeyNotFound = errors.New("api key not found")
+)
+
+// KeyRegistry maps API keys to individual limits, manageable at runtime
+// Set it as Limiter.Keys to limit requests carrying a key by the key's limits
+// instead of by ip; requests with an unknown or revoked key are rejected with 401
+type KeyRegistry struct {
+	sync.RWMutex
+	Header string // Request header the key is read from (default "X-API-Key")
+	Store  Store  // Optional shared store for quota counters; if nil they are kept in memory
+	// How long each key's usage is kept per minute for Usage (default 24 hours)
+	Retention time.Duration
+	keys      map[string]*apiKey
+}
+
+// State kept for a registered key
+type apiKey struct {
+	limits  KeyLimits
+	limiter *rate.Limiter
+	used    int64     // Events counted against the quota in the current period
+	period  time.Time // Start of the current quota period
+	usage   usageLog  // Events per minute, oldest first, for Usage
+}
+
+// Creates an empty registry
+func NewKeyRegistry() *KeyRegistry {
+	return &KeyRegistry{keys: make(map[string]*apiKey)}
+}
+
+// Registers a new key
+func (kr *KeyRegistry) Add(key string, limits KeyLimits) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if _, exists := kr.keys[key]; exists {
+		return ErrKeyExists
+	}
+	kr.keys[key] = &apiKey{
+		limits:  withDefaults(limits),
+		limiter: rate.NewLimiter(limits.Rate, limits.Burst),
+		period:  time.Now(),
+	}
+	return nil
+}
+
+// Changes a registered key's limits
+// The key's bucket keeps its accumulated tokens and its quota usage is kept
+func (kr *KeyRegistry) Update(key string, limits KeyLimits) error {
+	kr.Lock()
+	defer kr.Unlock()
+	k, exists := kr.keys[key]
+	if !exists {
+		return ErrKeyNotFound
+	}
+	k.limits = withDefaults(limits)
+	now := time.Now()
+	k.limiter.SetLimitAt(now, limits.Rate)
+	k.limiter.SetBurstAt(now, limits.Burst)
+	return nil
+}
+
+// Removes a key; requests carrying it are rejected
+func (kr *KeyRegistry) Revoke(key string) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if _, exists := kr.keys[key]; !exists {
+		return ErrKeyNotFound
+	}
+	delete(kr.keys, key)
+	return nil
+}
+
+// Returns a key's limits
+func (kr *KeyRegistry) Get(key string) (KeyLimits, bool) {
+	kr.RLock()
+	defer kr.RUnlock()
+	k, exists := kr.keys[key]
+	if !exists {
+		return KeyLimits{}, false
+	}
+	return k.limits, true
+}
+
+// Returns the registered keys in sorted order
+func (kr *KeyRegistry) Keys() []string {
+	kr.RLock()
+	defer kr.RUnlock()
+	keys := make([]string, 0, len(kr.keys))
+	for k := range kr.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns the key carried by the request, if any
+func (kr *KeyRegistry) requestKey(r *http.Request) string {
+	header := kr.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	return r.Header.Get(header)
+}
+
+// Checks whether or not the key is allowed an event by its rate and quota
+// Allowed events are warned once the key has used the soft fraction of either
+// Returns false for known if the key is not registered, and the store's error
+// if the quota could not be counted, in which case the rate's decision stands
+func (kr *KeyRegistry) allow(key string, soft float64) (d Decision, known bool, err error) {
+	kr.Lock()
+	k, exists := kr.keys[key]
+	if !exists {
+		kr.Unlock()
+		return Decision{}, false, nil
+	}
+	now := time.Now()
+	res := k.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+		res.CancelAt(now)
+		kr.recordUsage(k, now, false)
+		kr.Unlock()
+		return Decision{RetryAfter: retryAfter(k.limiter, 1)}, true, nil
+	}
+	d = Decision{Allowed: true, Remaining: int(k.limiter.TokensAt(now))}
+	if b := k.limiter.Burst(); b > 0 {
+		d.Warning = softWarning(1-k.limiter.TokensAt(now)/float64(b), soft, "rate limit")
+	}
+	limits := k.limits
+	kr.Unlock()
+	if limits.Quota > 0 {
+		// Counted without the lock, so other keys aren't held up by the store
+		used, periodEnd, err := kr.countQuota(key, k, limits.QuotaPeriod, now)
+		if err != nil {
+			kr.record(k, now, true)
+			return d, true, err
+		}
+		if used > limits.Quota {
+			res.CancelAt(now) // The rate's token is not spent on an event over the quota
+			kr.record(k, now, false)
+			return Decision{RetryAfter: periodEnd.Sub(now), QuotaExceeded: true}, true, nil
+		}
+		if warning := softWarning(float64(used)/float64(limits.Quota), soft, "quota"); warning != "" {
+			d.Warning = warning
+		}
+	}
+	kr.record(k, now, true)
+	return d, true, nil
+}
+
+// Records an event of the key in its usage, taking the lock
+func (kr *KeyRegistry) record(k *apiKey, now time.Time, allowed bool) {
+	kr.Lock()
+	defer kr.Unlock()
+	kr.recordUsage(k, now, allowed)
+}
+
+// Counts an event against the key's quota and returns the usage in the
+// current period and the period's end
+// Must be called without holding the lock; it is only taken for counters kept in memory
+func (kr *KeyRegistry) countQuota(key string, k *apiKey, period time.Duration, now time.Time) (int64, time.Time, error) {
+	if kr.Store != nil {
+		idx := now.UnixNano() / int64(period)
+		periodEnd := time.Unix(0, (idx+1)*int64(period))
+		used, err := kr.Store.Incr("quota:"+key+":"+strconv.FormatInt(idx, 10), 1, period)
+		return used, periodEnd, err
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	if now.Sub(k.period) >= period {
+		k.period = now
+		k.used = 0
+	}
+	k.used++
+	return k.used, k.period.Add(period), nil
+}
+
+// Fills in the default quota period
+func withDefaults(limits KeyLimits) KeyLimits {
+	if limits.QuotaPeriod == 0 {
+		limits.QuotaPeriod = 24 * time.Hour
+	}
+	return limits
+}
+
+// Limits a request carrying an API key by the key's limits and the dimensions'
+func (l *Limiter) limitAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	dd, dims, dimsBack := l.allowDimensions(r)
+	setDimensionHeaders(w, dims)
+	if !dd.Allowed && l.deny(w, r, key, deniedDimension(dims), http.StatusTooManyRequests, dd.RetryAfter) {
+		return
+	}
+	d, known, err := l.Keys.allow(key, l.SoftLimit.Threshold)
+	if err != nil {
+		// The quota could not be counted: the OnInternalError policy decides
+		// whether the request is let through on its rate alone
+		l.emit(Event{Kind: EventInternalError, Key: key, Err: err, Reason: ReasonStoreError})
+		if fd, ok := l.failDecision(l.OnInternalError, 0); ok && !fd.Allowed {
+			dimsBack()
+			if l.deny(w, r, key, "internal", http.StatusServiceUnavailable, 0) {
+				return
+			}
+		}
+	}
+	if !known || !d.Allowed {
+		dimsBack() // The dimensions' tokens are not spent on a denied request
+	}
+	if !known && l.deny(w, r, key, "api-key", http.StatusUnauthorized, 0) {
+		return
+	}
+	rule := "rate"
+	if d.QuotaExceeded {
+		rule = "quota"
+	}
+	if known && !d.Allowed && l.deny(w, r, key, rule, http.StatusTooManyRequests, d.RetryAfter) {
+		return
+	}
+	l.warnSoftLimit(w, key, d.Warning)
+	l.notifyAllow(key)
+	d.Dimensions, d.Key = dims, key
+	next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), d)))
+}
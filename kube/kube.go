@@ -0,0 +1,153 @@
+// Package kube provides replica count discovery funcs for a golimiter.Limiter's
+// Replicas.Discover, so limits split across the pods of a Kubernetes
+// deployment hold as the HorizontalPodAutoscaler scales it
+//
+//	lim.Replicas.Discover = kube.Endpoints("", "my-service")
+//	lim.Replicas.UpdateFreq = 1
+package kube
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location of the pod's service account credentials
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Returned when no replica is found, so the last count is kept
+var ErrNoReplicas = errors.New("kube: no ready replicas found")
+
+// Returns a func counting the addresses a headless Service's name resolves to,
+// one per ready pod (e.g. "my-service.my-namespace.svc.cluster.local")
+func DNS(name string) func() (int, error) {
+	return func() (int, error) {
+		addrs, err := net.LookupHost(name)
+		if err != nil {
+			return 0, err
+		}
+		if len(addrs) == 0 {
+			return 0, ErrNoReplicas
+		}
+		return len(addrs), nil
+	}
+}
+
+// Returns a func reading the count from a file holding a number, e.g. a
+// Downward API volume exposing an annotation kept up to date by an operator
+func File(path string) func() (int, error) {
+	return func() (int, error) {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return 0, fmt.Errorf("kube: replica count file %s: %v", path, err)
+		}
+		if n < 1 {
+			return 0, ErrNoReplicas
+		}
+		return n, nil
+	}
+}
+
+// EndpointSlices of a Service, as far as they are read
+type endpointSlices struct {
+	Items []struct {
+		Endpoints []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Ready *bool `json:"ready"`
+			} `json:"conditions"`
+			TargetRef *struct {
+				Name string `json:"name"`
+			} `json:"targetRef"`
+		} `json:"endpoints"`
+	} `json:"items"`
+}
+
+// Returns a func counting the ready endpoints of a Service through the
+// Kubernetes API, with the pod's service account, which needs permission to
+// list endpointslices in the namespace
+// An empty namespace is the pod's own
+func Endpoints(namespace, service string) func() (int, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func() (int, error) {
+		if namespace == "" {
+			raw, err := os.ReadFile(serviceAccount + "/namespace")
+			if err != nil {
+				return 0, err
+			}
+			namespace = strings.TrimSpace(string(raw))
+		}
+		// The token is read on every call, since bound tokens are rotated
+		token, err := os.ReadFile(serviceAccount + "/token")
+		if err != nil {
+			return 0, err
+		}
+		if client.Transport == nil {
+			ca, err := os.ReadFile(serviceAccount + "/ca.crt")
+			if err != nil {
+				return 0, err
+			}
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(ca)
+			client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		}
+		host := net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+		u := "https://" + host + "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(namespace) +
+			"/endpointslices?labelSelector=" + url.QueryEscape("kubernetes.io/service-name="+service)
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("kube: listing endpointslices of %s/%s: %s", namespace, service, resp.Status)
+		}
+		var slices endpointSlices
+		if err = json.NewDecoder(resp.Body).Decode(&slices); err != nil {
+			return 0, err
+		}
+		return slices.ready()
+	}
+}
+
+// Counts the ready endpoints, once each even if they are in several slices
+// (e.g. one per address family)
+func (s endpointSlices) ready() (int, error) {
+	seen := make(map[string]bool)
+	for _, item := range s.Items {
+		for _, ep := range item.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue // Unknown readiness counts as ready, as in the API
+			}
+			id := ""
+			if ep.TargetRef != nil {
+				id = ep.TargetRef.Name
+			} else if len(ep.Addresses) > 0 {
+				id = ep.Addresses[0]
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) == 0 {
+		return 0, ErrNoReplicas
+	}
+	return len(seen), nil
+}
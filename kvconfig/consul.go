@@ -0,0 +1,84 @@
+package kvconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Consul KV source, watched with blocking queries
+type Consul struct {
+	Addr   string        // Address of the Consul agent (default "http://127.0.0.1:8500")
+	Prefix string        // Key prefix the configuration is kept under, e.g. "golimiter/api"
+	Token  string        // ACL token with read access to the prefix (none if empty)
+	Wait   time.Duration // Longest a blocking query waits for a change (default 5 minutes)
+	Client *http.Client  // Client the queries are made with (default http.DefaultClient)
+}
+
+// Reads the keys under the prefix, blocking until the prefix's index moves
+// past index
+func (s *Consul) Watch(ctx context.Context, index uint64) (map[string][]byte, uint64, error) {
+	addr := s.Addr
+	if addr == "" {
+		addr = "http://127.0.0.1:8500" // Use default address if none provided
+	}
+	wait := s.Wait
+	if wait == 0 {
+		wait = 5 * time.Minute // Use default wait if none provided
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	prefix := strings.Trim(s.Prefix, "/") + "/"
+	q := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", fmt.Sprintf("%ds", int(wait.Seconds())))
+	}
+	u := strings.TrimRight(addr, "/") + "/v1/kv/" + prefix + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, index, err
+	}
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, index, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return nil, index, fmt.Errorf("kvconfig: consul returned %s", resp.Status)
+	}
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, index, fmt.Errorf("kvconfig: consul returned no index")
+	}
+	if next < index {
+		next = 0 // The index went backwards (e.g. the cluster was restored), so it is reset
+	}
+	values := make(map[string][]byte)
+	if resp.StatusCode == http.StatusNotFound {
+		return values, next, nil // No keys under the prefix
+	}
+	var pairs []struct {
+		Key   string
+		Value []byte // Base64 in the JSON, decoded by encoding/json
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, index, err
+	}
+	for _, p := range pairs {
+		if k := strings.TrimPrefix(p.Key, prefix); k != "" && !strings.HasSuffix(k, "/") {
+			values[k] = p.Value
+		}
+	}
+	return values, next, nil
+}
@@ -0,0 +1,142 @@
+package kvconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// etcd v3 source, read and watched through etcd's JSON gRPC gateway
+type Etcd struct {
+	Endpoint string        // Address of an etcd member (default "http://127.0.0.1:2379")
+	Prefix   string        // Key prefix the configuration is kept under, e.g. "golimiter/api"
+	Token    string        // Auth token from /v3/auth/authenticate (none if empty)
+	Wait     time.Duration // Longest a watch waits for a change (default 5 minutes)
+	Client   *http.Client  // Client the calls are made with (default http.DefaultClient)
+}
+
+// Response header of the gateway, whose int64s are JSON strings
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+// Reads the keys under the prefix once a change past revision index is
+// seen, or the wait is over
+func (s *Etcd) Watch(ctx context.Context, index uint64) (map[string][]byte, uint64, error) {
+	prefix := strings.Trim(s.Prefix, "/") + "/"
+	if index > 0 {
+		wait := s.Wait
+		if wait == 0 {
+			wait = 5 * time.Minute // Use default wait if none provided
+		}
+		wctx, cancel := context.WithTimeout(ctx, wait)
+		err := s.wait(wctx, prefix, index)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, index, ctx.Err()
+		}
+		if err != nil && wctx.Err() == nil {
+			return nil, index, err
+		}
+	}
+	var resp struct {
+		Header etcdHeader `json:"header"`
+		KVs    []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	body := map[string][]byte{"key": []byte(prefix), "range_end": prefixEnd(prefix)}
+	if err := s.call(ctx, "/v3/kv/range", body, func(dec *json.Decoder) error { return dec.Decode(&resp) }); err != nil {
+		return nil, index, err
+	}
+	values := make(map[string][]byte, len(resp.KVs))
+	for _, kv := range resp.KVs {
+		if k := strings.TrimPrefix(string(kv.Key), prefix); k != "" && !strings.HasSuffix(k, "/") {
+			values[k] = kv.Value
+		}
+	}
+	return values, uint64(resp.Header.Revision), nil
+}
+
+// Watches the prefix from the revision after index until an event arrives,
+// or the watch is canceled (e.g. because the revision was compacted)
+func (s *Etcd) wait(ctx context.Context, prefix string, index uint64) error {
+	body := map[string]any{"create_request": map[string]any{
+		"key":            []byte(prefix),
+		"range_end":      prefixEnd(prefix),
+		"start_revision": index + 1,
+	}}
+	return s.call(ctx, "/v3/watch", body, func(dec *json.Decoder) error {
+		for {
+			var msg struct {
+				Result struct {
+					Events   []json.RawMessage `json:"events"`
+					Canceled bool              `json:"canceled"`
+				} `json:"result"`
+				Error *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := dec.Decode(&msg); err != nil {
+				return err
+			}
+			if msg.Error != nil {
+				return errors.New("kvconfig: etcd watch: " + msg.Error.Message)
+			}
+			if len(msg.Result.Events) > 0 || msg.Result.Canceled {
+				return nil
+			}
+		}
+	})
+}
+
+// Posts the body to the gateway's path and reads the response with read
+func (s *Etcd) call(ctx context.Context, path string, body any, read func(dec *json.Decoder) error) error {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:2379" // Use default endpoint if none provided
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+path, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", s.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kvconfig: etcd returned %s", resp.Status)
+	}
+	return read(json.NewDecoder(resp.Body))
+}
+
+// Returns the end of the key range holding the keys with the prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0} // Every key
+}
@@ -0,0 +1,231 @@
+// Package kvconfig keeps a golimiter.Limiter's limits, plans, mode and lists
+// in an etcd or Consul KV store, so a change to a key reaches every instance
+// of the fleet within seconds of being written
+//
+// The keys under the source's prefix are:
+//
+//	rate           default rate, in events per second (e.g. "10")
+//	burst          default burst (e.g. "20")
+//	mode           "enforce", "shadow", "allow-all" or "deny-all"
+//	plans/<name>   rate plan as JSON, e.g. {"rate": 100, "burst": 200, "ttl": "1h"},
+//	               replacing the whole plan (its Windows included)
+//	whitelist      whitelist entries, in the list's Format (default lines)
+//	blacklist      blacklist entries, in the list's Format (default lines)
+//
+// Other keys are ignored. Values that fail to apply leave the last good value
+// in force, as do deleted keys, and the watcher reports its rounds and their
+// errors in the limiter's Health
+//
+//	w := &kvconfig.Watcher{Limiter: lim, Source: &kvconfig.Consul{Prefix: "golimiter/api"}}
+//	err := w.Start()
+package kvconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/i-norden/golimiter"
+	"golang.org/x/time/rate"
+)
+
+// A KV store holding a limiter's configuration under a prefix
+type Source interface {
+	// Returns the values of the keys under the prefix, by key relative to it,
+	// once they may have changed since index (at once for index 0), with the
+	// index to pass on the next call
+	// Returns the same index if nothing changed within the source's wait
+	Watch(ctx context.Context, index uint64) (map[string][]byte, uint64, error)
+}
+
+// Watcher settings
+type Watcher struct {
+	sync.Mutex
+	Limiter *golimiter.Limiter // Limiter configured (must be initialized)
+	Source  Source             // Store the configuration is read from
+	// Name the watcher is reported under in the limiter's Health (default "kvconfig")
+	Name string
+	// Wait after a failed watch before retrying (default 5 seconds)
+	Retry time.Duration
+	// Longest a watch blocks, used as the watcher's period in the limiter's
+	// Health (default 5 minutes); must be at least the source's wait
+	Period  time.Duration
+	applied map[string]string // Last value applied by key
+	errs    map[string]error  // Error of the last value of the keys that failed to apply
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Plan as stored under plans/<name>
+type plan struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	TTL   string  `json:"ttl"` // Duration, e.g. "1h" (0- Cleanup.Thres)
+}
+
+// Reads the configuration once, applying it, and starts the background
+// process that applies its changes
+// Returns the error of the first read, after which the process still runs,
+// so the limiter keeps its own configuration until the store is reachable
+func (w *Watcher) Start() error {
+	if w.Limiter == nil || w.Source == nil {
+		return errors.New("kvconfig watcher has no limiter or source")
+	}
+	if w.Name == "" {
+		w.Name = "kvconfig" // Use default name if none provided
+	}
+	if w.Retry == 0 {
+		w.Retry = 5 * time.Second // Use default retry if none provided
+	}
+	if w.Period == 0 {
+		w.Period = 5 * time.Minute // Use default period if none provided
+	}
+	w.applied = make(map[string]string)
+	w.errs = make(map[string]error)
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel, w.done = cancel, make(chan struct{})
+	index, err := w.round(ctx, 0)
+	go w.watch(ctx, index)
+	return err
+}
+
+// Stops applying changes; the configuration applied last stays in force
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+	w.Limiter.ReportStopped(w.Name)
+}
+
+// Returns the errors of the values that failed to apply, by key
+func (w *Watcher) Errors() map[string]error {
+	w.Lock()
+	defer w.Unlock()
+	errs := make(map[string]error, len(w.errs))
+	for k, err := range w.errs {
+		errs[k] = err
+	}
+	return errs
+}
+
+// Watches the source until stopped, waiting Retry after failed rounds
+func (w *Watcher) watch(ctx context.Context, index uint64) {
+	defer close(w.done)
+	for ctx.Err() == nil {
+		next, err := w.round(ctx, index)
+		if err != nil && ctx.Err() == nil && next == index {
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.Retry):
+			}
+		}
+		index = next
+	}
+}
+
+// Waits for a change after index and applies it, reporting the round
+// Returns the index to watch from next, unchanged if the watch failed
+func (w *Watcher) round(ctx context.Context, index uint64) (uint64, error) {
+	values, next, err := w.Source.Watch(ctx, index)
+	if ctx.Err() != nil {
+		return index, ctx.Err()
+	}
+	if err == nil {
+		err = w.apply(values)
+	} else {
+		next = index // The last good configuration stays in force
+	}
+	w.Limiter.ReportRun(w.Name, w.Period+w.Retry, err)
+	return next, err
+}
+
+// Applies the values that changed since they were last applied
+// Returns the errors of those that failed, which are retried on the next round
+func (w *Watcher) apply(values map[string][]byte) error {
+	w.Lock()
+	defer w.Unlock()
+	for k := range w.applied {
+		if _, ok := values[k]; !ok {
+			delete(w.applied, k) // Deleted keys keep their last value, which is applied again if they return
+		}
+	}
+	for k := range w.errs {
+		if _, ok := values[k]; !ok {
+			delete(w.errs, k)
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := string(values[k])
+		if last, ok := w.applied[k]; ok && last == v {
+			continue
+		}
+		if err := w.set(k, values[k]); err != nil {
+			w.errs[k] = err
+			continue
+		}
+		delete(w.errs, k)
+		w.applied[k] = v
+	}
+	errs := make([]error, 0, len(w.errs))
+	for _, k := range keys {
+		if err, ok := w.errs[k]; ok {
+			errs = append(errs, fmt.Errorf("%s: %v", k, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Applies the value of a key to the limiter
+func (w *Watcher) set(key string, value []byte) error {
+	l := w.Limiter
+	s := strings.TrimSpace(string(value))
+	switch key {
+	case "rate":
+		r, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		return l.SetRate(rate.Limit(r))
+	case "burst":
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		return l.SetBurst(b)
+	case "mode":
+		m, err := golimiter.ParseMode(s)
+		if err != nil {
+			return err
+		}
+		l.SetMode(m)
+		return nil
+	case "whitelist", "blacklist":
+		return l.LoadList(key, value)
+	}
+	name, ok := strings.CutPrefix(key, "plans/")
+	if !ok || name == "" {
+		return nil
+	}
+	var p plan
+	if err := json.Unmarshal(value, &p); err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if p.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(p.TTL); err != nil {
+			return err
+		}
+	}
+	return l.SetPlan(name, golimiter.Plan{Rate: rate.Limit(p.Rate), Burst: p.Burst, TTL: ttl})
+}
@@ -0,0 +1,75 @@
+package golimiter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Error returned by WaitKey when Leaky.MaxQueue callers are already waiting for the key
+var ErrQueueFull = errors.New("too many callers waiting for the key")
+
+// Leaky bucket state of a visitor, for pacing WaitKey callers
+type leakyQueue struct {
+	last   time.Time // Slot of the last caller let through or waiting
+	queued int       // Callers waiting for their slot
+}
+
+// Waits for the visitor's next slot, spaced exactly one interval of the
+// visitor's rate after the previous one, so events drain at a steady rate
+// without bursts; returns early if the context is done or its deadline
+// falls before the slot, giving the slot back if no later caller took one
+func (l *Limiter) drip(ctx context.Context, v *visitor) error {
+	l.Lock()
+	r := l.activeLimiter(v).Limit()
+	if r == rate.Inf {
+		l.Unlock()
+		return nil
+	}
+	if r <= 0 { // There is never a slot
+		l.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if l.Leaky.MaxQueue > 0 && v.drip.queued >= l.Leaky.MaxQueue {
+		l.Unlock()
+		return ErrQueueFull
+	}
+	interval := time.Duration(float64(time.Second) / float64(r))
+	now := time.Now()
+	slot := v.drip.last.Add(interval)
+	if slot.Before(now) {
+		slot = now
+	}
+	if dl, ok := ctx.Deadline(); ok && dl.Before(slot) {
+		l.Unlock()
+		return context.DeadlineExceeded
+	}
+	v.drip.last = slot
+	wait := slot.Sub(now)
+	if wait <= 0 {
+		l.Unlock()
+		return nil
+	}
+	v.drip.queued++
+	l.Unlock()
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		l.Lock()
+		v.drip.queued--
+		l.Unlock()
+		return nil
+	case <-ctx.Done():
+		l.Lock()
+		v.drip.queued--
+		if v.drip.last.Equal(slot) {
+			v.drip.last = slot.Add(-interval)
+		}
+		l.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,311 @@
+package golimiter
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	c "github.com/i-norden/golimiter/common"
+)
+
+// Runtime changes of persisted lists by list name and ip
+type listChanges map[string]map[string]listChange
+
+// A runtime change of a list
+type listChange struct {
+	entry c.Entry // Entry that was added, or the removed ip
+	add   bool
+}
+
+// Returns a snapshot of the list with the entry added (add is true) or its ip removed
+// Lists are replaced by a changed snapshot as a whole, so they can be read without locking
+// Returns an error if the entry's ip (or cidr) doesn't parse
+func withEntry(list *c.IPSet, e c.Entry, add bool) (*c.IPSet, error) {
+	next := list.Snapshot()
+	if !add {
+		next.Remove(e.IP)
+	} else if err := next.Add(e); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// Checks whether the list has an entry for exactly the ip (or cidr) that hasn't expired
+func hasEntry(list *c.IPSet, ip string) bool {
+	e, ok := list.Get(ip)
+	return ok && (e.Expires.IsZero() || time.Now().Before(e.Expires))
+}
+
+// Returns the named list with its settings
+func (l *Limiter) listOf(name string) (list *atomic.Pointer[c.IPSet], filename, format string, persist bool) {
+	if name == "whitelist" {
+		return &l.Whitelist.list, l.Whitelist.Filename, l.Whitelist.Format, l.Whitelist.Persist
+	}
+	return &l.Blacklist.list, l.Blacklist.Filename, l.Blacklist.Format, l.Blacklist.Persist
+}
+
+// Adds the entry to or removes its ip from the named list, unless it already is or isn't on it
+// If the list persists runtime changes, the change is kept across reloads
+// and the list is written back to its file
+// Returns whether the list changed, and an error if the entry's ip doesn't parse
+func (l *Limiter) setListEntry(name string, e c.Entry, add bool) (bool, error) {
+	list, filename, format, persist := l.listOf(name)
+	l.listMu.Lock()
+	cur := list.Load()
+	if old, in := cur.Get(e.IP); !add && !in || add && in && old == e {
+		l.listMu.Unlock()
+		return false, nil
+	}
+	next, err := withEntry(cur, e, add)
+	if err != nil {
+		l.listMu.Unlock()
+		return false, err
+	}
+	list.Store(next)
+	if persist {
+		if l.changes == nil {
+			l.changes = make(listChanges)
+		}
+		if l.changes[name] == nil {
+			l.changes[name] = make(map[string]listChange)
+		}
+		l.changes[name][e.IP] = listChange{entry: e, add: add}
+		if !isRemote(filename) {
+			err = l.writeList(filename, format, next)
+		}
+	}
+	l.listMu.Unlock()
+	detail := "removed " + e.IP
+	if add {
+		detail = "added " + e.IP
+	}
+	l.emit(Event{Kind: EventListChange, Key: name, Detail: detail})
+	if err != nil {
+		l.emit(Event{Kind: EventListWriteFailed, Key: name, Err: err})
+	}
+	return true, nil
+}
+
+// Replaces the named list with one (re)loaded from its file,
+// keeping the runtime changes the file doesn't reflect yet
+func (l *Limiter) replaceList(name string, loaded *c.IPSet) {
+	list, _, _, _ := l.listOf(name)
+	l.listMu.Lock()
+	defer l.listMu.Unlock()
+	defer l.dropLevels(list.Load(), loaded)
+	now := time.Now()
+	for ip, ch := range l.changes[name] {
+		expired := !ch.entry.Expires.IsZero() && now.After(ch.entry.Expires)
+		if expired || hasEntry(loaded, ip) == ch.add {
+			delete(l.changes[name], ip) // Later edits of the file take precedence
+			continue
+		}
+		if next, err := withEntry(loaded, ch.entry, ch.add); err == nil {
+			loaded = next
+		}
+	}
+	list.Store(loaded)
+}
+
+// Takes the levels the entries of a list gave their ips back
+// from the ips that are no longer on the list
+func (l *Limiter) dropLevels(prev, next *c.IPSet) {
+	l.Lock()
+	defer l.Unlock()
+	for _, e := range prev.Entries() {
+		if _, ok := next.Get(e.IP); !ok && e.Level != 0 && l.levels[e.IP] == e.Level {
+			l.setLevel(e.IP, 0)
+		}
+	}
+}
+
+// Writes the list back to its file, replacing it atomically
+// Lists signed with an embedded HMAC are re-signed; lists that can
+// only be verified with a public key are left alone
+// Must be called while holding the list lock
+func (l *Limiter) writeList(filename, format string, list *c.IPSet) error {
+	secret := l.ListSigning.Secret
+	if len(secret) == 0 && len(l.ListSigning.PublicKey) > 0 {
+		return nil
+	}
+	if format == "" {
+		format = c.FormatByExt(filename)
+	}
+	raw, err := c.EncodeEntries(list.Entries(), format)
+	if err != nil {
+		return err
+	}
+	if len(secret) > 0 {
+		raw = append([]byte(embeddedMAC+hex.EncodeToString(listMAC(secret, raw))+"\n"), raw...)
+	}
+	return writeFileAtomic(filename, raw)
+}
+
+// Writes the file through a temporary file in the same directory that is
+// renamed over it, so readers never see a partially written file
+func writeFileAtomic(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Returns the whitelist's entries, ordered by ip
+func (l *Limiter) WhitelistEntries() []c.Entry {
+	return l.Whitelist.list.Load().Entries()
+}
+
+// Returns the blacklist's entries, ordered by ip
+func (l *Limiter) BlacklistEntries() []c.Entry {
+	return l.Blacklist.list.Load().Entries()
+}
+
+// Returned by Ban while the blacklist is off, when a ban would have no effect
+var ErrBlacklistOff = errors.New("blacklist is off")
+
+// Blacklists the ip for the duration (zero- until it is removed), recording the reason
+// Returns ErrBlacklistOff if the blacklist is off, or an error if ip is not an ip or cidr
+func (l *Limiter) Ban(ip string, d time.Duration, reason string) error {
+	if !l.blacklistOn() {
+		return ErrBlacklistOff
+	}
+	e := c.Entry{IP: ip, Reason: reason}
+	if d > 0 {
+		e.Expires = time.Now().Add(d)
+	}
+	if _, err := l.setListEntry("blacklist", e, true); err != nil {
+		return err
+	}
+	if d <= 0 {
+		l.persistEntry("blacklist", ip, true) // A ListStore can't record the expiry
+	}
+	return nil
+}
+
+// Reads the list file and applies the recorded runtime changes on top of it
+// The levels of the entries are assigned to their ips
+func (l *Limiter) loadList(name, filename, format string) (*c.IPSet, error) {
+	return l.loadRead(name, l.readAhead(filename, format))
+}
+
+// Applies the recorded runtime changes on top of a list already read
+func (l *Limiter) loadRead(name string, r *listRead) (*c.IPSet, error) {
+	if r.err == ErrListSignature {
+		l.emit(Event{Kind: EventListRejected, Key: name, Err: r.err}) // The last good list is kept
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return l.listSet(name, r.res), nil
+}
+
+// A list read ahead of taking the lock, with the version of its file
+type listRead struct {
+	version fileVersion
+	res     c.ParseResult
+	err     error
+}
+
+// The lists that are on, as read by readLists (nil for those that are off)
+type listReads struct {
+	white, black *listRead
+}
+
+// Reads (or fetches) the lists that are on, without holding the lock
+func (l *Limiter) readLists() (lists listReads) {
+	if l.Whitelist.On && l.Whitelist.Filename != "" {
+		lists.white = l.readAhead(l.Whitelist.Filename, l.Whitelist.Format)
+	}
+	if l.Blacklist.On && l.Blacklist.Filename != "" {
+		lists.black = l.readAhead(l.Blacklist.Filename, l.Blacklist.Format)
+	}
+	return lists
+}
+
+// Reads (or fetches) a list, noting the version of its file beforehand
+func (l *Limiter) readAhead(filename, format string) *listRead {
+	r := &listRead{version: statVersion(filename)}
+	r.res, r.err = l.readList(filename, format)
+	return r
+}
+
+// Replaces the named list ("whitelist" or "blacklist") with the entries in
+// raw, in the list's Format (default lines), and switches it on, for lists
+// kept elsewhere than in a file (e.g. in etcd or Consul)
+// Runtime changes recorded by a ListStore apply on top, as on a reload;
+// signed lists must embed their HMAC. On error the current list is kept
+// A list that also has a Filename is replaced again on its file's next reload
+func (l *Limiter) LoadList(name string, raw []byte) error {
+	if name != "whitelist" && name != "blacklist" {
+		return fmt.Errorf("unknown list %q", name)
+	}
+	_, _, format, _ := l.listOf(name)
+	if format == "" {
+		format = c.FormatLines
+	}
+	raw, err := l.verifyList("", raw)
+	if err == ErrListSignature {
+		l.emit(Event{Kind: EventListRejected, Key: name, Err: err})
+	}
+	if err != nil {
+		return err
+	}
+	res, err := c.ParseEntries(raw, format)
+	if err != nil {
+		return err
+	}
+	l.replaceList(name, l.listSet(name, res))
+	if name == "whitelist" {
+		l.Whitelist.enabled.Store(true)
+	} else {
+		l.Blacklist.enabled.Store(true)
+	}
+	return nil
+}
+
+// Builds the named list from the parsed entries, reporting the malformed ones
+// and applying the recorded runtime changes
+// The levels of the entries are assigned to their ips
+func (l *Limiter) listSet(name string, res c.ParseResult) *c.IPSet {
+	if len(res.Malformed) > 0 { // Malformed entries are left out
+		problems := make([]error, len(res.Malformed))
+		for i, pe := range res.Malformed {
+			problems[i] = pe
+		}
+		l.emit(Event{Kind: EventListMalformed, Key: name, Err: errors.Join(problems...)})
+	}
+	entries := res.Entries
+	entries = l.mergeEntries(name, entries)
+	for i, e := range entries {
+		if e.Level == 0 && name == "whitelist" {
+			entries[i].Level = l.Whitelist.Level // Whitelisted ips get the whitelist's level
+		}
+		if entries[i].Level != 0 {
+			l.SetLevel(e.IP, entries[i].Level)
+		}
+	}
+	return c.NewIPSet(entries)
+}
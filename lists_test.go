@@ -0,0 +1,58 @@
+package golimiter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInvalidListEntryRejected(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blacklist.txt")
+	if err := os.WriteFile(file, []byte("10.0.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var changes []string
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.OnEvent = func(e Event) {
+		if e.Kind == EventListChange {
+			mu.Lock()
+			changes = append(changes, e.Detail)
+			mu.Unlock()
+		}
+	}
+	changed := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(changes, ", ")
+	}
+	l.Cleanup.Off = true
+	l.Blacklist.On = true
+	l.Blacklist.Filename = file
+	l.Blacklist.Persist = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	if err := l.AddToBlacklist("not-an-ip"); err == nil {
+		t.Fatal("invalid entry accepted")
+	}
+	if err := l.Ban("10.0.0.300", 0, "typo"); err == nil {
+		t.Fatal("invalid ban accepted")
+	}
+	if got := changed(); got != "" {
+		t.Fatalf("invalid entries emitted %q", got)
+	}
+	raw, _ := os.ReadFile(file)
+	if strings.Contains(string(raw), "not-an-ip") || strings.Contains(string(raw), "10.0.0.300") {
+		t.Fatalf("invalid entries persisted: %q", raw)
+	}
+	if err := l.AddToBlacklist("10.0.0.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if got := changed(); got != "added 10.0.0.0/24" {
+		t.Fatalf("valid entry emitted %q", got)
+	}
+}
@@ -0,0 +1,41 @@
+package golimiter
+
+import (
+	"net/http"
+)
+
+// Returns the class of the request's method: "read" for GET, HEAD and
+// OPTIONS, "write" for every other method
+func methodClass(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return "read"
+	}
+	return "write"
+}
+
+// Returns the visitor for the key's bucket of the request's method class and
+// the class, if Methods is on
+// Returns a nil visitor for reads when Methods.Read is unset, which are
+// limited by the visitor's own bucket
+// Method buckets are limited by their class's rate and burst only, not by
+// plans, levels or load states
+func (l *Limiter) methodVisitor(r *http.Request, key string) (*visitor, string) {
+	if !l.Methods.On {
+		return nil, ""
+	}
+	class := methodClass(r)
+	pl := l.Methods.Write
+	if class == "read" {
+		pl = l.Methods.Read
+	}
+	if pl.Rate == 0 && pl.Burst == 0 {
+		if class == "read" {
+			return nil, ""
+		}
+		pl = Plan{Rate: l.Rate, Burst: l.Burst} // Use default params if none provided
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.getFixedVisitor(class+":"+key, params{rate: pl.Rate, burst: pl.Burst}), class
+}
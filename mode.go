@@ -0,0 +1,119 @@
+package golimiter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// The mode a limiter operates in, switchable at runtime
+type Mode int32
+
+const (
+	// Enforce limits as configured (the default)
+	Enforce Mode = iota
+	// Evaluate limits but let denied requests through, counting them
+	Shadow
+	// Maintenance: deny everything not on the whitelist, with 503 for http
+	DenyAll
+	// Bypass the limiter entirely
+	AllowAll
+)
+
+// Returned by WaitKey while the limiter is in DenyAll mode
+var ErrDenyAll = errors.New("limiter is denying everything (deny-all mode)")
+
+var modeNames = [...]string{"enforce", "shadow", "deny-all", "allow-all"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return "unknown"
+	}
+	return modeNames[m]
+}
+
+// Parses a mode from its name
+func ParseMode(name string) (Mode, error) {
+	for i, n := range modeNames {
+		if n == name {
+			return Mode(i), nil
+		}
+	}
+	return Enforce, errors.New("unknown limiter mode " + name)
+}
+
+// Switches the limiter's mode; safe to call while it is serving
+func (l *Limiter) SetMode(m Mode) {
+	if Mode(atomic.SwapInt32(&l.mode, int32(m))) != m {
+		l.emit(Event{Kind: EventModeChange, Detail: m.String()})
+	}
+}
+
+// Returns the limiter's current mode
+func (l *Limiter) Mode() Mode {
+	return Mode(atomic.LoadInt32(&l.mode))
+}
+
+// Returns the number of denials let through while in shadow mode
+func (l *Limiter) ShadowDenials() uint64 {
+	return atomic.LoadUint64(&l.shadowed)
+}
+
+// Rejects the request with the status, unless the denial is shadowed
+// The denial of the key by the rule is recorded in the audit log
+// Returns whether the request was rejected
+func (l *Limiter) deny(w http.ResponseWriter, r *http.Request, key, rule string, status int, retry time.Duration) bool {
+	shadow := l.shadows(key, r)
+	l.audit(AuditEntry{Key: key, Path: r.URL.Path, Rule: rule, Status: status, Shadow: shadow})
+	if shadow {
+		atomic.AddUint64(&l.shadowed, 1)
+		return false
+	}
+	atomic.AddUint64(&l.denied, 1)
+	l.demerit(key)
+	l.recordHistory(key, true)
+	if rule != "asn" {
+		l.countASNDenial(key)
+	}
+	setRetryAfter(w, retry)
+	reason := RuleReason(rule)
+	w.Header().Set("X-RateLimit-Reason", string(reason))
+	if cw, ok := w.(*checkWriter); ok { // Check writes no response
+		cw.rule, cw.status = rule, status
+		return true
+	}
+	l.tarpit(r.Context())
+	if dropResponse(w, status, l.Responses.Drop[reason]) {
+		return true
+	}
+	if h := l.Responses.Handlers[reason]; h != nil {
+		h.ServeHTTP(w, r)
+		return true
+	}
+	http.Error(w, http.StatusText(status)+": "+reason.Text(), status)
+	return true
+}
+
+// Closes the connection, unless the denial is shadowed
+// The denial of the key by the rule is recorded in the audit log
+// Returns whether the connection was closed
+func (l *Limiter) denyConn(conn net.Conn, key, rule string) bool {
+	shadow := l.shadows(key, nil)
+	l.audit(AuditEntry{Key: key, Rule: rule, Shadow: shadow})
+	if shadow {
+		atomic.AddUint64(&l.shadowed, 1)
+		return false
+	}
+	atomic.AddUint64(&l.denied, 1)
+	l.demerit(key)
+	l.recordHistory(key, true)
+	if rule != "asn" {
+		l.countASNDenial(key)
+	}
+	l.tarpit(context.Background())
+	conn.Close()
+	return true
+}
@@ -0,0 +1,46 @@
+package golimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestModesApplyToKeyedCalls(t *testing.T) {
+	for _, tc := range []struct {
+		mode    Mode
+		allowed bool // Whether a key past its burst is let through at once
+	}{
+		{Enforce, false},
+		{Shadow, true},
+		{AllowAll, true},
+		{DenyAll, false},
+	} {
+		l := &Limiter{Rate: 0.001, Burst: 1}
+		l.Cleanup.Off = true
+		if err := l.Init(); err != nil {
+			t.Fatal(err)
+		}
+		l.AllowKey("spent")
+		l.SetMode(tc.mode)
+		if got := l.AllowKey("spent"); got != tc.allowed {
+			t.Errorf("%v: AllowKey = %v", tc.mode, got)
+		}
+		r := l.ReserveKey("spent")
+		if got := r.OK() && r.Delay() == 0; got != tc.allowed {
+			t.Errorf("%v: ReserveKey OK = %v, delay %v", tc.mode, r.OK(), r.Delay())
+		}
+		r.Cancel()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := l.WaitKey(ctx, "spent")
+		cancel()
+		if got := err == nil; got != tc.allowed {
+			t.Errorf("%v: WaitKey = %v", tc.mode, err)
+		}
+		if tc.mode == DenyAll && !errors.Is(err, ErrDenyAll) {
+			t.Errorf("deny-all: WaitKey = %v, want ErrDenyAll", err)
+		}
+		l.Stop()
+	}
+}
@@ -0,0 +1,55 @@
+// Package mtls provides a golimiter.KeyFunc that limits requests under the
+// identity of their verified TLS client certificate, so service-to-service
+// limits don't depend on unstable pod ips
+package mtls
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Extractor settings
+type Extractor struct {
+	// Plans per identity (SPIFFE ID or CN) naming entries in golimiter.Plans
+	Plans map[string]string
+	// Plan for identities not in Plans (empty uses the default Rate and Burst)
+	DefaultPlan string
+	// If true only SPIFFE IDs are used; certificates without one are limited by ip
+	SPIFFEOnly bool
+}
+
+// Returns a golimiter.KeyFunc using the extractor
+// Only certificates verified by the server (tls.Config.ClientAuth set to verify
+// them) are trusted; requests without one are left to ip limiting
+func (e *Extractor) KeyFunc() golimiter.KeyFunc {
+	return func(r *http.Request) (golimiter.Identity, bool) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return golimiter.Identity{}, false
+		}
+		id := e.Identity(r.TLS.VerifiedChains[0][0])
+		if id == "" {
+			return golimiter.Identity{}, false
+		}
+		plan, ok := e.Plans[id]
+		if !ok {
+			plan = e.DefaultPlan
+		}
+		return golimiter.Identity{Key: "mtls:" + id, Plan: plan}, true
+	}
+}
+
+// Returns the certificate's SPIFFE ID (its spiffe:// URI SAN), or its CN if it
+// has none and SPIFFEOnly is not set
+func (e *Extractor) Identity(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String()
+		}
+	}
+	if e.SPIFFEOnly {
+		return ""
+	}
+	return cert.Subject.CommonName
+}
@@ -0,0 +1,150 @@
+package golimiter
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	c "github.com/i-norden/golimiter/common"
+)
+
+// Prefix of the keys of visitors in a namespace
+const nsPrefix = "ns:"
+
+// Namespaces by name
+type namespaceMap map[string]*Namespace
+
+// A tenant's namespace (e.g. of a gateway serving many tenants), with a
+// visitor space, lists and plans of its own, while sharing the limiter's
+// background processes, store, states, dimensions and mode; obtained with
+// Limiter.Namespace
+type Namespace struct {
+	l         *Limiter
+	name      string
+	plans     map[string]Plan         // Plans of the namespace's identities; guarded by the limiter's lock
+	whitelist atomic.Pointer[c.IPSet] // Enforced once it has entries
+	blacklist atomic.Pointer[c.IPSet]
+}
+
+// Returns the named namespace, creating it if it doesn't exist yet
+// Namespaces start with no plans and empty lists
+func (l *Limiter) Namespace(name string) *Namespace {
+	l.Lock()
+	defer l.Unlock()
+	if ns, ok := l.namespaces[name]; ok {
+		return ns
+	}
+	ns := &Namespace{l: l, name: name, plans: make(map[string]Plan)}
+	ns.whitelist.Store(c.NewIPSet(nil))
+	ns.blacklist.Store(c.NewIPSet(nil))
+	if l.namespaces == nil {
+		l.namespaces = make(namespaceMap)
+	}
+	l.namespaces[name] = ns
+	return ns
+}
+
+// Returns the namespace's name
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Returns the visitor key a key of the namespace is kept under
+func (ns *Namespace) key(key string) string {
+	return nsPrefix + ns.name + ":" + key
+}
+
+// Returns the namespace a visitor key is kept in, nil if none
+// Must be called while holding the lock
+func (l *Limiter) namespaceOf(key string) *Namespace {
+	rest, ok := strings.CutPrefix(key, nsPrefix)
+	if !ok {
+		return nil
+	}
+	name, _, _ := strings.Cut(rest, ":")
+	return l.namespaces[name]
+}
+
+// Wrap this middleware method around a handler to limit its visitors in the
+// namespace; requests carrying API keys are limited in the namespace too
+func (ns *Namespace) LimitHTTPHandler(next http.Handler) http.Handler {
+	return ns.l.limitHTTP(next, "", ns)
+}
+
+// Namespace middleware method for a request handler function
+func (ns *Namespace) LimitHTTPFunc(nextFunc func(http.ResponseWriter, *http.Request)) http.Handler {
+	return ns.LimitHTTPHandler(http.HandlerFunc(nextFunc))
+}
+
+// Checks whether or not the visitor identified by key in the namespace is
+// allowed a single event; like Limiter.AllowKey otherwise
+func (ns *Namespace) AllowKey(key string) bool {
+	return ns.l.AllowKey(ns.key(key))
+}
+
+// Checks whether or not the visitor identified by key in the namespace is
+// allowed n events at once; like Limiter.AllowN otherwise
+func (ns *Namespace) AllowN(key string, n int) bool {
+	return ns.l.AllowN(ns.key(key), n)
+}
+
+// Adds or replaces the named rate plan of the namespace's identities
+// Existing visitors on the plan are updated in place
+func (ns *Namespace) SetPlan(name string, p Plan) error {
+	if p.Rate < 0 || p.Burst < 0 {
+		return errors.New("plan rate and burst must not be negative")
+	}
+	l := ns.l
+	l.Lock()
+	defer l.Unlock()
+	ns.plans[name] = p
+	l.retuneVisitors(func(v *visitor) bool { return v.fixed == nil && v.ns == ns && v.plan == name })
+	return nil
+}
+
+// Adds the ip (or cidr) to the namespace's blacklist
+// Returns an error if ip is not an ip or cidr
+func (ns *Namespace) AddToBlacklist(ip string) error {
+	return ns.setEntry(&ns.blacklist, ip, true)
+}
+
+// Removes the ip (or cidr) from the namespace's blacklist
+func (ns *Namespace) RemoveFromBlacklist(ip string) {
+	ns.setEntry(&ns.blacklist, ip, false)
+}
+
+// Adds the ip (or cidr) to the namespace's whitelist
+// Once the whitelist has entries, only its ips are let into the namespace
+// Returns an error if ip is not an ip or cidr
+func (ns *Namespace) AddToWhitelist(ip string) error {
+	return ns.setEntry(&ns.whitelist, ip, true)
+}
+
+// Removes the ip (or cidr) from the namespace's whitelist
+func (ns *Namespace) RemoveFromWhitelist(ip string) {
+	ns.setEntry(&ns.whitelist, ip, false)
+}
+
+// Adds the ip to or removes it from one of the namespace's lists
+func (ns *Namespace) setEntry(list *atomic.Pointer[c.IPSet], ip string, add bool) error {
+	ns.l.listMu.Lock()
+	defer ns.l.listMu.Unlock()
+	next, err := withEntry(list.Load(), c.Entry{IP: ip}, add)
+	if err != nil {
+		return err
+	}
+	list.Store(next)
+	return nil
+}
+
+// Returns the rule denying the ip by the namespace's lists, "" if none does
+func (ns *Namespace) listed(ip string) string {
+	if wl := ns.whitelist.Load(); wl.Len() > 0 && !wl.Contains(ip) {
+		return "whitelist:" + ns.name
+	}
+	if ns.blacklist.Load().Contains(ip) {
+		return "blacklist:" + ns.name
+	}
+	return ""
+}
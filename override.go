@@ -0,0 +1,81 @@
+package golimiter
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A temporary limit for a single visitor key
+type override struct {
+	params params
+	until  time.Time
+}
+
+// Temporarily limits the visitor key by the rate and burst instead of its
+// plan, the default params and the load states (e.g. to boost or restrict a
+// customer during a migration); the override expires after ttl
+// Setting it again replaces the previous override and its ttl
+func (l *Limiter) SetVisitorLimit(key string, r rate.Limit, b int, ttl time.Duration) error {
+	if r < 0 || b < 0 {
+		return errors.New("visitor rate and burst must not be negative")
+	}
+	if ttl <= 0 {
+		return errors.New("visitor limit ttl must be positive")
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.overrides == nil {
+		l.overrides = make(map[string]override)
+	}
+	o := override{params: params{rate: r, burst: b}, until: time.Now().Add(ttl)}
+	if _, ok := l.overrides[key]; !ok { // Replaced overrides are rescheduled once their old time comes
+		heap.Push(&l.overrideAt, expiry{key: key, at: o.until})
+	}
+	l.overrides[key] = o
+	if v, ok := l.visitors[key]; ok {
+		r, b = l.scale(r, b)
+		v.limiter = retune(v.limiter, r, b, time.Now())
+		v.denied.Store(nil) // The override applies right away
+	}
+	return nil
+}
+
+// Removes the visitor key's override before it expires
+func (l *Limiter) ClearVisitorLimit(key string) {
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.overrides[key]; !ok {
+		return
+	}
+	delete(l.overrides, key)
+	if v, ok := l.visitors[key]; ok {
+		r, b := l.scale(l.defaultParams(v))
+		v.limiter = retune(v.limiter, r, b, time.Now())
+	}
+}
+
+// Returns the visitor's override params, if it has an override that hasn't expired
+// Must be called while holding the lock
+func (l *Limiter) overridden(v *visitor) (params, bool) {
+	o, ok := l.overrides[v.key]
+	if !ok || time.Now().After(o.until) {
+		return params{}, false
+	}
+	return o.params, true
+}
+
+// Removes the visitor's override once it has expired and
+// gives its default limiter its regular params back
+// Must be called while holding the lock
+func (l *Limiter) expireOverride(v *visitor) {
+	o, ok := l.overrides[v.key]
+	if !ok || !time.Now().After(o.until) {
+		return
+	}
+	delete(l.overrides, v.key)
+	r, b := l.scale(l.defaultParams(v))
+	v.limiter = retune(v.limiter, r, b, time.Now())
+}
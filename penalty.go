@@ -0,0 +1,170 @@
+package golimiter
+
+import (
+	"container/heap"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Failures reported with ReportFailure by key
+type failureLog map[string]*failureRecord
+
+// Failures reported for a key
+type failureRecord struct {
+	weight float64   // Failure weight since the key was last blocked
+	blocks int       // Times the key has been blocked
+	last   time.Time // Time of the last failure
+}
+
+// Reports a failed authentication (e.g. a failed login) by the key, weighted
+// by how suspicious it is; keys whose failures add up to the threshold are
+// blocked for escalating durations and, after enough blocks, blacklisted
+// Failures are counted separately from the key's request rate
+func (l *Limiter) ReportFailure(key string, weight float64) {
+	if weight <= 0 {
+		return
+	}
+	threshold, block := l.Failures.Threshold, l.Failures.Block
+	if threshold == 0 {
+		threshold = 5 // Use default threshold if none provided
+	}
+	if block == 0 {
+		block = time.Minute // Use default block if none provided
+	}
+	now := time.Now()
+	l.Lock()
+	if l.failures == nil {
+		l.failures = make(failureLog)
+	}
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.last) > l.failureMemory() {
+		if !ok {
+			heap.Push(&l.failureAt, expiry{key: key, at: now.Add(l.failureMemory())})
+		}
+		f = &failureRecord{}
+		l.failures[key] = f
+	}
+	f.weight += weight
+	f.last = now
+	if f.weight < threshold {
+		l.Unlock()
+		return
+	}
+	f.weight = 0
+	f.blocks++
+	blocks := f.blocks
+	l.Unlock()
+	for i := 1; i < blocks && block < 24*time.Hour; i++ {
+		block *= 2
+	}
+	if block > 24*time.Hour {
+		block = 24 * time.Hour
+	}
+	l.SetVisitorLimit(key, 0, 0, block)
+	l.emit(Event{Kind: EventKeyBlocked, Key: key, Detail: block.String()})
+	// Repeat offenders are blacklisted on top of the block, which still
+	// applies to keys that aren't ips and can't be blacklisted
+	if l.Failures.BanAfter > 0 && blocks > l.Failures.BanAfter {
+		l.Ban(key, l.Failures.BanFor, "authentication failures")
+	}
+}
+
+// Forgets the key's failures, e.g. after it authenticated successfully
+// A block already in force is left to expire
+func (l *Limiter) ClearFailures(key string) {
+	l.Lock()
+	delete(l.failures, key)
+	l.Unlock()
+}
+
+// Returns how long a key's failures are remembered without a new one
+func (l *Limiter) failureMemory() time.Duration {
+	if l.Failures.Forget == 0 {
+		return time.Hour // Use default memory if none provided
+	}
+	return l.Failures.Forget
+}
+
+// Wrap this middleware method around a login handler to report a failure
+// for the request's ip whenever the handler responds 401 or 403
+func (l *Limiter) LimitLogins(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status == http.StatusUnauthorized || sw.status == http.StatusForbidden {
+			l.ReportFailure(RemoteIP(r.RemoteAddr), 1)
+		}
+	})
+}
+
+// Updates the visitor's moving error ratio with the status of a response to it
+// The visitor is penalized once the ratio rises above the threshold, and
+// pardoned once it falls below half of it
+func (l *Limiter) observeStatus(key string, status int) {
+	threshold, weight := l.Errors.Threshold, l.Errors.Weight
+	if threshold == 0 {
+		threshold = 0.5 // Use default threshold if none provided
+	}
+	if weight == 0 {
+		weight = 0.05 // Use default weight if none provided
+	}
+	failed := 0.0
+	if status >= 400 {
+		failed = 1
+	}
+	l.Lock()
+	defer l.Unlock()
+	v, ok := l.visitors[key]
+	if !ok {
+		return
+	}
+	v.errRatio = weight*failed + (1-weight)*v.errRatio
+	penalty := v.penalty
+	if v.errRatio > threshold {
+		penalty = true
+	} else if v.errRatio < threshold/2 {
+		penalty = false
+	}
+	if penalty == v.penalty {
+		return
+	}
+	v.penalty = penalty
+	r, b := l.scale(l.defaultParams(v))
+	v.limiter = retune(v.limiter, r, b, time.Now())
+}
+
+// Reduces a rate and burst by the errors penalty factor; the burst stays at least one
+// Must be called while holding the lock
+func (l *Limiter) penalize(r rate.Limit, b int) (rate.Limit, int) {
+	factor := l.Errors.Factor
+	if factor == 0 {
+		factor = 0.1 // Use default factor if none provided
+	}
+	if r != rate.Inf {
+		r *= rate.Limit(factor)
+	}
+	if b = int(float64(b) * factor); b < 1 {
+		b = 1
+	}
+	return r, b
+}
+
+// Records the status of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.status = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Lets handlers flush through the status writer
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
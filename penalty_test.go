@@ -0,0 +1,105 @@
+package golimiter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newPenaltyLimiter(t *testing.T) *Limiter {
+	t.Helper()
+	l := &Limiter{Rate: 100, Burst: 100}
+	l.Cleanup.Off = true
+	l.Failures.Threshold = 2
+	l.Failures.Block = time.Minute
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestFailuresBlockAtThreshold(t *testing.T) {
+	l := newPenaltyLimiter(t)
+	l.ReportFailure("alice", 1)
+	if !l.AllowKey("alice") {
+		t.Fatal("blocked below the threshold")
+	}
+	l.ReportFailure("alice", 1)
+	if l.AllowKey("alice") {
+		t.Fatal("not blocked at the threshold")
+	}
+	if !l.AllowKey("bob") {
+		t.Fatal("another key was blocked")
+	}
+}
+
+func TestFailuresBlocksEscalate(t *testing.T) {
+	l := newPenaltyLimiter(t)
+	var blocks []string
+	l.SetOnEvent(func(e Event) {
+		if e.Kind == EventKeyBlocked {
+			blocks = append(blocks, e.Detail)
+		}
+	})
+	for i := 0; i < 6; i++ {
+		l.ReportFailure("alice", 1)
+	}
+	want := []string{"1m0s", "2m0s", "4m0s"}
+	if len(blocks) != len(want) {
+		t.Fatalf("blocks %v, want %v", blocks, want)
+	}
+	for i := range want {
+		if blocks[i] != want[i] {
+			t.Fatalf("blocks %v, want %v", blocks, want)
+		}
+	}
+}
+
+func TestFailuresClearedAndForgotten(t *testing.T) {
+	l := newPenaltyLimiter(t)
+	l.Failures.Forget = 20 * time.Millisecond
+	l.ReportFailure("alice", 1)
+	l.ClearFailures("alice")
+	l.ReportFailure("alice", 1)
+	if !l.AllowKey("alice") {
+		t.Fatal("cleared failures were counted")
+	}
+	time.Sleep(30 * time.Millisecond)
+	l.ReportFailure("alice", 1)
+	if !l.AllowKey("alice") {
+		t.Fatal("forgotten failures were counted")
+	}
+}
+
+func TestFailuresBanKeepsBlock(t *testing.T) {
+	l := &Limiter{Rate: 100, Burst: 100}
+	l.Cleanup.Off = true
+	l.Blacklist.On = true
+	l.Blacklist.Filename = filepath.Join(t.TempDir(), "blacklist")
+	os.WriteFile(l.Blacklist.Filename, nil, 0644)
+	l.Failures.Threshold = 1
+	l.Failures.BanAfter = 1
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	awaitBlacklist(t, l)
+	for _, key := range []string{"10.0.0.1", "alice"} {
+		l.ReportFailure(key, 1)
+		l.ReportFailure(key, 1) // Past BanAfter
+		if l.AllowKey(key) {
+			t.Fatalf("%s unblocked once banned", key)
+		}
+	}
+	if !hasEntry(l.Blacklist.list.Load(), "10.0.0.1") {
+		t.Fatal("repeat offender ip not blacklisted")
+	}
+}
+
+func TestFailuresBanNeedsBlacklist(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Failures.BanAfter = 3
+	if err := l.Validate(); err == nil {
+		t.Fatal("ban after accepted without the blacklist on")
+	}
+}
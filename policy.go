@@ -0,0 +1,87 @@
+package golimiter
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// A named policy (e.g. login, search, upload) enforcing buckets of its own
+// per visitor, while sharing the limiter's visitor table, cleanup, lists
+// and mode; obtained with Limiter.Policy
+// Policy buckets are limited by the policy's rate and burst only, not by
+// plans, levels or load states
+type Policy struct {
+	l    *Limiter
+	name string
+}
+
+// Returns the named policy, limited by the rate and burst in Policies
+// (or the limiter's default Rate and Burst if it has none)
+func (l *Limiter) Policy(name string) *Policy {
+	return &Policy{l: l, name: name}
+}
+
+// Wrap this middleware method around a handler to limit its visitors by the
+// policy; requests carrying API keys are limited by the policy too
+func (p *Policy) LimitHTTPHandler(next http.Handler) http.Handler {
+	return p.l.limitHTTP(next, p.name, nil)
+}
+
+// Policy middleware method for a request handler function
+func (p *Policy) LimitHTTPFunc(nextFunc func(http.ResponseWriter, *http.Request)) http.Handler {
+	return p.LimitHTTPHandler(http.HandlerFunc(nextFunc))
+}
+
+// Checks whether or not the visitor identified by key is allowed a single
+// event by the policy; like Limiter.AllowKey otherwise
+func (p *Policy) AllowKey(key string) bool {
+	return p.AllowN(key, 1)
+}
+
+// Checks whether or not the visitor identified by key is allowed n events at
+// once by the policy; like Limiter.AllowN otherwise
+func (p *Policy) AllowN(key string, n int) bool {
+	return p.CheckN(key, n).Allowed
+}
+
+// Checks n events for the visitor identified by key like AllowN, returning
+// the decision instead of a bool; like Limiter.CheckN otherwise
+func (p *Policy) CheckN(key string, n int) Decision {
+	l := p.l
+	switch l.Mode() {
+	case AllowAll:
+		return Decision{Allowed: true}
+	case DenyAll:
+		return Decision{Allowed: false}
+	}
+	d := l.allowN(l.getPolicyVisitor(p.name, key), n)
+	if !d.Allowed {
+		if !l.shadows(key, nil) {
+			atomic.AddUint64(&l.denied, 1)
+			return d
+		}
+		d = Decision{Allowed: true} // Shadowed denials are let through
+	}
+	l.notifyAllow(key)
+	return d
+}
+
+// Returns the visitor for the key's bucket of the named policy
+func (l *Limiter) getPolicyVisitor(name, key string) *visitor {
+	l.Lock()
+	defer l.Unlock()
+	pl, ok := l.Policies[name]
+	if !ok {
+		pl = Plan{Rate: l.Rate, Burst: l.Burst}
+	}
+	v := l.getFixedVisitor(policyKey(name, key), params{rate: pl.Rate, burst: pl.Burst})
+	if v.windows == nil && len(pl.Windows) > 0 {
+		v.windows = l.windowLimiters(pl.Windows)
+	}
+	return v
+}
+
+// Returns the visitor key a policy's bucket is kept under
+func policyKey(name, key string) string {
+	return "policy:" + name + ":" + key
+}
@@ -0,0 +1,30 @@
+package golimiter
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Takes the tokens new visitors don't start with from the visitor's buckets
+// Must be called while holding the lock
+func (l *Limiter) prefill(v *visitor) {
+	if !l.InitialTokens.On {
+		return
+	}
+	now := time.Now()
+	drain := func(lim *rate.Limiter) {
+		initial := l.InitialTokens.Count
+		if l.InitialTokens.Fraction > 0 {
+			initial = int(math.Round(l.InitialTokens.Fraction * float64(lim.Burst())))
+		}
+		if spent := lim.Burst() - initial; spent > 0 {
+			lim.AllowN(now, spent)
+		}
+	}
+	drain(v.limiter)
+	for _, lim := range v.limiters {
+		drain(lim)
+	}
+}
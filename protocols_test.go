@@ -0,0 +1,124 @@
+package golimiter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Tests of one limiter shared by http handlers, net listeners and keyed calls
+
+// Connection reporting a fixed remote address
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr { return c.remote }
+
+// Returns a connection from the address, closed when the test ends
+func connFrom(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	remote, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return remoteConn{Conn: server, remote: remote}
+}
+
+// Returns whether the request from the address was let through
+func serveFrom(h http.Handler, addr string) bool {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code == http.StatusOK
+}
+
+// Returns whether the connection from the address was handled
+func connectFrom(t *testing.T, l *Limiter, addr string) bool {
+	handled := false
+	l.LimitNetConn(connFrom(t, addr), func(net.Conn) { handled = true })
+	return handled
+}
+
+func TestRemoteIP(t *testing.T) {
+	for addr, ip := range map[string]string{
+		"10.0.0.1:1234":     "10.0.0.1",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"10.0.0.1":          "10.0.0.1", // No port
+		"2001:db8::1":       "2001:db8::1",
+	} {
+		if got := RemoteIP(addr); got != ip {
+			t.Errorf("RemoteIP(%q) = %q, want %q", addr, got, ip)
+		}
+	}
+}
+
+func TestBudgetSharedAcrossProtocols(t *testing.T) {
+	l := &Limiter{Rate: 0.001, Burst: 3}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	h := l.LimitHTTPFunc(func(w http.ResponseWriter, r *http.Request) {})
+	// One token each from an http request, a connection and a keyed call,
+	// all from different ports of the same client
+	if !serveFrom(h, "10.0.0.1:1000") {
+		t.Fatal("http request denied")
+	}
+	if !connectFrom(t, l, "10.0.0.1:2000") {
+		t.Fatal("connection denied")
+	}
+	if !l.AllowKey(RemoteIP("10.0.0.1:3000")) {
+		t.Fatal("keyed call denied")
+	}
+	// The client's budget is spent, whatever protocol it uses next
+	if serveFrom(h, "10.0.0.1:4000") {
+		t.Fatal("http request allowed past the shared burst")
+	}
+	if connectFrom(t, l, "10.0.0.1:5000") {
+		t.Fatal("connection allowed past the shared burst")
+	}
+	if l.AllowKey("10.0.0.1") {
+		t.Fatal("keyed call allowed past the shared burst")
+	}
+	// Other clients have budgets of their own
+	if !serveFrom(h, "10.0.0.2:1000") || !connectFrom(t, l, "10.0.0.2:2000") {
+		t.Fatal("another client was denied")
+	}
+	if n := l.Stats().Visitors; n != 2 {
+		t.Fatalf("%d visitors, want one per client", n)
+	}
+}
+
+func TestIdentifiedKeysSeparate(t *testing.T) {
+	l := &Limiter{Rate: 0.001, Burst: 1}
+	l.Cleanup.Off = true
+	l.KeyFunc = func(r *http.Request) (Identity, bool) {
+		key := r.Header.Get("X-Account")
+		return Identity{Key: key}, key != ""
+	}
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	h := l.LimitHTTPFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1000"
+	r.Header.Set("X-Account", "acct")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal("identified request denied")
+	}
+	// The identified request drew from the account's bucket, not the ip's
+	if !connectFrom(t, l, "10.0.0.1:2000") {
+		t.Fatal("identified request was charged to its ip")
+	}
+	if l.AllowKey("acct") {
+		t.Fatal("identified request was not charged to its key")
+	}
+}
@@ -0,0 +1,208 @@
+package golimiter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signature opening a PROXY protocol v2 header
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Error returned by reads from a connection whose PROXY protocol header is malformed
+var ErrProxyHeader = errors.New("golimiter: malformed PROXY protocol header")
+
+// Longest v1 header, including its CRLF
+const proxyV1Max = 107
+
+// Connection whose client address is read from the PROXY protocol header
+// ahead of its data, on first use
+type proxyConn struct {
+	net.Conn
+	r       *bufio.Reader
+	once    sync.Once
+	trusted bool          // Whether the peer is a trusted load balancer whose header is read
+	timeout time.Duration // Time allowed for the header to arrive
+	src     net.Addr      // Client address from the header, nil if it had none
+	err     error         // Error reading the header
+}
+
+// Returns a listener whose connections report the client address from their
+// PROXY protocol header as their RemoteAddr, for servers taking connections
+// from a load balancer in TCP mode (e.g. an http.Server, or a TLS listener
+// wrapping it, whose connections are then limited by the real client ip)
+// The header is read on the connection's first Read or RemoteAddr call, so
+// Accept is not held up by slow clients
+func (l *Limiter) ProxyListener(ln net.Listener) net.Listener {
+	return &proxyListener{Listener: ln, l: l}
+}
+
+type proxyListener struct {
+	net.Listener
+	l *Limiter
+}
+
+func (pl *proxyListener) Accept() (net.Conn, error) {
+	conn, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return pl.l.proxyConn(conn), nil
+}
+
+// Wraps the connection to read its PROXY protocol header if ProxyProtocol is
+// on, unless it already is
+func (l *Limiter) proxyConn(conn net.Conn) net.Conn {
+	if !l.ProxyProtocol.On {
+		return conn
+	}
+	if _, ok := conn.(*proxyConn); ok {
+		return conn
+	}
+	timeout := l.ProxyProtocol.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second // Use default timeout if none provided
+	}
+	return &proxyConn{
+		Conn:    conn,
+		r:       bufio.NewReader(conn),
+		trusted: l.proxyTrusted(RemoteIP(conn.RemoteAddr().String())),
+		timeout: timeout,
+	}
+}
+
+// Reports whether the ip is a load balancer whose PROXY protocol headers are trusted
+func (l *Limiter) proxyTrusted(ip string) bool {
+	if len(l.ProxyProtocol.nets) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range l.ProxyProtocol.nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the header once, if the peer is trusted
+func (c *proxyConn) header() {
+	c.once.Do(func() {
+		if !c.trusted {
+			return
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.src, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.header()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// Returns the client address from the header, or the peer's address if it had none
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.header()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Reads a v1 or v2 PROXY protocol header from the start of the stream
+// Returns a nil address if the stream has no header, or its header carries
+// no client address (v1 UNKNOWN, v2 LOCAL or non-IP families)
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		if b, err := r.Peek(6); err != nil || string(b) != "PROXY " {
+			return nil, nil
+		}
+		return readProxyV1(r)
+	case '\r':
+		if b, err := r.Peek(len(proxyV2Sig)); err != nil || !bytes.Equal(b, proxyV2Sig) {
+			return nil, nil
+		}
+		return readProxyV2(r)
+	}
+	return nil, nil
+}
+
+// Reads a v1 header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > proxyV1Max || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// Reads a v2 header: the signature, version and command, address family,
+// length, then the addresses and any TLVs, which are skipped
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, ErrProxyHeader
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, ErrProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrProxyHeader
+	}
+	switch fixed[12] & 0xf {
+	case 0: // LOCAL, e.g. the load balancer's health checks
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, ErrProxyHeader
+	}
+	switch fixed[13] >> 4 {
+	case 1: // IPv4: source and destination addresses, then ports
+		if len(body) < 12 {
+			return nil, ErrProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 2: // IPv6
+		if len(body) < 36 {
+			return nil, ErrProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil // Unspecified or unix socket addresses
+}
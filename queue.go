@@ -0,0 +1,238 @@
+package golimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Errors of requests that could not wait in the queue
+var (
+	errQueueFull = errors.New("queue is full")
+	errQueueWait = errors.New("waited too long in the queue")
+)
+
+// Returns a Queue.Priority func reading the priority from the named request
+// header, e.g. one set by a trusted proxy; requests without it have priority 0
+func PriorityHeader(name string) func(r *http.Request) int {
+	return func(r *http.Request) int {
+		p, _ := strconv.Atoi(r.Header.Get(name))
+		return p
+	}
+}
+
+// Requests waiting for the global bucket, served by weighted fair queueing:
+// each priority class gets a share of the global rate by its weight, and
+// within a class requests are served in order of arrival
+type fairQueue struct {
+	sync.Mutex
+	classes map[int]*class
+	virtual float64       // Virtual time, the finish tag of the last request served
+	waiting int           // Requests in the queue
+	wake    chan struct{} // Signals the dispatcher that requests were queued
+}
+
+// The requests of one priority
+type class struct {
+	last    float64 // Finish tag of the class's last queued request
+	waiters []*waiter
+}
+
+// A queued request
+type waiter struct {
+	cost   int
+	finish float64                // Virtual time the request finishes at, if served alone in its class
+	served bool                   // Whether the dispatcher took the request from the queue
+	ready  chan *rate.Reservation // Receives the global tokens (nil if they can't be had)
+}
+
+// Reserves the ip's hierarchy tokens like reserveHierarchy, except that requests
+// over the global rate wait in the queue (if it is on) for the global tokens
+// Requests also wait while others are queued, so the queue isn't overtaken
+func (l *Limiter) reserveQueued(r *http.Request, ip, plan string, n int) (Decision, string, func()) {
+	if !l.Queue.On {
+		return l.reserveHierarchy(ip, n, true)
+	}
+	l.queue.Lock()
+	waiting := l.queue.waiting
+	l.queue.Unlock()
+	if waiting == 0 {
+		d, rule, giveBack := l.reserveHierarchy(ip, n, true)
+		if d.Allowed || rule != "global" {
+			return d, rule, giveBack
+		}
+	}
+	d, rule, giveBack := l.reserveHierarchy(ip, n, false)
+	if !d.Allowed {
+		return d, rule, giveBack
+	}
+	res, err := l.waitInQueue(r.Context(), l.priority(r, plan), n)
+	if err != nil {
+		giveBack()
+		return Decision{}, "queue", func() {}
+	}
+	return d, rule, func() {
+		giveBack()
+		res.Cancel()
+	}
+}
+
+// Returns the request's priority, by the Priority func or the identity's plan
+func (l *Limiter) priority(r *http.Request, plan string) int {
+	if l.Queue.Priority != nil {
+		return l.Queue.Priority(r)
+	}
+	return l.Queue.Plans[plan]
+}
+
+// Queues a request of the priority until the dispatcher has reserved its n
+// global tokens, MaxWait has passed or the context is done
+func (l *Limiter) waitInQueue(ctx context.Context, priority, n int) (*rate.Reservation, error) {
+	maxWait, size := l.Queue.MaxWait, l.Queue.Size
+	if maxWait == 0 {
+		maxWait = 5 * time.Second // Use default max wait if none provided
+	}
+	if size == 0 {
+		size = 1000 // Use default size if none provided
+	}
+	weight, ok := l.Queue.Weights[priority]
+	if !ok || weight <= 0 {
+		weight = 1
+	}
+	q := &l.queue
+	q.Lock()
+	if q.waiting >= size {
+		q.Unlock()
+		return nil, errQueueFull
+	}
+	if q.classes == nil {
+		q.classes = make(map[int]*class)
+	}
+	c, ok := q.classes[priority]
+	if !ok {
+		c = &class{}
+		q.classes[priority] = c
+	}
+	start := q.virtual
+	if c.last > start {
+		start = c.last
+	}
+	w := &waiter{cost: n, finish: start + float64(n)/weight, ready: make(chan *rate.Reservation, 1)}
+	c.last = w.finish
+	c.waiters = append(c.waiters, w)
+	q.waiting++
+	q.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case res := <-w.ready:
+		if res == nil {
+			return nil, errQueueWait
+		}
+		return res, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	q.Lock()
+	if !w.served { // Still queued, so leave the queue
+		q.remove(priority, w)
+		q.Unlock()
+		return nil, errQueueWait
+	}
+	q.Unlock()
+	if res := <-w.ready; res != nil { // The dispatcher is about to hand over the tokens
+		res.Cancel()
+	}
+	return nil, errQueueWait
+}
+
+// Removes a waiter from its class
+// Must be called while holding the queue's lock
+func (q *fairQueue) remove(priority int, w *waiter) {
+	c := q.classes[priority]
+	for i, cw := range c.waiters {
+		if cw == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			q.waiting--
+			return
+		}
+	}
+}
+
+// Takes the request with the smallest finish tag from the queue, preferring
+// higher priorities on ties; returns nil if the queue is empty
+func (q *fairQueue) next() *waiter {
+	q.Lock()
+	defer q.Unlock()
+	var c *class
+	priority := 0
+	for p, pc := range q.classes {
+		if len(pc.waiters) == 0 {
+			continue
+		}
+		if c == nil {
+			c, priority = pc, p
+			continue
+		}
+		f, best := pc.waiters[0].finish, c.waiters[0].finish
+		if f < best || f == best && p > priority {
+			c, priority = pc, p
+		}
+	}
+	if c == nil {
+		return nil
+	}
+	w := c.waiters[0]
+	c.waiters[0] = nil
+	c.waiters = c.waiters[1:]
+	q.waiting--
+	q.virtual = w.finish
+	w.served = true
+	return w
+}
+
+// Hands the queued requests the global bucket's tokens as they become available,
+// in the order of the fair queue
+func (l *Limiter) serveQueue(ctx context.Context) {
+	for {
+		w := l.queue.next()
+		if w == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.queue.wake:
+			}
+			continue
+		}
+		l.Lock()
+		lim := l.globalLimiter()
+		l.Unlock()
+		now := time.Now()
+		res := lim.ReserveN(now, w.cost)
+		if !res.OK() { // More tokens than the global burst
+			w.ready <- nil
+			continue
+		}
+		if delay := res.DelayFrom(now); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				res.Cancel()
+				w.ready <- nil
+				return
+			case <-timer.C:
+			}
+		}
+		w.ready <- res
+	}
+}
@@ -0,0 +1,93 @@
+package golimiter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Why an event was denied, a stable code grouping the rules of the audit log
+// Set on denied responses as the X-RateLimit-Reason header
+type Reason string
+
+const (
+	// Not on the whitelist (or the namespace's), or the limiter is in maintenance,
+	// or the API key is unknown
+	ReasonNotWhitelisted Reason = "not_whitelisted"
+	// On the blacklist (or the namespace's), or banned by network (ASN) or TLS fingerprint
+	ReasonBlacklisted Reason = "blacklisted"
+	// Over the visitor's own rate, or its subnet's, a dimension's or a policy's
+	ReasonVisitorLimited Reason = "visitor_limited"
+	// Over the rate of the current load state, or the global bucket
+	ReasonStateLimited Reason = "state_limited"
+	// The API key's quota is exhausted
+	ReasonQuotaExceeded Reason = "quota_exceeded"
+	// The limiter failed to decide, e.g. because its store is down
+	ReasonStoreError Reason = "store_error"
+)
+
+// Descriptions of the reasons, written in the bodies of denied responses
+var reasonText = map[Reason]string{
+	ReasonNotWhitelisted: "client not allowed",
+	ReasonBlacklisted:    "client blocked",
+	ReasonVisitorLimited: "rate limit exceeded",
+	ReasonStateLimited:   "server busy, limits reduced",
+	ReasonQuotaExceeded:  "quota exceeded",
+	ReasonStoreError:     "rate limiter unavailable",
+}
+
+// Returns the reason's description
+func (r Reason) Text() string {
+	return reasonText[r]
+}
+
+// Returns the reason code of a rule of the audit log (e.g. "blacklist:<namespace>")
+func RuleReason(rule string) Reason {
+	name, _, _ := strings.Cut(rule, ":")
+	switch name {
+	case "whitelist", "maintenance", "api-key":
+		return ReasonNotWhitelisted
+	case "blacklist", "asn", "fingerprint":
+		return ReasonBlacklisted
+	case "state", "global", "queue", "max_conns":
+		return ReasonStateLimited
+	case "quota":
+		return ReasonQuotaExceeded
+	case "internal":
+		return ReasonStoreError
+	}
+	return ReasonVisitorLimited
+}
+
+// Error of a denied decision, as returned by Decision.Err
+type DenialError struct {
+	Reason     Reason
+	Rule       string        // Rule that denied the event, if known
+	RetryAfter time.Duration // Time until a retry could pass (0 if unknown)
+}
+
+func (e *DenialError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("golimiter: denied: %s (%s), retry after %v", e.Reason.Text(), e.Reason, e.RetryAfter)
+	}
+	return fmt.Sprintf("golimiter: denied: %s (%s)", e.Reason.Text(), e.Reason)
+}
+
+// Returns nil if the decision allowed the event, else a *DenialError with
+// its reason: the Reason set by Check, or one inferred from the decision
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+	reason := d.Reason
+	switch {
+	case reason != "":
+	case d.QuotaExceeded:
+		reason = ReasonQuotaExceeded
+	case d.Degraded:
+		reason = ReasonStateLimited
+	default:
+		reason = ReasonVisitorLimited
+	}
+	return &DenialError{Reason: reason, Rule: d.Rule, RetryAfter: d.RetryAfter}
+}
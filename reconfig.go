@@ -0,0 +1,181 @@
+package golimiter
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Error returned by SetRoute for a dimension the limiter does not have
+var ErrUnknownRoute = errors.New("no dimension with that name")
+
+// Sets the default rate at runtime
+// Existing visitors limited by the default rate are updated in place
+func (l *Limiter) SetRate(r rate.Limit) error {
+	if r < 0 {
+		return errors.New("rate must not be negative")
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.Rate = r
+	l.retuneVisitors(func(v *visitor) bool { return v.fixed == nil })
+	return nil
+}
+
+// Sets the default burst at runtime
+// Existing visitors limited by the default burst keep the same fraction of their bucket
+func (l *Limiter) SetBurst(b int) error {
+	if b < 0 {
+		return errors.New("burst must not be negative")
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.Burst = b
+	l.retuneVisitors(func(v *visitor) bool { return v.fixed == nil })
+	return nil
+}
+
+// Adds or replaces the named rate plan at runtime
+// Existing visitors on the plan are updated in place
+func (l *Limiter) SetPlan(name string, p Plan) error {
+	if p.Rate < 0 || p.Burst < 0 {
+		return errors.New("plan rate and burst must not be negative")
+	}
+	l.Lock()
+	defer l.Unlock()
+	plans := make(map[string]Plan, len(l.Plans)+1)
+	for n, pl := range l.Plans {
+		plans[n] = pl
+	}
+	plans[name] = p
+	l.Plans = plans
+	l.retuneVisitors(func(v *visitor) bool { return v.fixed == nil && v.plan == name })
+	return nil
+}
+
+// Adds or replaces the named policy at runtime
+// Existing buckets of the policy are updated in place
+func (l *Limiter) SetPolicy(name string, p Plan) error {
+	if p.Rate < 0 || p.Burst < 0 {
+		return errors.New("policy rate and burst must not be negative")
+	}
+	l.Lock()
+	defer l.Unlock()
+	policies := make(map[string]Plan, len(l.Policies)+1)
+	for n, pl := range l.Policies {
+		policies[n] = pl
+	}
+	policies[name] = p
+	l.Policies = policies
+	prefix := policyKey(name, "")
+	l.retuneVisitors(func(v *visitor) bool {
+		if v.fixed == nil || !strings.HasPrefix(v.key, prefix) {
+			return false
+		}
+		v.fixed = &params{rate: p.Rate, burst: p.Burst}
+		v.windows = l.windowLimiters(p.Windows)
+		return true
+	})
+	return nil
+}
+
+// Sets the rate and burst of the named dimension (e.g. an endpoint) at runtime
+// Existing keys of the dimension are updated in place
+func (l *Limiter) SetRoute(name string, r rate.Limit, b int) error {
+	if r < 0 || b < 0 {
+		return errors.New("route rate and burst must not be negative")
+	}
+	l.Lock()
+	defer l.Unlock()
+	for i := range l.Dimensions {
+		if l.Dimensions[i].Name != name {
+			continue
+		}
+		l.Dimensions[i].Rate, l.Dimensions[i].Burst = r, b
+		prefix := dimensionKey(name, "")
+		l.retuneVisitors(func(v *visitor) bool {
+			if v.fixed == nil || !strings.HasPrefix(v.key, prefix) {
+				return false
+			}
+			v.fixed = &params{rate: r, burst: b}
+			return true
+		})
+		return nil
+	}
+	return ErrUnknownRoute
+}
+
+// Gives the default limiter of the selected visitors their current params
+// Must be called while holding the lock
+func (l *Limiter) retuneVisitors(selected func(v *visitor) bool) {
+	now := time.Now()
+	for _, v := range l.visitors {
+		if !selected(v) {
+			continue
+		}
+		r, b := l.defaultParams(v)
+		if v.fixed == nil {
+			r, b = l.scale(r, b)
+		}
+		v.limiter = retune(v.limiter, r, b, now)
+	}
+}
+
+// Brings the visitor's limiters up to date with the current params if
+// ApplyToExisting is set, so changes reach visitors created before them
+// Must be called while holding the lock
+func (l *Limiter) applyExisting(v *visitor, now time.Time) {
+	if !l.ApplyToExisting || v.fixed != nil {
+		return
+	}
+	l.applyParams(v, now)
+}
+
+// Gives the visitor's limiters the current (scaled) rates and bursts in
+// place with SetLimit/SetBurst, keeping their tokens
+// Must be called while holding the lock
+func (l *Limiter) applyParams(v *visitor, now time.Time) {
+	set := func(lim *rate.Limiter, r rate.Limit, b int) {
+		if lim.Limit() != r {
+			lim.SetLimitAt(now, r)
+		}
+		if lim.Burst() != b {
+			lim.SetBurstAt(now, b)
+		}
+	}
+	if len(l.curve) == 0 { // The curve's rate is applied by applyCurve
+		r, b := l.scale(l.defaultParams(v))
+		set(v.limiter, r, b)
+	}
+	for i, p := range l.params {
+		if i < len(v.limiters) {
+			r, b := l.scale(p.rate, p.burst)
+			set(v.limiters[i], r, b)
+		}
+	}
+}
+
+// Returns a limiter with the rate and burst whose bucket is filled to the same
+// fraction as the given limiter's, so tuning neither resets nor forgets usage
+// The given limiter is returned if it already has the rate and burst
+func retune(lim *rate.Limiter, r rate.Limit, b int, now time.Time) *rate.Limiter {
+	if lim.Limit() == r && lim.Burst() == b {
+		return lim
+	}
+	if lim.Burst() == b { // Changing only the rate keeps the tokens
+		lim.SetLimitAt(now, r)
+		return lim
+	}
+	fill := 1.0
+	if lim.Burst() > 0 {
+		fill = math.Max(0, math.Min(1, lim.TokensAt(now)/float64(lim.Burst())))
+	}
+	next := rate.NewLimiter(r, b)
+	if spent := b - int(math.Round(fill*float64(b))); spent > 0 {
+		next.AllowN(now, spent)
+	}
+	return next
+}
@@ -0,0 +1,57 @@
+package golimiter
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Error of a panic recovered inside the limiter, e.g. in a KeyFunc or a store driver
+// Reported with internal_error events; the request is handled by the OnInternalError policy
+type PanicError struct {
+	Where string      // What panicked: "request" (deciding a request) or "store"
+	Value interface{} // Value the panic was raised with
+	Stack []byte      // Stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("golimiter: panic in %s: %v", e.Where, e.Value)
+}
+
+// Wraps the downstream handler so that serving it marks the request as decided,
+// after which panics are left to the server
+func decided(next http.Handler, deciding *bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*deciding = false
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Recovers a panic raised while the request was being decided, handling the
+// request by the OnInternalError policy instead
+// Must be deferred directly; panics of the downstream handler are not recovered
+func (l *Limiter) recoverRequest(w http.ResponseWriter, r *http.Request, next http.Handler, deciding *bool) {
+	if !*deciding {
+		return
+	}
+	if p := recover(); p != nil {
+		if p == http.ErrAbortHandler { // Raised to drop the connection of a denied request
+			panic(p)
+		}
+		err := &PanicError{Where: "request", Value: p, Stack: debug.Stack()}
+		l.failRequest(w, r, next, RemoteIP(r.RemoteAddr), err)
+	}
+}
+
+// Adds n to the store's counter at key, recovering a panic of the store driver
+// as an error so the breaker and the OnInternalError policy handle it
+func (l *Limiter) storeIncr(key string, n int64, ttl time.Duration) (count int64, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Where: "store", Value: p, Stack: debug.Stack()}
+			l.emit(Event{Kind: EventInternalError, Key: key, Err: err, Reason: ReasonStoreError})
+		}
+	}()
+	return l.Store.Incr(key, n, ttl)
+}
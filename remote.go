@@ -0,0 +1,114 @@
+package golimiter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Largest remote list file read; a larger response fails the fetch, and the
+// last good copy of the list is kept
+const maxListSize = 32 << 20
+
+// Last fetched copy of a remote list file, for conditional requests
+type remoteCopy struct {
+	body         []byte
+	etag         string
+	lastModified string
+}
+
+// Caches the remote list files fetched by the limiter
+type remoteLists struct {
+	sync.Mutex
+	copies map[string]*remoteCopy
+}
+
+// Checks whether a list location is an http(s) URL
+func isRemote(loc string) bool {
+	return strings.HasPrefix(loc, "https://") || strings.HasPrefix(loc, "http://")
+}
+
+// Reads a list file from disk, or fetches it if its location is a URL
+func (l *Limiter) readSource(loc string) ([]byte, error) {
+	if !isRemote(loc) {
+		return ioutil.ReadFile(loc)
+	}
+	retries := l.ListFetch.Retries
+	if retries == 0 {
+		retries = 2 // Use default retries if none provided
+	}
+	backoff := time.Second
+	var err error
+	for attempt := 0; ; attempt++ {
+		var body []byte
+		if body, err = l.fetch(loc); err == nil {
+			return body, nil
+		}
+		if attempt >= retries {
+			return nil, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Fetches a remote list file with a conditional request,
+// returning the cached copy if it hasn't changed
+func (l *Limiter) fetch(url string) ([]byte, error) {
+	l.remote.Lock()
+	cached := l.remote.copies[url]
+	l.remote.Unlock()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	client := l.ListFetch.Client
+	if client == nil {
+		timeout := l.ListFetch.Timeout
+		if timeout == 0 {
+			timeout = 10 * time.Second // Use default timeout if none provided
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.body, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxListSize {
+		return nil, fmt.Errorf("fetching %s: list is larger than %d bytes", url, maxListSize)
+	}
+	l.remote.Lock()
+	if l.remote.copies == nil {
+		l.remote.copies = make(map[string]*remoteCopy)
+	}
+	l.remote.copies[url] = &remoteCopy{
+		body:         body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	l.remote.Unlock()
+	return body, nil
+}
@@ -0,0 +1,24 @@
+package golimiter
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchRejectsOversizedList(t *testing.T) {
+	size := maxListSize
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("#\n"), size/2))
+	}))
+	defer srv.Close()
+	l := &Limiter{}
+	if body, err := l.fetch(srv.URL); err != nil || len(body) != maxListSize {
+		t.Fatalf("list at the limit: %d bytes, %v", len(body), err)
+	}
+	size += 2
+	if _, err := l.fetch(srv.URL); err == nil {
+		t.Fatal("list over the limit was read")
+	}
+}
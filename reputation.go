@@ -0,0 +1,123 @@
+package golimiter
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Multiplier of the rate and burst of visitors whose reputation is below a score
+type ReputationTier struct {
+	Below  float64 // Score (0-100) below which the tier applies
+	Factor float64 // Multiplier of the visitor's rate and burst (e.g. 0.25)
+}
+
+// Reputation kept for a visitor
+type reputation struct {
+	since    time.Time // When the visitor was added
+	demerits float64   // Decaying points lost for denials
+	at       time.Time // When the demerits were last decayed
+	factor   float64   // Multiplier of the tier applied to the visitor's params (0- none)
+}
+
+// Returns the visitor's reputation score, from 0 (worst) to 100 (best)
+// Scores start at 50 for new visitors and grow to 100 with age, less
+// decaying demerits for denials and the visitor's error ratio; ips on an
+// enabled whitelist always score 100 and on an enabled blacklist 0
+// Must be called while holding the lock
+func (l *Limiter) score(v *visitor, now time.Time) float64 {
+	if l.whitelistOn() && l.Whitelist.list.Load().Contains(v.key) {
+		return 100
+	}
+	if l.blacklistOn() && l.Blacklist.list.Load().Contains(v.key) {
+		return 0
+	}
+	maturity := l.Reputation.Maturity
+	if maturity == 0 {
+		maturity = time.Hour // Use default maturity if none provided
+	}
+	age := math.Min(1, float64(now.Sub(v.rep.since))/float64(maturity))
+	s := 50 + 50*age - l.decay(v, now) - 50*v.errRatio
+	return math.Max(0, math.Min(100, s))
+}
+
+// Decays the visitor's demerits by the time since they were last decayed and returns them
+// Must be called while holding the lock
+func (l *Limiter) decay(v *visitor, now time.Time) float64 {
+	halfLife := l.Reputation.HalfLife
+	if halfLife == 0 {
+		halfLife = 10 * time.Minute // Use default half-life if none provided
+	}
+	if v.rep.demerits > 0 {
+		v.rep.demerits *= math.Pow(0.5, float64(now.Sub(v.rep.at))/float64(halfLife))
+	}
+	v.rep.at = now
+	return v.rep.demerits
+}
+
+// Takes points off the reputation of the visitor of a denied key
+func (l *Limiter) demerit(key string) {
+	if !l.Reputation.On {
+		return
+	}
+	per := l.Reputation.PerDenial
+	if per == 0 {
+		per = 10 // Use default demerits if none provided
+	}
+	l.Lock()
+	defer l.Unlock()
+	if v, ok := l.visitors[key]; ok && v.fixed == nil {
+		v.rep.demerits = l.decay(v, time.Now()) + per
+	}
+}
+
+// Gives the visitor the multiplier of the lowest tier its score is below,
+// updating its default limiter if the tier changed
+// Must be called while holding the lock
+func (l *Limiter) applyReputation(v *visitor, now time.Time) {
+	if !l.Reputation.On || len(l.Reputation.Tiers) == 0 || v.fixed != nil {
+		return
+	}
+	s, factor, below := l.score(v, now), 0.0, math.Inf(1)
+	for _, t := range l.Reputation.Tiers {
+		if s < t.Below && t.Below < below {
+			factor, below = t.Factor, t.Below
+		}
+	}
+	if factor == v.rep.factor {
+		return
+	}
+	v.rep.factor = factor
+	r, b := l.scale(l.defaultParams(v))
+	v.limiter = retune(v.limiter, r, b, now)
+}
+
+// Multiplies the params by the visitor's reputation tier, if it has one
+func (v *visitor) reputed(r rate.Limit, b int) (rate.Limit, int) {
+	if v.rep.factor <= 0 {
+		return r, b
+	}
+	if r != rate.Inf {
+		r *= rate.Limit(v.rep.factor)
+	}
+	if b = int(float64(b) * v.rep.factor); b < 1 {
+		b = 1
+	}
+	return r, b
+}
+
+// Returns the reputation score (0-100) of the visitor with the key, if
+// Reputation is on and the limiter has a visitor for the key
+func (l *Limiter) Score(key string) (float64, bool) {
+	if !l.Reputation.On {
+		return 0, false
+	}
+	l.Lock()
+	defer l.Unlock()
+	v, ok := l.visitors[key]
+	if !ok || v.fixed != nil {
+		return 0, false
+	}
+	return l.score(v, time.Now()), true
+}
@@ -0,0 +1,243 @@
+// Package rls serves the Envoy ratelimit v3 gRPC protocol backed by a
+// golimiter.Limiter, so Envoy/Istio sidecars can delegate rate decisions to it
+package rls
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/i-norden/golimiter"
+	"golang.org/x/time/rate"
+
+	rlcommon "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc"
+)
+
+// Server implements the envoy.service.ratelimit.v3.RateLimitService
+type Server struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	Limiter *golimiter.Limiter // Limiter the decisions are delegated to
+	// Maps a descriptor to the visitor key it is limited under
+	// If nil, DescriptorKey is used
+	KeyFunc   func(domain string, d *rlcommon.RateLimitDescriptor) string
+	mu        sync.Mutex
+	rules     []Rule          // Set with SetRules
+	overrides map[string]bool // Policies registered for descriptors' limit overrides
+}
+
+// A rule giving the descriptors it matches a rate and burst of their own,
+// e.g. those of a route (generic_key=checkout) or of each user on a route
+// (generic_key=checkout, user_id=any)
+// Descriptors matched by no rule are limited by the limiter's defaults
+type Rule struct {
+	Name      string  // Name of the rule, whose buckets are kept under the policy "rls:<name>"
+	Domain    string  // Domain of the descriptors matched (any if empty)
+	Entries   []Entry // Entries of the descriptors matched, all of them and in order
+	Rate      rate.Limit
+	Burst     int
+	Unlimited bool // Matching descriptors are always allowed
+}
+
+// An entry of a rule, matching the descriptor entry with the key and value
+type Entry struct {
+	Key   string
+	Value string // Any value if empty, each value being limited separately
+}
+
+// Creates a new Server backed by the given (initialized) limiter
+func NewServer(l *golimiter.Limiter) *Server {
+	return &Server{Limiter: l}
+}
+
+// Registers the server with a grpc.Server
+func (s *Server) Register(gs *grpc.Server) {
+	rlsv3.RegisterRateLimitServiceServer(gs, s)
+}
+
+// Sets the rules descriptors are matched against, replacing the previous ones
+// The first matching rule applies; a descriptor's own limit override (set by
+// Envoy from the route's dynamic metadata) applies instead of the rule's rate
+// and burst
+func (s *Server) SetRules(rules []Rule) error {
+	names := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		if r.Name == "" || names[r.Name] {
+			return errors.New("rls: rule names must be set and unique")
+		}
+		names[r.Name] = true
+		if len(r.Entries) == 0 {
+			return errors.New("rls: rule " + r.Name + " has no entries")
+		}
+		if r.Unlimited {
+			continue
+		}
+		if err := s.Limiter.SetPolicy(rulePolicy(r.Name), golimiter.Plan{Rate: r.Rate, Burst: r.Burst}); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.rules = rules
+	s.mu.Unlock()
+	return nil
+}
+
+// Returns the policy a rule's buckets are kept under
+func rulePolicy(name string) string {
+	return "rls:" + name
+}
+
+// Returns the first of the rules matching the descriptor, nil if none does
+func (s *Server) match(domain string, d *rlcommon.RateLimitDescriptor) *Rule {
+	s.mu.Lock()
+	rules := s.rules
+	s.mu.Unlock()
+	entries := d.GetEntries()
+	for i, r := range rules {
+		if (r.Domain != "" && r.Domain != domain) || len(r.Entries) != len(entries) {
+			continue
+		}
+		matches := true
+		for j, e := range r.Entries {
+			if e.Key != entries[j].GetKey() || (e.Value != "" && e.Value != entries[j].GetValue()) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// Returns the policy limiting descriptors with the override, registering it
+// with the limiter the first time it is seen
+// An override refills its requests evenly over its unit, all of them
+// allowed at once
+func (s *Server) overridePolicy(o *rlcommon.RateLimitDescriptor_RateLimitOverride) (string, error) {
+	var unit time.Duration
+	switch o.GetUnit() {
+	case typev3.RateLimitUnit_SECOND:
+		unit = time.Second
+	case typev3.RateLimitUnit_MINUTE:
+		unit = time.Minute
+	case typev3.RateLimitUnit_HOUR:
+		unit = time.Hour
+	case typev3.RateLimitUnit_DAY:
+		unit = 24 * time.Hour
+	case typev3.RateLimitUnit_MONTH:
+		unit = 30 * 24 * time.Hour
+	case typev3.RateLimitUnit_YEAR:
+		unit = 365 * 24 * time.Hour
+	default:
+		return "", errors.New("rls: unknown limit override unit")
+	}
+	rpu := o.GetRequestsPerUnit()
+	name := "rls:override:" + strconv.FormatUint(uint64(rpu), 10) + "/" + o.GetUnit().String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.overrides[name] {
+		p := golimiter.Plan{Rate: rate.Limit(float64(rpu) / unit.Seconds()), Burst: int(rpu)}
+		if err := s.Limiter.SetPolicy(name, p); err != nil {
+			return "", err
+		}
+		if s.overrides == nil {
+			s.overrides = make(map[string]bool)
+		}
+		s.overrides[name] = true
+	}
+	return name, nil
+}
+
+// Checks each descriptor in the request against the limiter, under the
+// policy of its limit override or matching rule if it has one
+// Each status carries the limit applied and, if allowed, the requests left
+// under it (those of the local limiters, 0 with a Store)
+// The overall code is OVER_LIMIT if any of the descriptors are over their limit
+func (s *Server) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	hits := int(req.GetHitsAddend())
+	if hits == 0 {
+		hits = 1 // Envoy treats an unset addend as a single hit
+	}
+	keyFunc := s.KeyFunc
+	if keyFunc == nil {
+		keyFunc = DescriptorKey
+	}
+	resp := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	for _, d := range req.GetDescriptors() {
+		key := keyFunc(req.GetDomain(), d)
+		rule := s.match(req.GetDomain(), d)
+		var dec golimiter.Decision
+		var limit *rlsv3.RateLimitResponse_RateLimit
+		switch {
+		case d.GetLimit() != nil:
+			policy, err := s.overridePolicy(d.GetLimit())
+			if err != nil {
+				return nil, err
+			}
+			dec = s.Limiter.Policy(policy).CheckN(key, hits)
+			limit = &rlsv3.RateLimitResponse_RateLimit{
+				Name:            policy,
+				RequestsPerUnit: d.GetLimit().GetRequestsPerUnit(),
+				Unit:            rlsv3.RateLimitResponse_RateLimit_Unit(d.GetLimit().GetUnit()), // Numbered alike
+			}
+		case rule == nil:
+			dec = s.Limiter.CheckN(key, hits) // All of the hits are allowed or none are
+			limit = rateLimit("", s.Limiter.Rate)
+		case rule.Unlimited:
+			dec.Allowed = true
+		default:
+			dec = s.Limiter.Policy(rulePolicy(rule.Name)).CheckN(key, hits)
+			limit = rateLimit(rule.Name, rule.Rate)
+		}
+		status := &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK, CurrentLimit: limit}
+		if !dec.Allowed {
+			status.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+			resp.OverallCode = status.Code
+		} else if limit != nil {
+			status.LimitRemaining = uint32(dec.Remaining)
+		}
+		resp.Statuses = append(resp.Statuses, status)
+	}
+	return resp, nil
+}
+
+// Returns the named limit of the rate in requests per the shortest unit it
+// allows a whole request in, nil if the rate is unlimited
+func rateLimit(name string, r rate.Limit) *rlsv3.RateLimitResponse_RateLimit {
+	if r == rate.Inf {
+		return nil
+	}
+	units := []struct {
+		unit rlsv3.RateLimitResponse_RateLimit_Unit
+		secs float64
+	}{
+		{rlsv3.RateLimitResponse_RateLimit_SECOND, 1},
+		{rlsv3.RateLimitResponse_RateLimit_MINUTE, 60},
+		{rlsv3.RateLimitResponse_RateLimit_HOUR, 60 * 60},
+		{rlsv3.RateLimitResponse_RateLimit_DAY, 24 * 60 * 60},
+	}
+	for _, u := range units {
+		if rpu := float64(r) * u.secs; rpu >= 1 || u.unit == rlsv3.RateLimitResponse_RateLimit_DAY {
+			return &rlsv3.RateLimitResponse_RateLimit{Name: name, RequestsPerUnit: uint32(math.Round(rpu)), Unit: u.unit}
+		}
+	}
+	return nil
+}
+
+// Default descriptor to key mapping
+// Joins the domain and the descriptor's entries as domain|key=value|key=value
+func DescriptorKey(domain string, d *rlcommon.RateLimitDescriptor) string {
+	parts := []string{domain}
+	for _, e := range d.GetEntries() {
+		parts = append(parts, e.GetKey()+"="+e.GetValue())
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,125 @@
+package rls
+
+import (
+	"context"
+	"testing"
+
+	"github.com/i-norden/golimiter"
+	"golang.org/x/time/rate"
+
+	rlcommon "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+)
+
+// Returns a descriptor of the key value pairs
+func descriptor(kv ...string) *rlcommon.RateLimitDescriptor {
+	d := &rlcommon.RateLimitDescriptor{}
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, &rlcommon.RateLimitDescriptor_Entry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+// Returns a server with the rules over a limiter of the rate and burst
+func newServer(t *testing.T, r rate.Limit, burst int, rules []Rule) *Server {
+	l := &golimiter.Limiter{Rate: r, Burst: burst}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(l)
+	if err := s.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Returns the single status of the server's response to the descriptor
+func check(t *testing.T, s *Server, domain string, d *rlcommon.RateLimitDescriptor) *rlsv3.RateLimitResponse_DescriptorStatus {
+	resp, err := s.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{Domain: domain, Descriptors: []*rlcommon.RateLimitDescriptor{d}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Statuses) != 1 || resp.OverallCode != resp.Statuses[0].Code {
+		t.Fatalf("got response %v", resp)
+	}
+	return resp.Statuses[0]
+}
+
+func TestMatch(t *testing.T) {
+	s := newServer(t, 1, 1, []Rule{
+		{Name: "checkout", Entries: []Entry{{Key: "generic_key", Value: "checkout"}}, Rate: 1, Burst: 1},
+		{Name: "search-user", Domain: "edge", Entries: []Entry{{Key: "generic_key", Value: "search"}, {Key: "user_id"}}, Rate: 1, Burst: 1},
+		{Name: "search", Entries: []Entry{{Key: "generic_key", Value: "search"}, {Key: "user_id"}}, Rate: 1, Burst: 1},
+		{Name: "any-path", Entries: []Entry{{Key: "path"}}, Unlimited: true},
+		{Name: "healthz", Entries: []Entry{{Key: "path", Value: "/healthz"}}, Unlimited: true},
+	})
+	for _, tc := range []struct {
+		name   string
+		domain string
+		d      *rlcommon.RateLimitDescriptor
+		rule   string // "" if none matches
+	}{
+		{"value", "edge", descriptor("generic_key", "checkout"), "checkout"},
+		{"any domain", "internal", descriptor("generic_key", "checkout"), "checkout"},
+		{"other value", "edge", descriptor("generic_key", "cart"), ""},
+		{"other key", "edge", descriptor("route", "checkout"), ""},
+		{"domain", "edge", descriptor("generic_key", "search", "user_id", "a"), "search-user"},
+		{"other domain", "internal", descriptor("generic_key", "search", "user_id", "a"), "search"},
+		{"entries out of order", "edge", descriptor("user_id", "a", "generic_key", "search"), ""},
+		{"fewer entries", "edge", descriptor("generic_key", "search"), ""},
+		{"more entries", "edge", descriptor("generic_key", "checkout", "user_id", "a"), ""},
+		{"any value", "edge", descriptor("path", "/login"), "any-path"},
+		{"first match wins", "edge", descriptor("path", "/healthz"), "any-path"},
+		{"no entries", "edge", descriptor(), ""},
+	} {
+		got := ""
+		if r := s.match(tc.domain, tc.d); r != nil {
+			got = r.Name
+		}
+		if got != tc.rule {
+			t.Errorf("%s: matched %q, want %q", tc.name, got, tc.rule)
+		}
+	}
+}
+
+func TestStatusLimits(t *testing.T) {
+	s := newServer(t, 10, 3, []Rule{
+		{Name: "checkout", Entries: []Entry{{Key: "generic_key", Value: "checkout"}}, Rate: 0.5, Burst: 2},
+		{Name: "healthz", Entries: []Entry{{Key: "path", Value: "/healthz"}}, Unlimited: true},
+	})
+	override := descriptor("generic_key", "upload")
+	override.Limit = &rlcommon.RateLimitDescriptor_RateLimitOverride{RequestsPerUnit: 4, Unit: typev3.RateLimitUnit_HOUR}
+	for _, tc := range []struct {
+		name      string
+		d         *rlcommon.RateLimitDescriptor
+		limit     *rlsv3.RateLimitResponse_RateLimit
+		remaining []uint32 // Of each request until denied
+	}{
+		{"defaults", descriptor("generic_key", "cart"),
+			&rlsv3.RateLimitResponse_RateLimit{RequestsPerUnit: 10, Unit: rlsv3.RateLimitResponse_RateLimit_SECOND}, []uint32{2, 1, 0}},
+		{"rule", descriptor("generic_key", "checkout"),
+			&rlsv3.RateLimitResponse_RateLimit{Name: "checkout", RequestsPerUnit: 30, Unit: rlsv3.RateLimitResponse_RateLimit_MINUTE}, []uint32{1, 0}},
+		{"override", override,
+			&rlsv3.RateLimitResponse_RateLimit{Name: "rls:override:4/HOUR", RequestsPerUnit: 4, Unit: rlsv3.RateLimitResponse_RateLimit_HOUR}, []uint32{3, 2, 1, 0}},
+	} {
+		for i, want := range tc.remaining {
+			st := check(t, s, "edge", tc.d)
+			if st.Code != rlsv3.RateLimitResponse_OK || st.LimitRemaining != want {
+				t.Fatalf("%s: request %d got %v, want %d remaining", tc.name, i, st, want)
+			}
+			if l := st.CurrentLimit; l.GetName() != tc.limit.Name || l.GetRequestsPerUnit() != tc.limit.RequestsPerUnit || l.GetUnit() != tc.limit.Unit {
+				t.Fatalf("%s: limit %v, want %v", tc.name, l, tc.limit)
+			}
+		}
+		if st := check(t, s, "edge", tc.d); st.Code != rlsv3.RateLimitResponse_OVER_LIMIT || st.CurrentLimit == nil || st.LimitRemaining != 0 {
+			t.Fatalf("%s: got %v past the limit", tc.name, st)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if st := check(t, s, "edge", descriptor("path", "/healthz")); st.Code != rlsv3.RateLimitResponse_OK || st.CurrentLimit != nil {
+			t.Fatalf("unlimited rule got %v", st)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package golimiter
+
+import (
+	"hash/fnv"
+	"net/http"
+)
+
+// Decides per key whether denials are enforced or only shadowed, e.g. through
+// a feature-flag system, so new limits can be rolled out gradually: enforced
+// for a share of the keys and shadowed for the rest, then ramped up
+// Only consulted in Enforce mode; the other modes apply to every key
+type RolloutProvider interface {
+	// Returns whether the denial of the key is enforced; if not it is let
+	// through and counted as in shadow mode
+	// r is the denied request, nil for connections and keyed calls
+	Enforce(key string, r *http.Request) bool
+}
+
+// Adapter to use a func as a RolloutProvider, e.g. one evaluating a flag
+type RolloutFunc func(key string, r *http.Request) bool
+
+func (f RolloutFunc) Enforce(key string, r *http.Request) bool {
+	return f(key, r)
+}
+
+// RolloutProvider enforcing denials for a percentage of the keys, e.g.
+// RolloutPercent(10), chosen by a hash of the key so each key is treated
+// alike on every instance; the keys enforced at a percentage stay enforced
+// as it is raised
+type RolloutPercent float64
+
+func (p RolloutPercent) Enforce(key string, r *http.Request) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()%10000) < float64(p)*100
+}
+
+// Reports whether the denial of the key is let through: in shadow mode, or
+// in Enforce mode if the Rollout provider doesn't enforce it for the key
+func (l *Limiter) shadows(key string, r *http.Request) bool {
+	switch l.Mode() {
+	case Shadow:
+		return true
+	case Enforce:
+		return l.Rollout != nil && !l.Rollout.Enforce(key, r)
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package golimiter
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// A parsed Dimension.Match pattern
+type route struct {
+	host    string // Host matched exactly, "" for any host
+	suffix  string // Domain whose subdomains are matched, for "*." hosts
+	path    string // Path matched, without its trailing slash
+	prefix  bool   // Whether paths under the path are matched too, for "/*" paths
+	anyPath bool   // Whether the pattern has no path, matching every path
+}
+
+// Parses a pattern of the form [host][/path], e.g. "api.example.com/*",
+// "*.example.com/v1/*", "static.example.com" or "/login"
+// Hosts can start with "*." to match any subdomain, or be "*" for any host;
+// paths can end in "/*" to match everything under them
+func parseRoute(pattern string) (route, error) {
+	var rt route
+	host, path := pattern, ""
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		host, path = pattern[:i], pattern[i:]
+	} else {
+		rt.anyPath = true
+	}
+	host = strings.ToLower(host)
+	switch {
+	case host == "" || host == "*":
+	case strings.HasPrefix(host, "*."):
+		rt.suffix = host[1:]
+	default:
+		rt.host = host
+	}
+	if strings.Contains(rt.host, "*") || strings.Contains(rt.suffix, "*") {
+		return rt, errors.New("hosts can only have a leading wildcard")
+	}
+	if strings.HasSuffix(path, "/*") {
+		rt.prefix, path = true, strings.TrimSuffix(path, "*")
+	}
+	if strings.Contains(path, "*") {
+		return rt, errors.New("paths can only have a trailing wildcard")
+	}
+	rt.path = strings.TrimSuffix(path, "/")
+	return rt, nil
+}
+
+// Checks whether the request matches the route
+// Trailing slashes are ignored, so "/login" also matches "/login/" and
+// "/v1/*" also matches "/v1"
+func (rt route) matches(r *http.Request) bool {
+	if rt.host != "" || rt.suffix != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(host)
+		if rt.host != "" && host != rt.host || rt.suffix != "" && !strings.HasSuffix(host, rt.suffix) {
+			return false
+		}
+	}
+	if rt.anyPath {
+		return true
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	return path == rt.path || rt.prefix && strings.HasPrefix(path, rt.path+"/")
+}
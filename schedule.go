@@ -0,0 +1,172 @@
+package golimiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A scheduled window during which Rate and Burst replace the limiter's
+// default Rate and Burst (e.g. stricter limits during business hours,
+// relaxed ones overnight, special ones during maintenance)
+type Window struct {
+	Name string // Window name, for reference
+	// Cron expression of the minutes during which the window is active,
+	// as "minute hour day-of-month month day-of-week" fields supporting
+	// *, lists (1,2), ranges (1-5) and steps (*/15, 0-30/5)
+	// e.g. "* 9-16 * * 1-5" is active from 9:00 to 16:59 on weekdays
+	// As in standard cron, Sunday is day-of-week 0 or 7, and if both
+	// day-of-month and day-of-week are restricted (don't start with *)
+	// a day matching either of them matches, e.g. "* * 1 * 1" is the 1st
+	// of every month and every Monday
+	Cron  string
+	Rate  rate.Limit
+	Burst int
+}
+
+// A parsed window
+type window struct {
+	fields [5]uint64 // Bitsets of the matching values for each cron field
+	anyDay bool      // Whether day-of-month and day-of-week are both restricted, so either may match
+	params params
+}
+
+// Ranges of the cron fields
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// Indexes of the day fields
+const (
+	cronDayOfMonth = 2
+	cronDayOfWeek  = 4
+)
+
+// Parses the limiter's schedule windows
+// Must be called while holding the lock
+func (l *Limiter) parseSchedule() error {
+	l.windows = make([]window, len(l.Schedule.Windows))
+	for i, w := range l.Schedule.Windows {
+		pw, err := parseWindow(w)
+		if err != nil {
+			return err
+		}
+		l.windows[i] = pw
+	}
+	l.window = -1
+	return nil
+}
+
+// Parses a schedule window's cron expression
+func parseWindow(w Window) (window, error) {
+	var pw window
+	fields := strings.Fields(w.Cron)
+	if len(fields) != 5 {
+		return pw, fmt.Errorf("schedule window %q: expected 5 cron fields, got %d", w.Name, len(fields))
+	}
+	for f, field := range fields {
+		bits, err := parseCronField(field, cronBounds[f][0], cronBounds[f][1])
+		if err != nil {
+			return pw, fmt.Errorf("schedule window %q: %v", w.Name, err)
+		}
+		pw.fields[f] = bits
+	}
+	if pw.fields[cronDayOfWeek]&(1<<7) != 0 { // 7 is Sunday too
+		pw.fields[cronDayOfWeek] = pw.fields[cronDayOfWeek]&^(1<<7) | 1
+	}
+	pw.anyDay = !strings.HasPrefix(fields[cronDayOfMonth], "*") && !strings.HasPrefix(fields[cronDayOfWeek], "*")
+	pw.params = params{rate: w.Rate, burst: w.Burst}
+	return pw, nil
+}
+
+// Parses a single cron field into a bitset of the values it matches
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step, part = s, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", field)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Reports whether the window is active at the time
+func (w window) matches(t time.Time) bool {
+	values := [5]int{t.Minute(), t.Hour(), t.Day(), int(t.Month()), int(t.Weekday())}
+	match := func(f int) bool { return w.fields[f]&(1<<uint(values[f])) != 0 }
+	if !match(0) || !match(1) || !match(3) {
+		return false
+	}
+	if w.anyDay {
+		return match(cronDayOfMonth) || match(cronDayOfWeek)
+	}
+	return match(cronDayOfMonth) && match(cronDayOfWeek)
+}
+
+// Re-evaluates which window is active once per minute
+// Must be called while holding the lock
+func (l *Limiter) updateSchedule(now time.Time) {
+	if len(l.windows) == 0 {
+		return
+	}
+	minute := now.Unix() / 60
+	if minute == l.winMinute {
+		return
+	}
+	l.winMinute = minute
+	loc := l.Schedule.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t := now.In(loc)
+	l.window = -1
+	for i, w := range l.windows {
+		if w.matches(t) { // The first matching window wins
+			l.window = i
+			break
+		}
+	}
+}
+
+// Brings the visitor's default limiter in line with the active window
+// Fixed params (policies, dimensions, subnets, ...) replace the schedule
+// Must be called while holding the lock
+func (l *Limiter) applySchedule(v *visitor) {
+	if len(l.windows) == 0 || v.fixed != nil {
+		return
+	}
+	r, b := l.scale(l.defaultParams(v))
+	now := time.Now()
+	if v.limiter.Limit() != r {
+		v.limiter.SetLimitAt(now, r)
+	}
+	if v.limiter.Burst() != b {
+		v.limiter.SetBurstAt(now, b)
+	}
+}
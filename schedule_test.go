@@ -0,0 +1,111 @@
+package golimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronFields(t *testing.T) {
+	for _, c := range []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"5", 0, 59, []int{5}},
+		{"1,3", 0, 6, []int{1, 3}},
+		{"9-12", 0, 23, []int{9, 10, 11, 12}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"0-30/10,45", 0, 59, []int{0, 10, 20, 30, 45}},
+	} {
+		bits, err := parseCronField(c.field, c.min, c.max)
+		if err != nil {
+			t.Fatalf("%q: %v", c.field, err)
+		}
+		var want uint64
+		for _, v := range c.want {
+			want |= 1 << uint(v)
+		}
+		if bits != want {
+			t.Errorf("%q: got %b, want %b", c.field, bits, want)
+		}
+	}
+	for _, field := range []string{"60", "5-1", "*/0", "a", "1-b"} {
+		if _, err := parseCronField(field, 0, 59); err == nil {
+			t.Errorf("%q accepted", field)
+		}
+	}
+	if _, err := parseWindow(Window{Cron: "* * * *"}); err == nil {
+		t.Error("4 fields accepted")
+	}
+	if _, err := parseWindow(Window{Cron: "* * * * 8"}); err == nil {
+		t.Error("day-of-week 8 accepted")
+	}
+}
+
+func TestCronDays(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) } // Oct 1 2026 is a Thursday
+	for _, c := range []struct {
+		cron    string
+		matches []int
+		misses  []int
+	}{
+		{"* * 1 * 1", []int{1, 19, 26}, []int{2, 20}}, // Both restricted: either matches
+		{"* * * * 7", []int{18, 25}, []int{19}},       // 7 is Sunday
+		{"* * * * 0", []int{18}, []int{17}},
+		{"* * * * 5-7", []int{16, 17, 18}, []int{19}},
+		{"* * 1 * *", []int{1}, []int{19}},
+		{"* * */10 * 1", nil, []int{1, 19, 20}}, // A day field starting with * is ANDed
+		{"* * * * 1-5", []int{16, 19}, []int{17, 18}},
+		{"* 9-16 * * *", nil, nil},
+	} {
+		w, err := parseWindow(Window{Name: c.cron, Cron: c.cron})
+		if err != nil {
+			t.Fatalf("%q: %v", c.cron, err)
+		}
+		for _, d := range c.matches {
+			if !w.matches(day(d)) {
+				t.Errorf("%q doesn't match Oct %d", c.cron, d)
+			}
+		}
+		for _, d := range c.misses {
+			if w.matches(day(d)) {
+				t.Errorf("%q matches Oct %d", c.cron, d)
+			}
+		}
+	}
+	w, _ := parseWindow(Window{Cron: "* 9-16 * * *"})
+	if !w.matches(time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)) || w.matches(time.Date(2026, 10, 1, 17, 0, 0, 0, time.UTC)) {
+		t.Error("hours not matched")
+	}
+}
+
+func TestScheduleSkipsFixedBuckets(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.Cleanup.Off = true
+	l.Replicas.Count = 2
+	l.Policies = map[string]Plan{"search": {Rate: 0.001, Burst: 4}}
+	l.Schedule.Windows = []Window{{Name: "always", Cron: "* * * * *", Rate: 100, Burst: 100}}
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// The policy's bucket keeps its burst of 4, neither divided by the
+	// replica count nor replaced by the window
+	p := l.Policy("search")
+	for i := 0; i < 4; i++ {
+		if !p.AllowKey("a") {
+			t.Fatalf("policy request %d denied", i)
+		}
+	}
+	if p.AllowKey("a") {
+		t.Fatal("policy bucket was given the window's burst")
+	}
+	// Default buckets follow the window, divided by the replica count
+	l.AllowKey("a")
+	l.Lock()
+	burst := l.visitors["a"].limiter.Burst()
+	l.Unlock()
+	if burst != 50 {
+		t.Fatalf("default bucket burst %d, want the window's 100 over 2 replicas", burst)
+	}
+}
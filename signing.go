@@ -0,0 +1,82 @@
+package golimiter
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	c "github.com/i-norden/golimiter/common"
+)
+
+// Returned when a list file's signature is missing or doesn't match
+var ErrListSignature = errors.New("list file signature is missing or invalid")
+
+// Prefix of the first line of a list file carrying an embedded HMAC of the rest of the file
+const embeddedMAC = "#hmac-sha256:"
+
+// Reads (or fetches) a list file, verifying its signature if list signing is set up,
+// and parses its entries
+func (l *Limiter) readList(filename, format string) (c.ParseResult, error) {
+	if format == "" {
+		format = c.FormatByExt(filename)
+	}
+	path := resolveList(filename)
+	raw, err := l.readSource(path)
+	if err != nil {
+		return c.ParseResult{}, err
+	}
+	if raw, err = l.verifyList(signedPath(filename, path), raw); err != nil {
+		return c.ParseResult{}, err
+	}
+	return c.ParseEntries(raw, format)
+}
+
+// Verifies the list file's contents and returns the part that was signed
+// The HMAC may be embedded as a first line of "#hmac-sha256:<hex>"; otherwise
+// a detached hex HMAC or ed25519 signature is read from the file's path + ".sig"
+func (l *Limiter) verifyList(filename string, raw []byte) ([]byte, error) {
+	secret, pub := l.ListSigning.Secret, l.ListSigning.PublicKey
+	if len(secret) == 0 && len(pub) == 0 {
+		return raw, nil
+	}
+	if len(secret) > 0 && bytes.HasPrefix(raw, []byte(embeddedMAC)) {
+		nl := bytes.IndexByte(raw, '\n')
+		if nl < 0 {
+			return nil, ErrListSignature
+		}
+		sig, err := hex.DecodeString(string(bytes.TrimSpace(raw[len(embeddedMAC):nl])))
+		body := raw[nl+1:]
+		if err != nil || !hmac.Equal(sig, listMAC(secret, body)) {
+			return nil, ErrListSignature
+		}
+		return body, nil
+	}
+	if filename == "" {
+		return nil, ErrListSignature // Lists not read from a file can only embed their HMAC
+	}
+	detached, err := l.readSource(filename + ".sig")
+	if err != nil {
+		return nil, ErrListSignature
+	}
+	sig, err := hex.DecodeString(string(bytes.TrimSpace(detached)))
+	if err != nil {
+		return nil, ErrListSignature
+	}
+	if len(pub) > 0 && ed25519.Verify(pub, raw, sig) {
+		return raw, nil
+	}
+	if len(secret) > 0 && hmac.Equal(sig, listMAC(secret, raw)) {
+		return raw, nil
+	}
+	return nil, ErrListSignature
+}
+
+// Returns the HMAC-SHA256 of a list file's contents
+func listMAC(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
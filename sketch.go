@@ -0,0 +1,70 @@
+package golimiter
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Count-min sketch of how often keys were seen in the current window,
+// used to admit unknown keys as visitors only once they have been seen enough
+type sketch struct {
+	sync.Mutex
+	rows   [][]uint32
+	window time.Duration
+	reset  time.Time // When the counts were last cleared
+}
+
+// Creates a sketch of depth rows of width counters, cleared every window
+func newSketch(width, depth int, window time.Duration) *sketch {
+	s := &sketch{rows: make([][]uint32, depth), window: window, reset: time.Now()}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, width)
+	}
+	return s
+}
+
+// Adds n sightings of the key and returns the estimated sightings in the window
+// The estimate never undercounts, but may overcount on hash collisions
+func (s *sketch) add(key string, n int) uint32 {
+	s.Lock()
+	defer s.Unlock()
+	if now := time.Now(); now.Sub(s.reset) >= s.window {
+		for _, row := range s.rows {
+			for i := range row {
+				row[i] = 0
+			}
+		}
+		s.reset = now
+	}
+	min := ^uint32(0)
+	for i, row := range s.rows {
+		h := fnv.New32a()
+		h.Write([]byte{byte(i)}) // Seed each row's hash differently
+		h.Write([]byte(key))
+		c := &row[h.Sum32()%uint32(len(row))]
+		*c += uint32(n)
+		if *c < min {
+			min = *c
+		}
+	}
+	return min
+}
+
+// Reports whether the key should be limited by a full visitor
+// Keys that are not yet visitors are only admitted once the sketch has seen
+// them Admission.Threshold times in its window; until then their events are
+// allowed without allocating a visitor, which keeps floods of spoofed
+// addresses from filling the visitors map
+func (l *Limiter) admit(key string, n int) bool {
+	if l.sketch == nil {
+		return true
+	}
+	l.Lock()
+	_, known := l.visitors[key]
+	l.Unlock()
+	if known {
+		return true
+	}
+	return int(l.sketch.add(key, n)) >= l.Admission.Threshold
+}
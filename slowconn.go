@@ -0,0 +1,116 @@
+package golimiter
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A connection watched for drip-fed bytes (Slowloris)
+type slowConn struct {
+	net.Conn
+	l      *Limiter
+	ip     string
+	read   int64         // Bytes read in the current window, accessed atomically
+	header int32         // Set to 1 once the first headers have ended, accessed atomically
+	tail   []byte        // Last bytes read, to find the end of the headers across reads
+	done   chan struct{} // Closed to stop watching
+	once   sync.Once
+}
+
+// Wraps the connection to be watched if slow connection protection is on
+// The returned func stops watching it
+func (l *Limiter) watchConn(conn net.Conn, ip string) (net.Conn, func()) {
+	if l.SlowConns.HeaderTimeout <= 0 && l.SlowConns.MinRate <= 0 {
+		return conn, func() {}
+	}
+	c := &slowConn{Conn: conn, l: l, ip: ip, done: make(chan struct{})}
+	if l.SlowConns.HeaderTimeout <= 0 {
+		c.header = 1
+	}
+	go c.watch()
+	return c, c.stop
+}
+
+func (c *slowConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	if n > 0 && atomic.LoadInt32(&c.header) == 0 {
+		buf := append(c.tail, p[:n]...)
+		if bytes.Contains(buf, []byte("\r\n\r\n")) || bytes.Contains(buf, []byte("\n\n")) {
+			atomic.StoreInt32(&c.header, 1)
+		}
+		if len(buf) > 3 {
+			buf = buf[len(buf)-3:]
+		}
+		c.tail = append(c.tail[:0], buf...)
+	}
+	return n, err
+}
+
+func (c *slowConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// Stops watching the connection
+func (c *slowConn) stop() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// Closes the connection if it hasn't ended its first headers in time, or if
+// it sent some, but too few, bytes in a window; idle connections are left alone
+func (c *slowConn) watch() {
+	var header, tick <-chan time.Time
+	if atomic.LoadInt32(&c.header) == 0 {
+		t := time.NewTimer(c.l.SlowConns.HeaderTimeout)
+		defer t.Stop()
+		header = t.C
+	}
+	window := c.l.SlowConns.Window
+	if window == 0 {
+		window = 10 * time.Second // Use default window if none provided
+	}
+	if c.l.SlowConns.MinRate > 0 {
+		t := time.NewTicker(window)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-header:
+			header = nil
+			if atomic.LoadInt32(&c.header) == 0 && c.l.closeSlow(c) {
+				return
+			}
+		case <-tick:
+			n := atomic.SwapInt64(&c.read, 0)
+			if n > 0 && float64(n) < c.l.SlowConns.MinRate*window.Seconds() && c.l.closeSlow(c) {
+				return
+			}
+		}
+	}
+}
+
+// Closes a slow connection, and blacklists its ip if configured to
+// In shadow mode the connection is only recorded
+// Returns whether the connection was closed
+func (l *Limiter) closeSlow(c *slowConn) bool {
+	shadow := l.shadows(c.ip, nil)
+	l.audit(AuditEntry{Key: c.ip, Rule: "slow", Shadow: shadow})
+	if shadow {
+		atomic.AddUint64(&l.shadowed, 1)
+		return false
+	}
+	atomic.AddUint64(&l.denied, 1)
+	c.Close()
+	l.emit(Event{Kind: EventSlowConn, Key: c.ip})
+	if l.SlowConns.Blacklist {
+		l.Ban(c.ip, l.SlowConns.BanFor, "slow connection")
+	}
+	return true
+}
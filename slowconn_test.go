@@ -0,0 +1,45 @@
+package golimiter
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSlowConnBlacklisted(t *testing.T) {
+	l := &Limiter{Rate: 100, Burst: 100}
+	l.Cleanup.Off = true
+	l.Blacklist.On = true
+	l.Blacklist.Filename = filepath.Join(t.TempDir(), "blacklist")
+	os.WriteFile(l.Blacklist.Filename, nil, 0644)
+	l.SlowConns.HeaderTimeout = 20 * time.Millisecond
+	l.SlowConns.Blacklist = true
+	l.SlowConns.BanFor = time.Hour
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	awaitBlacklist(t, l)
+	// The client never ends its headers, so the connection is closed
+	l.LimitNetConn(connFrom(t, "10.0.0.1:1000"), func(c net.Conn) {
+		c.Read(make([]byte, 1))
+	})
+	// The ip is banned just after the connection is closed
+	for deadline := time.Now().Add(time.Second); !hasEntry(l.Blacklist.list.Load(), "10.0.0.1"); {
+		if time.Now().After(deadline) {
+			t.Fatal("slow connection's ip not blacklisted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlowConnBlacklistNeedsBlacklist(t *testing.T) {
+	l := &Limiter{Rate: 1, Burst: 1}
+	l.SlowConns.HeaderTimeout = time.Second
+	l.SlowConns.Blacklist = true
+	if err := l.Validate(); err == nil {
+		t.Fatal("slow connections blacklist accepted without the blacklist on")
+	}
+}
@@ -0,0 +1,41 @@
+package golimiter
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Returns the warning for a limit of which the fraction has been used,
+// "" if it is below the soft threshold
+func softWarning(used, threshold float64, limit string) string {
+	if threshold <= 0 || used < threshold {
+		return ""
+	}
+	return strconv.Itoa(int(math.Round(math.Min(used, 1)*100))) + "% of " + limit + " used"
+}
+
+// Sets the X-RateLimit-Warning header for an allowed request given a warning,
+// and reports the key's first warning since it was last below the threshold
+func (l *Limiter) warnSoftLimit(w http.ResponseWriter, key, warning string) {
+	if l.SoftLimit.Threshold <= 0 {
+		return
+	}
+	if warning != "" {
+		w.Header().Set("X-RateLimit-Warning", warning)
+	}
+	l.Lock()
+	crossed := warning != "" && !l.warned[key]
+	if crossed {
+		if l.warned == nil {
+			l.warned = make(map[string]bool)
+		}
+		l.warned[key] = true
+	} else if warning == "" {
+		delete(l.warned, key)
+	}
+	l.Unlock()
+	if crossed {
+		l.emit(Event{Kind: EventSoftLimit, Key: key, Detail: warning})
+	}
+}
@@ -0,0 +1,296 @@
+// Package sqlite keeps a golimiter.Limiter's audit entries (denials) and
+// per-minute aggregates in an embedded SQLite database, with retention and
+// query helpers, giving small deployments historical visibility without
+// external infrastructure
+// The database is opened by the caller with the SQLite driver of their
+// choice, e.g. modernc.org/sqlite (pure Go) or github.com/mattn/go-sqlite3
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/i-norden/golimiter"
+)
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS audit (
+		time    INTEGER NOT NULL, -- unix nanoseconds
+		key     TEXT    NOT NULL,
+		path    TEXT    NOT NULL DEFAULT '',
+		rule    TEXT    NOT NULL DEFAULT '',
+		status  INTEGER NOT NULL DEFAULT 0,
+		shadow  INTEGER NOT NULL DEFAULT 0,
+		allow   INTEGER NOT NULL DEFAULT 0,
+		country TEXT    NOT NULL DEFAULT '',
+		asn     INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_time ON audit (time)`,
+	`CREATE INDEX IF NOT EXISTS audit_key ON audit (key, time)`,
+	`CREATE TABLE IF NOT EXISTS minutes (
+		minute         INTEGER PRIMARY KEY, -- unix seconds
+		allowed        INTEGER NOT NULL,
+		denied         INTEGER NOT NULL,
+		shadow_denials INTEGER NOT NULL,
+		visitors       INTEGER NOT NULL,
+		state          INTEGER NOT NULL
+	)`,
+}
+
+// DB records a limiter's history in a SQLite database
+type DB struct {
+	db       *sql.DB
+	lim      *golimiter.Limiter
+	opts     Options
+	mu       sync.Mutex
+	pending  []golimiter.AuditEntry // Entries not yet written
+	dropped  uint64                 // Entries dropped because too many were pending, accessed atomically
+	last     golimiter.Stats        // Limiter counters at the last aggregate
+	quitChan chan bool              // Channel used to stop the background goroutine
+	done     chan bool              // Closed once the background goroutine has stopped
+}
+
+// DB options
+type Options struct {
+	Retention  time.Duration // How long audit entries are kept (default 7 days)
+	Aggregates time.Duration // How long per-minute aggregates are kept (default 90 days)
+	FlushFreq  time.Duration // How often pending audit entries are written, in one transaction (default 1 second)
+	MaxPending int           // Entries held between writes; any more are dropped (default 10000)
+}
+
+// A minute of the limiter's activity
+type Minute struct {
+	Minute        time.Time `json:"minute"`
+	Allowed       uint64    `json:"allowed"`
+	Denied        uint64    `json:"denied"`
+	ShadowDenials uint64    `json:"shadow_denials"`
+	Visitors      int       `json:"visitors"` // Visitors at the end of the minute
+	State         int       `json:"state"`    // Load state at the end of the minute
+}
+
+// Selects audit entries; zero fields select all
+type Query struct {
+	Key   string
+	Rule  string
+	From  time.Time
+	To    time.Time
+	Limit int // Entries returned at most, newest first (default 1000)
+}
+
+// A key and its number of audit entries, as returned by TopKeys
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Creates the tables if needed and starts the background process that writes
+// audit entries, records the limiter's per-minute aggregates and removes
+// rows past their retention
+// Set the limiter's Audit to AuditLog() to record its denials
+func Open(db *sql.DB, lim *golimiter.Limiter, opts Options) (*DB, error) {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Retention == 0 {
+		opts.Retention = 7 * 24 * time.Hour // Use default retention if none provided
+	}
+	if opts.Aggregates == 0 {
+		opts.Aggregates = 90 * 24 * time.Hour // Use default retention if none provided
+	}
+	if opts.FlushFreq == 0 {
+		opts.FlushFreq = time.Second // Use default freq if none provided
+	}
+	if opts.MaxPending == 0 {
+		opts.MaxPending = 10000 // Use default max if none provided
+	}
+	d := &DB{db: db, lim: lim, opts: opts, last: lim.Stats(), quitChan: make(chan bool), done: make(chan bool)}
+	go d.background()
+	return d, nil
+}
+
+// Stops the background process after writing the pending entries
+// The database itself is left open
+func (d *DB) Close() error {
+	close(d.quitChan)
+	<-d.done
+	return d.flush()
+}
+
+// Returns an audit log recording its entries in the database
+// Its sampling (AllowEvery, PerKey) can be set as for any audit log
+func (d *DB) AuditLog() *golimiter.AuditLog {
+	return golimiter.NewAuditFunc(d.add)
+}
+
+// Returns the number of entries dropped because MaxPending were waiting to be written
+func (d *DB) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+// Holds the entry until the next write
+func (d *DB) add(e golimiter.AuditEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.pending) >= d.opts.MaxPending {
+		atomic.AddUint64(&d.dropped, 1)
+		return nil
+	}
+	d.pending = append(d.pending, e)
+	return nil
+}
+
+// Writes the pending entries in one transaction
+func (d *DB) flush() error {
+	d.mu.Lock()
+	entries := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO audit (time, key, path, rule, status, shadow, allow, country, asn) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		if _, err = stmt.Exec(e.Time.UnixNano(), e.Key, e.Path, e.Rule, e.Status, e.Shadow, e.Allow, e.Country, e.ASN); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Records the limiter's activity in the minute that ended at end
+func (d *DB) aggregate(end time.Time) error {
+	st := d.lim.Stats()
+	last := d.last
+	d.last = st
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO minutes (minute, allowed, denied, shadow_denials, visitors, state) VALUES (?, ?, ?, ?, ?, ?)`,
+		end.Add(-time.Minute).Unix(), st.Allowed-last.Allowed, st.Denied-last.Denied, st.ShadowDenials-last.ShadowDenials, st.Visitors, st.State)
+	return err
+}
+
+// Removes the rows past their retention
+func (d *DB) prune(now time.Time) error {
+	if _, err := d.db.Exec(`DELETE FROM audit WHERE time < ?`, now.Add(-d.opts.Retention).UnixNano()); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(`DELETE FROM minutes WHERE minute < ?`, now.Add(-d.opts.Aggregates).Unix())
+	return err
+}
+
+// Writes the pending entries every FlushFreq, and records the aggregates
+// and prunes at the end of every minute
+// Errors are retried on the next run; pending entries of a failed write are lost
+func (d *DB) background() {
+	defer close(d.done)
+	flush := time.NewTicker(d.opts.FlushFreq)
+	defer flush.Stop()
+	minute := time.NewTimer(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)))
+	defer minute.Stop()
+	for {
+		select {
+		case <-d.quitChan:
+			return
+		case <-flush.C:
+			d.flush()
+		case now := <-minute.C:
+			end := now.Truncate(time.Minute)
+			d.aggregate(end)
+			d.prune(now)
+			minute.Reset(time.Until(end.Add(time.Minute)))
+		}
+	}
+}
+
+// Returns the audit entries selected by the query, newest first
+func (d *DB) Entries(q Query) ([]golimiter.AuditEntry, error) {
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if q.Key != "" {
+		where, args = append(where, "key = ?"), append(args, q.Key)
+	}
+	if q.Rule != "" {
+		where, args = append(where, "rule = ?"), append(args, q.Rule)
+	}
+	if !q.From.IsZero() {
+		where, args = append(where, "time >= ?"), append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		where, args = append(where, "time < ?"), append(args, q.To.UnixNano())
+	}
+	if q.Limit == 0 {
+		q.Limit = 1000
+	}
+	rows, err := d.db.Query(`SELECT time, key, path, rule, status, shadow, allow, country, asn FROM audit WHERE `+
+		strings.Join(where, " AND ")+` ORDER BY time DESC LIMIT ?`, append(args, q.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []golimiter.AuditEntry
+	for rows.Next() {
+		var e golimiter.AuditEntry
+		var nanos int64
+		if err = rows.Scan(&nanos, &e.Key, &e.Path, &e.Rule, &e.Status, &e.Shadow, &e.Allow, &e.Country, &e.ASN); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, nanos)
+		if !e.Allow {
+			e.Reason = golimiter.RuleReason(e.Rule)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Returns the n keys with the most denials in [from, to), most first
+func (d *DB) TopKeys(from, to time.Time, n int) ([]KeyCount, error) {
+	rows, err := d.db.Query(`SELECT key, COUNT(*) FROM audit WHERE allow = 0 AND time >= ? AND time < ? GROUP BY key ORDER BY 2 DESC LIMIT ?`,
+		from.UnixNano(), to.UnixNano(), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var top []KeyCount
+	for rows.Next() {
+		var kc KeyCount
+		if err = rows.Scan(&kc.Key, &kc.Count); err != nil {
+			return nil, err
+		}
+		top = append(top, kc)
+	}
+	return top, rows.Err()
+}
+
+// Returns the per-minute aggregates of the minutes starting in [from, to), oldest first
+func (d *DB) Minutes(from, to time.Time) ([]Minute, error) {
+	rows, err := d.db.Query(`SELECT minute, allowed, denied, shadow_denials, visitors, state FROM minutes WHERE minute >= ? AND minute < ? ORDER BY minute`,
+		from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var minutes []Minute
+	for rows.Next() {
+		var m Minute
+		var secs int64
+		if err = rows.Scan(&secs, &m.Allowed, &m.Denied, &m.ShadowDenials, &m.Visitors, &m.State); err != nil {
+			return nil, err
+		}
+		m.Minute = time.Unix(secs, 0)
+		minutes = append(minutes, m)
+	}
+	return minutes, rows.Err()
+}
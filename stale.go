@@ -0,0 +1,87 @@
+package golimiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// What the limiter does with a list whose reloads have failed for longer than MaxStaleness
+type StalePolicy int
+
+const (
+	// Keep using the last good list
+	StaleKeep StalePolicy = iota
+	// Let every request through the list's check (fail-open)
+	StaleOpen
+	// Fail every request at the list's check (fail-closed)
+	StaleClosed
+)
+
+// Reload state of a white/blacklist
+type listReload struct {
+	failures int         // Consecutive failed reloads; only touched by the list's update routine
+	lastOK   time.Time   // When the list was last loaded; only touched by the list's update routine
+	stale    atomic.Bool // Whether the list is older than MaxStaleness
+	running  bool        // Whether the update routine was started; guarded by the limiter's lock
+	first    *listRead   // List read by Init, loaded on the update routine's first pass instead of reading it again
+}
+
+// Records a reload of the named list, reporting the list once its reloads have
+// failed ListReload.Failures times in a row and again once it is older than
+// MaxStaleness, and when it recovers
+func (l *Limiter) reloaded(name string, rl *listReload, err error) {
+	now := time.Now()
+	if rl.lastOK.IsZero() {
+		rl.lastOK = now // The list was read when the limiter was validated
+	}
+	if err == nil {
+		if rl.failures >= l.reloadFailures() {
+			l.emit(Event{Kind: EventListRecovered, Key: name})
+		}
+		rl.failures, rl.lastOK = 0, now
+		rl.stale.Store(false)
+		return
+	}
+	rl.failures++
+	if rl.failures == l.reloadFailures() {
+		l.emit(Event{Kind: EventListDegraded, Key: name, Err: err})
+	}
+	max := l.ListReload.MaxStaleness
+	if max > 0 && now.Sub(rl.lastOK) > max && !rl.stale.Swap(true) {
+		l.emit(Event{Kind: EventListStale, Key: name, Err: err})
+	}
+}
+
+// Returns the number of consecutive failed reloads after which a list is degraded
+func (l *Limiter) reloadFailures() int {
+	if l.ListReload.Failures == 0 {
+		return 3 // Use default failures if none provided
+	}
+	return l.ListReload.Failures
+}
+
+// Checks whether the ip is on the whitelist, unless the stale policy decides instead
+func (l *Limiter) whitelisted(ip string) bool {
+	if l.Whitelist.reload.stale.Load() {
+		switch l.ListReload.StalePolicy {
+		case StaleOpen:
+			return true
+		case StaleClosed:
+			return false
+		}
+	}
+	return l.Whitelist.list.Load().Contains(ip)
+}
+
+// Checks whether the ip is on the blacklist, unless the stale policy decides instead
+func (l *Limiter) blacklisted(ip string) bool {
+	if l.Blacklist.reload.stale.Load() {
+		switch l.ListReload.StalePolicy {
+		case StaleOpen:
+			return false
+		case StaleClosed:
+			return true
+		}
+	}
+	return l.Blacklist.list.Load().Contains(ip)
+}
@@ -0,0 +1,193 @@
+package golimiter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A limiter state, entered when the load on the limiter surpasses its threshold
+// While the state is active visitors are limited using its Rate and Burst
+// instead of Limiter.Rate and Limiter.Burst
+type State struct {
+	Threshold int        // Load, in events per second across all visitors, above which the state is entered
+	Rate      rate.Limit // Per visitor rate enforced in the state
+	Burst     int        // Per visitor burst/bucket size enforced in the state
+	// Visitors at these levels (see SetLevel) keep the default Rate and Burst
+	// while the state is active, so only other traffic is squeezed
+	ExemptLevels []int
+}
+
+// Replaces the limiter's states
+// States must be given in order of strictly increasing thresholds; when the load
+// surpasses several thresholds at once the state with the highest one becomes active
+// Existing visitors are given new limiters for the states, or with ApplyToExisting
+// set keep their limiters' usage for the states they already had
+func (l *Limiter) SetStates(states []State) error {
+	if err := validateStates(states); err != nil {
+		return err
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.triggers = make([]*rate.Limiter, len(states))
+	l.params = make([]params, len(states))
+	for i, st := range states {
+		l.triggers[i] = rate.NewLimiter(rate.Limit(st.Threshold), st.Threshold)
+		l.params[i] = params{rate: st.Rate, burst: st.Burst, exempt: append([]int(nil), st.ExemptLevels...)}
+	}
+	now := time.Now()
+	for _, v := range l.visitors {
+		prev := v.limiters
+		v.limiters = make([]*rate.Limiter, len(l.params))
+		for i, p := range l.params {
+			r, b := l.scale(p.rate, p.burst)
+			if l.ApplyToExisting && i < len(prev) {
+				v.limiters[i] = retune(prev[i], r, b, now)
+				continue
+			}
+			v.limiters[i] = rate.NewLimiter(r, b)
+		}
+	}
+	l.useDefault = true
+	l.state = 0
+	return nil
+}
+
+// Returns a copy of the limiter's states
+func (l *Limiter) States() []State {
+	l.Lock()
+	defer l.Unlock()
+	states := make([]State, len(l.params))
+	for i, p := range l.params {
+		states[i] = State{
+			Threshold:    int(l.triggers[i].Limit()),
+			Rate:         p.rate,
+			Burst:        p.burst,
+			ExemptLevels: append([]int(nil), p.exempt...),
+		}
+	}
+	return states
+}
+
+// Returns the index of the active state, or -1 if the default
+// Rate and Burst are being enforced
+func (l *Limiter) CurrentState() int {
+	l.Lock()
+	defer l.Unlock()
+	if l.useDefault {
+		return -1
+	}
+	return l.state
+}
+
+// Creates a load threshold using the given limit that triggers
+// the transition to a new limiter state that uses the given
+// vRate and vBurst instead of Limiter.Rate and Limiter.Burst
+// When multiple state are triggered the highest order state becomes active
+// An order past the last state appends the state
+// The state is not added if the resulting states would be invalid
+//
+// Deprecated: use SetStates, which reports invalid states
+func (l *Limiter) AddState(order int, limit int, vRate rate.Limit, vBurst int) {
+	states := l.States()
+	st := State{Threshold: limit, Rate: vRate, Burst: vBurst}
+	if order >= 0 && order < len(states) {
+		states[order] = st
+	} else {
+		states = append(states, st)
+	}
+	l.SetStates(states)
+}
+
+// Assigns a level to the visitor key, used to treat visitors differently
+// (e.g. exempting them from stricter states); visitors default to level 0
+// The level is kept when the visitor is cleaned up
+func (l *Limiter) SetLevel(key string, level int) {
+	l.Lock()
+	defer l.Unlock()
+	l.setLevel(key, level)
+}
+
+// Assigns a level to the visitor key and applies the level's multiplier
+// Must be called while holding the lock
+func (l *Limiter) setLevel(key string, level int) {
+	if l.levels == nil {
+		l.levels = make(map[string]int)
+	}
+	if level == 0 {
+		delete(l.levels, key)
+	} else {
+		l.levels[key] = level
+	}
+	if v, ok := l.visitors[key]; ok && v.level != level {
+		v.level = level
+		r, b := l.scale(l.defaultParams(v))
+		v.limiter = retune(v.limiter, r, b, time.Now())
+	}
+}
+
+// Checks whether the visitor's level (or an override) exempts it from the active state
+// Must be called while holding the lock
+func (l *Limiter) exempt(v *visitor) bool {
+	if l.useDefault || l.state >= len(l.params) {
+		return false
+	}
+	if _, ok := l.overridden(v); ok {
+		return true
+	}
+	for _, lvl := range l.params[l.state].exempt {
+		if v.level == lvl {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks that the states are usable
+func validateStates(states []State) error {
+	for i, st := range states {
+		if st.Threshold <= 0 {
+			return fmt.Errorf("state %d: threshold must be positive", i)
+		}
+		if st.Rate < 0 {
+			return fmt.Errorf("state %d: rate must not be negative", i)
+		}
+		if st.Burst <= 0 && st.Rate != rate.Inf {
+			return fmt.Errorf("state %d: burst must be positive", i)
+		}
+		if i > 0 && st.Threshold <= states[i-1].Threshold {
+			return errors.New("state thresholds must be strictly increasing")
+		}
+	}
+	return nil
+}
+
+// Update state variable based on limiters global limiter states
+// Every trigger is drained by each event so that they all track the load;
+// the highest order trigger that is exhausted becomes the active state
+func (l *Limiter) updateState() {
+	l.Lock()
+	prev, prevDefault := l.state, l.useDefault
+	now := time.Now()
+	l.updateCurve(now)
+	l.updateSchedule(now)
+	l.useDefault = true
+	for i, t := range l.triggers {
+		if !t.AllowN(now, 1) {
+			l.state = i
+			l.useDefault = false
+		}
+	}
+	changed, state := l.useDefault != prevDefault || !l.useDefault && l.state != prev, l.state
+	l.updateWarmUp(now, l.useDefault && !prevDefault)
+	if l.useDefault {
+		state = -1
+	}
+	l.Unlock()
+	if changed {
+		l.emit(Event{Kind: EventStateChange, Detail: strconv.Itoa(state)})
+	}
+}
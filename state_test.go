@@ -0,0 +1,64 @@
+package golimiter
+
+import (
+	"testing"
+)
+
+// AddState used to index into the limiter's nil state slices and panic
+func TestAddStateOnNewLimiter(t *testing.T) {
+	l := &Limiter{Rate: 10, Burst: 10}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l.AddState(0, 100, 1, 1)
+	l.AddState(5, 200, 0.5, 1) // Past the last state, so appended
+	l.AddState(0, 50, 2, 2)    // Replaces the first state
+	states := l.States()
+	if len(states) != 2 || states[0].Threshold != 50 || states[1].Threshold != 200 {
+		t.Fatalf("got states %+v", states)
+	}
+	l.AddState(1, 10, 1, 1) // Would leave the thresholds out of order, so not added
+	if states := l.States(); states[1].Threshold != 200 {
+		t.Fatalf("invalid state added: %+v", states)
+	}
+}
+
+func TestSetStatesValidated(t *testing.T) {
+	for name, states := range map[string][]State{
+		"zero threshold":   {{Threshold: 0, Rate: 1, Burst: 1}},
+		"negative rate":    {{Threshold: 10, Rate: -1, Burst: 1}},
+		"zero burst":       {{Threshold: 10, Rate: 1}},
+		"unordered":        {{Threshold: 20, Rate: 1, Burst: 1}, {Threshold: 10, Rate: 1, Burst: 1}},
+		"equal thresholds": {{Threshold: 10, Rate: 1, Burst: 1}, {Threshold: 10, Rate: 1, Burst: 1}},
+	} {
+		l := &Limiter{}
+		if err := l.SetStates(states); err == nil {
+			t.Errorf("%s: states accepted", name)
+		}
+	}
+}
+
+func TestStateEnteredUnderLoad(t *testing.T) {
+	l := &Limiter{Rate: 0.001, Burst: 10}
+	l.Cleanup.Off = true
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetStates([]State{{Threshold: 3, Rate: 0.001, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if s := l.CurrentState(); s != -1 {
+		t.Fatalf("state %d before any load", s)
+	}
+	for i := 0; i < 3; i++ {
+		l.AllowKey("other")
+	}
+	// The load is past the threshold, so the state's burst of 1 applies
+	if !l.AllowKey("key") || l.AllowKey("key") {
+		t.Fatal("state's burst not enforced")
+	}
+	if s := l.CurrentState(); s != 0 {
+		t.Fatalf("state %d under load, want 0", s)
+	}
+}
@@ -0,0 +1,82 @@
+package golimiter
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot of the limiter's internals, as published by the expvars package
+type Stats struct {
+	Mode          string `json:"mode"`
+	State         int    `json:"state"` // Index of the active load state (-1 for the default)
+	Visitors      int    `json:"visitors"`
+	Allowed       uint64 `json:"allowed"`
+	Denied        uint64 `json:"denied"`
+	ShadowDenials uint64 `json:"shadow_denials"`
+	Whitelist     int    `json:"whitelist"` // Number of whitelist entries
+	Blacklist     int    `json:"blacklist"` // Number of blacklist entries
+	StoreCalls    uint64 `json:"store_calls"`
+	StoreErrors   uint64 `json:"store_errors"`
+	FailOpen      uint64 `json:"fail_open"`   // Events the limiter failed to decide that were allowed by policy
+	FailClosed    uint64 `json:"fail_closed"` // Events the limiter failed to decide that were denied by policy
+	FailLocal     uint64 `json:"fail_local"`  // Events the limiter failed to decide that the local limiters decided
+	EarlyDrops    uint64 `json:"early_drops"` // Connections dropped by GateListener or LimitListener before any handshake
+}
+
+// Returns a snapshot of the limiter's internals
+func (l *Limiter) Stats() Stats {
+	st := Stats{
+		Mode:          l.Mode().String(),
+		State:         l.CurrentState(),
+		Allowed:       atomic.LoadUint64(&l.allowed),
+		Denied:        atomic.LoadUint64(&l.denied),
+		ShadowDenials: l.ShadowDenials(),
+		FailOpen:      atomic.LoadUint64(&l.failed.open),
+		FailClosed:    atomic.LoadUint64(&l.failed.closed),
+		FailLocal:     atomic.LoadUint64(&l.failed.local),
+		EarlyDrops:    atomic.LoadUint64(&l.acceptGate.drops),
+	}
+	l.Lock()
+	st.Visitors = len(l.visitors)
+	st.Whitelist = l.Whitelist.list.Load().Len()
+	st.Blacklist = l.Blacklist.list.Load().Len()
+	l.Unlock()
+	l.monitor.Lock()
+	st.StoreCalls, st.StoreErrors = l.monitor.store.Calls, l.monitor.store.Errors
+	l.monitor.Unlock()
+	return st
+}
+
+// A visitor's activity, as reported by TopVisitors
+type VisitorStats struct {
+	Key      string    `json:"key"`
+	Seen     uint64    `json:"seen"` // Times the visitor was seen since it was added (or last cleaned up)
+	LastSeen time.Time `json:"last_seen"`
+	Score    *float64  `json:"score,omitempty"` // Reputation score, if Reputation is on
+	Origin             // Network origin of the key, if Geo.GeoIP is set and the key is an ip
+}
+
+// Returns the n visitors that were seen most often, busiest first
+func (l *Limiter) TopVisitors(n int) []VisitorStats {
+	l.Lock()
+	all := make([]VisitorStats, 0, len(l.visitors))
+	now := time.Now()
+	for key, v := range l.visitors {
+		st := VisitorStats{Key: key, Seen: v.seen, LastSeen: v.lastSeen}
+		if l.Reputation.On && v.fixed == nil {
+			score := l.score(v, now)
+			st.Score = &score
+		}
+		all = append(all, st)
+	}
+	l.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].Seen > all[j].Seen })
+	if len(all) > n {
+		all = all[:n]
+	}
+	for i := range all {
+		all[i].Origin = l.originOf(all[i].Key)
+	}
+	return all
+}
@@ -0,0 +1,111 @@
+package golimiter
+
+import (
+	"strconv"
+	"time"
+
+	c "github.com/i-norden/golimiter/common"
+	"golang.org/x/time/rate"
+)
+
+// Store is shared storage for limiter counters, so that several
+// limiters (e.g. one per replica of a service) enforce one limit
+type Store interface {
+	// Atomically adds n to the counter at key and returns the new value
+	// Counters that do not exist are created at zero and expire after ttl
+	Incr(key string, n int64, ttl time.Duration) (int64, error)
+}
+
+// ListStore is optionally implemented by a Store to persist runtime
+// white/blacklist changes (e.g. bans) so that they survive restarts and
+// list file reloads
+type ListStore interface {
+	// Records that ip was added to (added is true) or removed from the list
+	SetListEntry(list, ip string, added bool) error
+	// Returns the recorded changes for the list
+	ListEntries(list string) (map[string]bool, error)
+}
+
+// Records a runtime list change if the store persists them
+func (l *Limiter) persistEntry(list, ip string, added bool) {
+	if ls, ok := l.Store.(ListStore); ok {
+		ls.SetListEntry(list, ip, added)
+	}
+}
+
+// Applies the recorded runtime changes for the list on top of a list read from its file
+func (l *Limiter) mergeEntries(list string, base []c.Entry) []c.Entry {
+	ls, ok := l.Store.(ListStore)
+	if !ok {
+		return base
+	}
+	entries, err := ls.ListEntries(list)
+	if err != nil || len(entries) == 0 {
+		return base
+	}
+	merged := make([]c.Entry, 0, len(base)+len(entries))
+	in := make(map[string]bool, len(base))
+	for _, e := range base {
+		if added, ok := entries[e.IP]; ok && !added {
+			continue
+		}
+		merged = append(merged, e)
+		in[e.IP] = true
+	}
+	for ip, added := range entries {
+		if added && !in[ip] {
+			merged = append(merged, c.Entry{IP: ip})
+		}
+	}
+	return merged
+}
+
+// Checks whether or not the key is allowed n events using the store
+// The bucket at the current state is approximated by a fixed window
+// of Burst/Rate seconds in which up to Burst events are allowed; sheltered
+// requests are exempt from the load state
+// Outside of the load states and the curve, the visitor's params are derived
+// as for the local limiters (plans, schedule windows, levels, ...)
+// If denied, the time until the next window starts is also returned
+func (l *Limiter) storeAllow(v *visitor, n int, sheltered bool) (bool, time.Duration, error) {
+	l.Lock()
+	key := v.key
+	_, overridden := l.overridden(v)
+	own := v.fixed != nil || overridden // Fixed and overridden params replace the states and the curve
+	var r rate.Limit
+	var b int
+	switch {
+	case !own && len(l.curve) > 0:
+		r, b = l.curRate, l.curBurst
+	case !own && !l.useDefault && l.state < len(l.params) && !sheltered && !l.exempt(v):
+		r, b = l.params[l.state].rate, l.params[l.state].burst
+	default:
+		r, b = l.defaultParams(v)
+	}
+	if v.fixed == nil {
+		r, b = warm(r, b, l.warmth(time.Now()))
+	}
+	l.Unlock()
+	if r == rate.Inf {
+		return true, 0, nil
+	}
+	if r <= 0 || b <= 0 {
+		return false, 0, nil
+	}
+	window := time.Duration(float64(b) / float64(r) * float64(time.Second))
+	if window <= 0 {
+		window = time.Nanosecond
+	}
+	now := time.Now().UnixNano()
+	idx := now / int64(window)
+	start := time.Now()
+	count, err := l.storeIncr(key+":"+strconv.FormatInt(idx, 10), int64(n), window*2)
+	l.monitor.storeCall(time.Since(start), err)
+	if err != nil {
+		return false, 0, err
+	}
+	if count <= int64(b) {
+		return true, 0, nil
+	}
+	return false, time.Duration((idx+1)*int64(window) - now), nil
+}
@@ -0,0 +1,162 @@
+// Package bolt provides a golimiter.Store persisted to an embedded bbolt
+// database, so long-horizon counters and runtime list changes (bans)
+// survive restarts on single-node deployments
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	countersBucket = []byte("counters")
+	listsBucket    = []byte("lists")
+)
+
+// Store implements golimiter.Store and golimiter.ListStore
+type Store struct {
+	db       *bolt.DB
+	quitChan chan bool // Channel used to stop the background goroutine
+}
+
+// Store options
+type Options struct {
+	// How often the database is synced to disk
+	// Zero syncs on every write; longer intervals trade durability of the
+	// most recent counts for write throughput
+	SyncFreq time.Duration
+	// How often expired counters are removed (default 1 minute)
+	CompactFreq time.Duration
+}
+
+// Opens (or creates) the database at path and starts the background
+// sync and compaction process
+func Open(path string, opts Options) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(countersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(listsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if opts.CompactFreq == 0 {
+		opts.CompactFreq = time.Minute // Use default freq if none provided
+	}
+	db.NoSync = opts.SyncFreq > 0
+	s := &Store{db: db, quitChan: make(chan bool)}
+	go s.background(opts)
+	return s, nil
+}
+
+// Stops the background process, syncs and closes the database
+func (s *Store) Close() error {
+	close(s.quitChan)
+	s.db.Sync()
+	return s.db.Close()
+}
+
+// Atomically adds n to the counter at key, creating it if needed
+// Counter values are stored as the count followed by the expiry in unix nanoseconds
+func (s *Store) Incr(key string, n int64, ttl time.Duration) (count int64, err error) {
+	now := time.Now()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(countersBucket)
+		expires := now.Add(ttl).UnixNano()
+		if v := b.Get([]byte(key)); len(v) == 16 && int64(binary.BigEndian.Uint64(v[8:])) > now.UnixNano() {
+			count = int64(binary.BigEndian.Uint64(v[:8]))
+			expires = int64(binary.BigEndian.Uint64(v[8:]))
+		}
+		count += n
+		v := make([]byte, 16)
+		binary.BigEndian.PutUint64(v[:8], uint64(count))
+		binary.BigEndian.PutUint64(v[8:], uint64(expires))
+		return b.Put([]byte(key), v)
+	})
+	return
+}
+
+// Records a runtime list change
+func (s *Store) SetListEntry(list, ip string, added bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(listsBucket).CreateBucketIfNotExists([]byte(list))
+		if err != nil {
+			return err
+		}
+		v := []byte{0}
+		if added {
+			v[0] = 1
+		}
+		return b.Put([]byte(ip), v)
+	})
+}
+
+// Returns the recorded changes for the list
+func (s *Store) ListEntries(list string) (entries map[string]bool, err error) {
+	entries = make(map[string]bool)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(listsBucket).Bucket([]byte(list))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			entries[string(k)] = len(v) == 1 && v[0] == 1
+			return nil
+		})
+	})
+	return
+}
+
+// Periodically syncs the database and removes expired counters
+func (s *Store) background(opts Options) {
+	compact := time.NewTicker(opts.CompactFreq)
+	defer compact.Stop()
+	var syncC <-chan time.Time
+	if opts.SyncFreq > 0 {
+		sync := time.NewTicker(opts.SyncFreq)
+		defer sync.Stop()
+		syncC = sync.C
+	}
+	for {
+		select {
+		case <-s.quitChan:
+			return
+		case <-syncC:
+			s.db.Sync()
+		case <-compact.C:
+			s.compact()
+		}
+	}
+}
+
+// Removes expired counters
+func (s *Store) compact() error {
+	now := uint64(time.Now().UnixNano())
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(countersBucket)
+		// Deleting through the cursor while iterating skips the key after each
+		// deleted one, so the expired keys are collected first
+		var expired [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(v) != 16 || binary.BigEndian.Uint64(v[8:]) <= now {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
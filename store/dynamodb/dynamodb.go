@@ -0,0 +1,77 @@
+// Package dynamodb provides a golimiter.Store backed by a DynamoDB table,
+// for serverless deployments that have no other shared state
+//
+// The table needs a string partition key (default attribute "key") and
+// should have DynamoDB TTL enabled on the expiry attribute (default "expires")
+// so that stale counters are removed
+package dynamodb
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The subset of the DynamoDB client used by the store
+type API interface {
+	UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// Store implements golimiter.Store
+type Store struct {
+	Client  API
+	Table   string        // Table name
+	KeyAttr string        // Partition key attribute (default "key")
+	TTLAttr string        // Expiry attribute, in epoch seconds (default "expires")
+	Timeout time.Duration // Per call timeout (default 1 second)
+}
+
+// Creates a new Store using the given client and table
+func New(client API, table string) *Store {
+	return &Store{Client: client, Table: table}
+}
+
+// Atomically adds n to the counter at key, creating it if needed
+// The expiry is only set when the counter is created
+func (s *Store) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	keyAttr, ttlAttr := s.KeyAttr, s.TTLAttr
+	if keyAttr == "" {
+		keyAttr = "key"
+	}
+	if ttlAttr == "" {
+		ttlAttr = "expires"
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	expires := time.Now().Add(ttl).Unix() + 1
+	out, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.Table),
+		Key:              map[string]types.AttributeValue{keyAttr: &types.AttributeValueMemberS{Value: key}},
+		UpdateExpression: aws.String("ADD #c :n SET #t = if_not_exists(#t, :t)"),
+		ExpressionAttributeNames: map[string]string{
+			"#c": "count",
+			"#t": ttlAttr,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":n": &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)},
+			":t": &types.AttributeValueMemberN{Value: strconv.FormatInt(expires, 10)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, err
+	}
+	count, ok := out.Attributes["count"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(count.Value, 10, 64)
+}
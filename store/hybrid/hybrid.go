@@ -0,0 +1,143 @@
+// Package hybrid provides a golimiter.Store that answers from a local cache
+// and asynchronously reconciles the counts with a shared backend store, so
+// that the latency (and outages) of the backend stay off the request path
+// The local counts include the backend's count as of the last reconciliation,
+// so other instances' usage is seen with a delay of up to the sync interval
+package hybrid
+
+import (
+	"sync"
+	"time"
+
+	"github.com/i-norden/golimiter"
+)
+
+// Store implements golimiter.Store
+type Store struct {
+	sync.Mutex
+	backend golimiter.Store
+	// Events a key may count locally before an increment waits for the backend
+	// instead of being reconciled asynchronously; bounds how far the local
+	// count can drift from the shared one (0- never wait)
+	ErrorBudget int64
+	counters    map[string]*counter
+	quitChan    chan bool // Channel used to stop the background goroutine
+}
+
+// A counter cached locally
+type counter struct {
+	synced  int64 // Backend count as of the last reconciliation
+	pending int64 // Local increments not yet sent to the backend
+	ttl     time.Duration
+	expires time.Time
+}
+
+// Store options
+type Options struct {
+	// How often pending increments are sent to the backend (default 100 milliseconds)
+	SyncFreq time.Duration
+	// See Store.ErrorBudget
+	ErrorBudget int64
+}
+
+// Creates a Store in front of the backend and starts the background
+// process reconciling the local counts with it
+func New(backend golimiter.Store, opts Options) *Store {
+	if opts.SyncFreq == 0 {
+		opts.SyncFreq = 100 * time.Millisecond // Use default freq if none provided
+	}
+	s := &Store{
+		backend:     backend,
+		ErrorBudget: opts.ErrorBudget,
+		counters:    make(map[string]*counter),
+		quitChan:    make(chan bool),
+	}
+	go s.background(opts.SyncFreq)
+	return s
+}
+
+// Stops the background process after sending the pending increments
+func (s *Store) Close() {
+	close(s.quitChan)
+	s.sync()
+}
+
+// Adds n to the local counter at key and returns the local estimate of its value
+// If the key has used up its error budget, the increment is sent to the backend
+// synchronously; if that fails the local estimate is returned
+func (s *Store) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	s.Lock()
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expires) {
+		c = &counter{ttl: ttl, expires: now.Add(ttl)}
+		s.counters[key] = c
+	}
+	c.pending += n
+	if s.ErrorBudget <= 0 || c.pending <= s.ErrorBudget {
+		v := c.synced + c.pending
+		s.Unlock()
+		return v, nil
+	}
+	pending := c.pending
+	c.pending = 0
+	s.Unlock()
+	v, err := s.backend.Incr(key, pending, ttl)
+	s.Lock()
+	defer s.Unlock()
+	if err != nil {
+		c.pending += pending // Retry with the next reconciliation
+		return c.synced + c.pending, nil
+	}
+	if v > c.synced {
+		c.synced = v
+	}
+	return c.synced + c.pending, nil
+}
+
+// Every interval send the pending increments to the backend
+func (s *Store) background(freq time.Duration) {
+	ticker := time.NewTicker(freq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quitChan:
+			return
+		case <-ticker.C:
+			s.sync()
+		}
+	}
+}
+
+// Sends the pending increments to the backend and drops expired counters
+func (s *Store) sync() {
+	now := time.Now()
+	type incr struct {
+		key string
+		c   *counter
+		n   int64
+	}
+	var incrs []incr
+	s.Lock()
+	for key, c := range s.counters {
+		if now.After(c.expires) {
+			delete(s.counters, key)
+			continue
+		}
+		if c.pending > 0 {
+			incrs = append(incrs, incr{key, c, c.pending})
+			c.pending = 0
+		}
+	}
+	s.Unlock()
+	for _, in := range incrs {
+		v, err := s.backend.Incr(in.key, in.n, in.c.ttl)
+		s.Lock()
+		if err != nil {
+			in.c.pending += in.n // Retry with the next reconciliation
+		} else if v > in.c.synced {
+			in.c.synced = v
+		}
+		s.Unlock()
+	}
+}
@@ -0,0 +1,82 @@
+// Package memcached provides a golimiter.Store backed by memcached
+// Counts are lossy if a memcached node is evicted or restarted, which
+// favors latency over accuracy
+package memcached
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Maximum key length accepted by memcached
+const maxKeyLen = 250
+
+// Store implements golimiter.Store
+type Store struct {
+	Client *memcache.Client
+	Prefix string // Prepended to every key
+}
+
+// Creates a new Store using the given memcached servers
+func New(servers ...string) *Store {
+	return &Store{Client: memcache.New(servers...), Prefix: "golimiter:"}
+}
+
+// Atomically adds n to the counter at key, creating it if needed
+func (s *Store) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	key = s.key(key)
+	for {
+		v, err := s.Client.Increment(key, uint64(n))
+		if err == nil {
+			return int64(v), nil
+		}
+		if err != memcache.ErrCacheMiss {
+			return 0, err
+		}
+		// Counter doesn't exist yet; Add fails if another client created it first
+		err = s.Client.Add(&memcache.Item{
+			Key:        key,
+			Value:      []byte(strconv.FormatInt(n, 10)),
+			Expiration: expiration(ttl),
+		})
+		if err == nil {
+			return n, nil
+		}
+		if err != memcache.ErrNotStored {
+			return 0, err
+		}
+	}
+}
+
+// Returns a key that is valid for memcached
+// Keys that are too long or contain spaces or control characters are hashed
+func (s *Store) key(key string) string {
+	key = s.Prefix + key
+	if len(key) <= maxKeyLen && validKey(key) {
+		return key
+	}
+	sum := sha1.Sum([]byte(key))
+	return s.Prefix + hex.EncodeToString(sum[:])
+}
+
+func validKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Converts a ttl to memcached's expiration in whole seconds, rounded up
+func expiration(ttl time.Duration) int32 {
+	secs := int32((ttl + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
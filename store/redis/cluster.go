@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of hash slots of a Redis Cluster
+const slotCount = 16384
+
+// Redirects followed per command before giving up
+const maxRedirects = 3
+
+// Returns the hash slot of the key, hashing only its {hash tag} if it has one
+func keySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) % slotCount)
+}
+
+// CRC16-CCITT (XMODEM), as used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Sends a command on the key to the node serving its slot, following
+// MOVED and ASK redirects while the cluster reshards or fails over
+func (s *Store) clusterDo(key string, args ...string) (any, error) {
+	slot := keySlot(key)
+	addr, asking := s.slotAddr(slot), false
+	for i := 0; ; i++ {
+		var reply any
+		var err error
+		if asking {
+			reply, err = s.pool(addr, 0).pipeline([]string{"ASKING"}, args)
+		} else {
+			reply, err = s.pool(addr, 0).do(args...)
+		}
+		if err == nil {
+			return reply, nil
+		}
+		var re redisError
+		if !errors.As(err, &re) {
+			s.refreshSlots() // The node may have failed over
+			return nil, err
+		}
+		fields := strings.Fields(string(re))
+		if i == maxRedirects || len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+			return nil, err
+		}
+		addr, asking = fields[2], fields[0] == "ASK"
+		if !asking { // The slot has moved for good, so the map is out of date
+			s.mu.Lock()
+			s.slots[slot] = addr
+			s.mu.Unlock()
+			s.refreshSlots()
+		}
+	}
+}
+
+// Returns the address of the node serving the slot, or a seed node if unknown
+func (s *Store) slotAddr(slot int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if addr := s.slots[slot]; addr != "" {
+		return addr
+	}
+	return s.opts.Addrs[slot%len(s.opts.Addrs)]
+}
+
+// Reloads the slot map in the background, at most once a second
+func (s *Store) refreshSlots() {
+	s.mu.Lock()
+	if s.refreshing || time.Since(s.refreshed) < time.Second {
+		s.mu.Unlock()
+		return
+	}
+	s.refreshing = true
+	s.mu.Unlock()
+	go func() {
+		s.loadSlots()
+		s.mu.Lock()
+		s.refreshing = false
+		s.mu.Unlock()
+	}()
+}
+
+// Reads the slot map from the first node that answers CLUSTER SLOTS,
+// trying the nodes already known before the seed nodes
+func (s *Store) loadSlots() error {
+	s.mu.Lock()
+	s.refreshed = time.Now()
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, addr := range s.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	s.mu.Unlock()
+	addrs = append(addrs, s.opts.Addrs...)
+	err := errors.New("redis: no cluster node answered")
+	for _, addr := range addrs {
+		var reply any
+		if reply, err = s.pool(addr, 0).do("CLUSTER", "SLOTS"); err != nil {
+			continue
+		}
+		var slots [slotCount]string
+		if err = parseSlots(reply, addr, &slots); err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.slots = slots
+		s.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// Fills the slot map from a CLUSTER SLOTS reply of the node at from: ranges
+// of slots with their master's ip and port first, then their replicas'
+func parseSlots(reply any, from string, slots *[slotCount]string) error {
+	ranges, ok := reply.([]any)
+	if !ok || len(ranges) == 0 {
+		return errors.New("redis: no slots in CLUSTER SLOTS reply")
+	}
+	for _, r := range ranges {
+		fields, ok := r.([]any)
+		if !ok || len(fields) < 3 {
+			return errors.New("redis: malformed CLUSTER SLOTS reply")
+		}
+		start, ok1 := fields[0].(int64)
+		end, ok2 := fields[1].(int64)
+		master, ok3 := fields[2].([]any)
+		if !ok1 || !ok2 || !ok3 || len(master) < 2 || start < 0 || end >= slotCount {
+			return errors.New("redis: malformed CLUSTER SLOTS reply")
+		}
+		ip, _ := master[0].([]byte)
+		port, _ := master[1].(int64)
+		host := string(ip)
+		if host == "" { // The node that answered
+			host, _, _ = net.SplitHostPort(from)
+		}
+		addr := net.JoinHostPort(host, strconv.FormatInt(port, 10))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+	}
+	return nil
+}
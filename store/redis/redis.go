@@ -0,0 +1,208 @@
+// Package redis provides a golimiter.Store backed by Redis: a single server,
+// a Redis Cluster, or the master of a Sentinel-monitored group, followed
+// through failovers
+// It speaks RESP over plain (or TLS) connections, without a client library
+// For latency that stays off the request path when Redis blips, wrap it in a
+// store/hybrid Store
+package redis
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Increments the counter, setting its expiry if it has none, so counters
+// are created and expired atomically
+const incrScript = `local v = redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < 0 then redis.call('PEXPIRE', KEYS[1], ARGV[2]) end
+return v`
+
+// Store options
+type Options struct {
+	// Address of the server, the cluster's seed nodes, or the sentinels if
+	// MasterName is set (e.g. "10.0.0.5:6379")
+	Addrs []string
+	// Whether Addrs are nodes of a Redis Cluster, whose slot map is read
+	// from them and followed through MOVED and ASK redirects
+	Cluster bool
+	// Name of the master monitored by the sentinels at Addrs (Sentinel off if empty)
+	MasterName       string
+	SentinelPassword string // Password of the sentinels, if they require one
+	Username         string // ACL user (default user if empty)
+	Password         string
+	DB               int           // Database selected, for a single server or Sentinel
+	TLS              *tls.Config   // Connects over TLS if set
+	DialTimeout      time.Duration // Timeout of each connection attempt (default 1 second)
+	Timeout          time.Duration // Timeout of each command (default 500 milliseconds)
+	PoolSize         int           // Idle connections kept per server (default 16)
+}
+
+// Store implements golimiter.Store
+type Store struct {
+	Prefix string // Prepended to every key
+	opts   Options
+	mu     sync.Mutex
+	pools  map[string]*pool // Connections by server address
+	// Cluster
+	slots      [slotCount]string // Address of the node serving each slot, "" if unknown
+	refreshed  time.Time         // When the slot map was last read
+	refreshing bool              // Whether the slot map is being read
+	// Sentinel
+	master string // Address of the current master, "" until found
+}
+
+// Creates a Store for the topology described by the options
+// For a cluster the slot map is read, and for Sentinel the master is looked
+// up, before returning
+func New(opts Options) (*Store, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("redis: no addresses")
+	}
+	if opts.Cluster && opts.MasterName != "" {
+		return nil, errors.New("redis: a cluster can't be found through Sentinel")
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = time.Second // Use default timeout if none provided
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 500 * time.Millisecond // Use default timeout if none provided
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = 16 // Use default pool size if none provided
+	}
+	s := &Store{Prefix: "golimiter:", opts: opts, pools: make(map[string]*pool)}
+	switch {
+	case opts.Cluster:
+		if err := s.loadSlots(); err != nil {
+			return nil, err
+		}
+	case opts.MasterName != "":
+		if _, err := s.findMaster(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Atomically adds n to the counter at key, creating it if needed
+func (s *Store) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	key = s.Prefix + key
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	reply, err := s.do(key, "EVAL", incrScript, "1", key, strconv.FormatInt(n, 10), strconv.FormatInt(ms, 10))
+	if err != nil {
+		return 0, err
+	}
+	v, ok := reply.(int64)
+	if !ok {
+		return 0, errors.New("redis: unexpected reply to INCRBY")
+	}
+	return v, nil
+}
+
+// Closes the idle connections
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.pools {
+		p.close()
+	}
+	return nil
+}
+
+// Sends a command on the key to the server that holds it
+func (s *Store) do(key string, args ...string) (any, error) {
+	switch {
+	case s.opts.Cluster:
+		return s.clusterDo(key, args...)
+	case s.opts.MasterName != "":
+		return s.sentinelDo(args...)
+	}
+	return s.pool(s.opts.Addrs[0], s.opts.DB).do(args...)
+}
+
+// Returns the pool of connections to the server
+func (s *Store) pool(addr string, db int) *pool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pools[addr]
+	if !ok {
+		p = &pool{addr: addr, db: db, opts: &s.opts}
+		s.pools[addr] = p
+	}
+	return p
+}
+
+// Sends a command to the current master, looking it up again through the
+// sentinels if it is unreachable or has been demoted to a replica
+func (s *Store) sentinelDo(args ...string) (any, error) {
+	s.mu.Lock()
+	master := s.master
+	s.mu.Unlock()
+	var err error
+	if master == "" {
+		if master, err = s.findMaster(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := s.pool(master, s.opts.DB).do(args...)
+	var re redisError
+	if err != nil && (!errors.As(err, &re) || strings.HasPrefix(string(re), "READONLY")) {
+		s.mu.Lock()
+		if s.master == master {
+			s.master = "" // Looked up again on the next command
+		}
+		s.mu.Unlock()
+	}
+	return reply, err
+}
+
+// Asks the sentinels for the master's address, and checks that it is a master
+func (s *Store) findMaster() (string, error) {
+	sentinel := s.opts
+	sentinel.Username, sentinel.Password = "", s.opts.SentinelPassword
+	err := errors.New("redis: no sentinel knows master " + s.opts.MasterName)
+	for _, addr := range s.opts.Addrs {
+		p := &pool{addr: addr, opts: &sentinel}
+		reply, e := p.do("SENTINEL", "get-master-addr-by-name", s.opts.MasterName)
+		p.close()
+		hostPort, ok := reply.([]any)
+		if e != nil || !ok || len(hostPort) != 2 {
+			if e != nil {
+				err = e
+			}
+			continue
+		}
+		host, _ := hostPort[0].([]byte)
+		port, _ := hostPort[1].([]byte)
+		master := net.JoinHostPort(string(host), string(port))
+		role, e := s.pool(master, s.opts.DB).do("ROLE")
+		if fields, ok := role.([]any); e != nil || !ok || len(fields) == 0 || string(asBytes(fields[0])) != "master" {
+			err = errors.New("redis: " + master + " is not a master yet")
+			continue
+		}
+		s.mu.Lock()
+		s.master = master
+		s.mu.Unlock()
+		return master, nil
+	}
+	return "", err
+}
+
+// Returns a bulk or simple string reply's bytes
+func asBytes(v any) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
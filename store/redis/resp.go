@@ -0,0 +1,193 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error reply of a server, e.g. "MOVED 3999 10.0.0.7:6379"
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// Connection speaking RESP to one server
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// Dials the server, authenticating and selecting the database if set
+func dial(addr string, o *Options, db int) (*conn, error) {
+	d := &net.Dialer{Timeout: o.DialTimeout}
+	var nc net.Conn
+	var err error
+	if o.TLS != nil {
+		nc, err = tls.DialWithDialer(d, "tcp", addr, o.TLS)
+	} else {
+		nc, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if o.Password != "" {
+		args := []string{"AUTH", o.Password}
+		if o.Username != "" {
+			args = []string{"AUTH", o.Username, o.Password}
+		}
+		if _, err := c.do(o.Timeout, args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if db > 0 {
+		if _, err := c.do(o.Timeout, "SELECT", strconv.Itoa(db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Sends the commands in one write and returns the last one's reply
+// Error replies of the other commands are returned as errors
+func (c *conn) pipeline(timeout time.Duration, cmds ...[]string) (any, error) {
+	c.SetDeadline(time.Now().Add(timeout))
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	var reply any
+	for i := range cmds {
+		v, err := readReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := v.(redisError); ok && i < len(cmds)-1 {
+			return nil, e
+		}
+		reply = v
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Sends a command and returns its reply
+func (c *conn) do(timeout time.Duration, args ...string) (any, error) {
+	return c.pipeline(timeout, args)
+}
+
+// Reads a reply: a string, redisError, int64, []byte, []any or nil
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errors.New("redis: unknown reply type " + string(kind))
+}
+
+// Idle connections to one server
+type pool struct {
+	sync.Mutex
+	addr string
+	db   int
+	opts *Options
+	idle []*conn
+}
+
+// Sends the commands over a pooled connection and returns the last one's reply
+// Connections are only put back if the server answered, so broken ones are dropped
+func (p *pool) pipeline(cmds ...[]string) (any, error) {
+	p.Lock()
+	var c *conn
+	if n := len(p.idle); n > 0 {
+		c, p.idle = p.idle[n-1], p.idle[:n-1]
+	}
+	p.Unlock()
+	if c == nil {
+		var err error
+		if c, err = dial(p.addr, p.opts, p.db); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.pipeline(p.opts.Timeout, cmds...)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		c.Close()
+		return nil, err
+	}
+	p.Lock()
+	if len(p.idle) < p.opts.PoolSize {
+		p.idle = append(p.idle, c)
+		c = nil
+	}
+	p.Unlock()
+	if c != nil {
+		c.Close()
+	}
+	return reply, err
+}
+
+// Sends a command over a pooled connection and returns its reply
+func (p *pool) do(args ...string) (any, error) {
+	return p.pipeline(args)
+}
+
+// Closes the idle connections
+func (p *pool) close() {
+	p.Lock()
+	defer p.Unlock()
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,30 @@
+package golimiter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Context key under which ConnContext stores a connection's stream count
+type streamsKey struct{}
+
+// Hook for http.Server.ConnContext that lets the limiter count the requests
+// each connection has in flight, so that HTTP/2 (and h2c) clients multiplexing
+// many streams over one connection can be bounded by Streams.MaxPerConn
+func (l *Limiter) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, streamsKey{}, new(int32))
+}
+
+// Counts the request as a stream of its connection
+// Returns a func that must be called once the request is done, and whether
+// the connection was within Streams.MaxPerConn
+func (l *Limiter) acquireStream(r *http.Request) (release func(), ok bool) {
+	active, _ := r.Context().Value(streamsKey{}).(*int32)
+	if l.Streams.MaxPerConn <= 0 || active == nil {
+		return func() {}, true
+	}
+	n := atomic.AddInt32(active, 1)
+	return func() { atomic.AddInt32(active, -1) }, int(n) <= l.Streams.MaxPerConn
+}
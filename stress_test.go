@@ -0,0 +1,114 @@
+package golimiter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Stress tests meant to be run with -race: traffic through every entry point
+// while the limiter is reconfigured, its lists reloaded and it is stopped
+
+// Runs f in n goroutines until ctx is done
+func hammer(ctx context.Context, wg *sync.WaitGroup, n int, f func(i int)) {
+	for g := 0; g < n; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				f(g*1000000 + i)
+			}
+		}(g)
+	}
+}
+
+func TestStressRuntimeChanges(t *testing.T) {
+	dir := t.TempDir()
+	white, black := filepath.Join(dir, "whitelist"), filepath.Join(dir, "blacklist")
+	os.WriteFile(white, []byte("10.0.0.1\n"), 0644)
+	os.WriteFile(black, []byte("10.0.0.2\n"), 0644)
+	l := &Limiter{Rate: 100, Burst: 10}
+	l.Whitelist.Filename = white
+	l.Blacklist.Filename = black
+	l.Blacklist.On = true
+	l.ListReload.Watch = time.Millisecond
+	l.Plans = map[string]Plan{"pro": {Rate: 1000, Burst: 100}}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := l.InitContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	h := l.LimitHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	var wg sync.WaitGroup
+	// Traffic
+	hammer(ctx, &wg, 4, func(i int) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i%4, i%7)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	})
+	hammer(ctx, &wg, 2, func(i int) {
+		l.AllowKey(fmt.Sprint("key", i%16))
+		l.AllowN(fmt.Sprint("key", i%16), 2)
+		l.AllowBatch([]string{"a", "b", "a"})
+		l.Check(httptest.NewRequest("GET", "/", nil))
+	})
+	// Runtime changes
+	hammer(ctx, &wg, 1, func(i int) {
+		l.SetRate(rate.Limit(50 + i%50))
+		l.SetBurst(5 + i%10)
+		l.SetPlan("pro", Plan{Rate: rate.Limit(500 + i%500), Burst: 50})
+		l.SetMode([]Mode{Enforce, Shadow, Enforce, AllowAll}[i%4])
+		l.SetReplicaCount(1 + i%3)
+	})
+	hammer(ctx, &wg, 1, func(i int) {
+		l.SetWhitelist(i%2 == 0)
+		l.SetBlacklist(i%3 != 0)
+		l.SetOnEvent(func(e Event) {})
+		l.SetOnAllow(func(key string) {})
+		l.SetLevel("10.0.1.1", i%3)
+		l.Ban("10.0.3.3", time.Millisecond, "stress")
+	})
+	// List reloads
+	hammer(ctx, &wg, 1, func(i int) {
+		os.WriteFile(black, []byte(fmt.Sprintf("10.0.0.%d\n", 2+i%5)), 0644)
+		l.LoadList("whitelist", []byte(fmt.Sprintf("10.0.0.%d\n", 1+i%5)))
+		time.Sleep(time.Millisecond)
+	})
+	// Reads
+	hammer(ctx, &wg, 1, func(i int) {
+		l.Health()
+		l.Stats()
+		l.WhitelistEntries()
+		l.BlacklistEntries()
+	})
+	<-ctx.Done()
+	l.Stop()
+	wg.Wait()
+}
+
+func TestStressInitAndStop(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		l := &Limiter{Rate: 10, Burst: 5}
+		if err := l.Init(); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		var wg sync.WaitGroup
+		hammer(ctx, &wg, 4, func(i int) {
+			l.AllowKey(fmt.Sprint(i % 8))
+		})
+		time.Sleep(time.Millisecond)
+		l.Stop() // Traffic keeps flowing while the background processes stop
+		<-ctx.Done()
+		wg.Wait()
+		cancel()
+	}
+}
@@ -0,0 +1,101 @@
+package golimiter
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Hooks set at runtime, which replace the OnEvent and OnAllow fields once set
+// The fields are read without synchronization, so they may only be set before Init
+type hooks struct {
+	event atomic.Pointer[func(e Event)]
+	allow atomic.Pointer[func(key string)]
+}
+
+// Sets the hook called with noteworthy events, safe to call while the limiter is in use
+// Replaces OnEvent, which may only be set before Init
+func (l *Limiter) SetOnEvent(f func(e Event)) {
+	l.hooks.event.Store(&f)
+}
+
+// Returns the hook events are currently reported to, for chaining in SetOnEvent
+func (l *Limiter) EventHook() func(e Event) {
+	if f := l.hooks.event.Load(); f != nil {
+		return *f
+	}
+	return l.OnEvent
+}
+
+// Sets the hook called with the visitor key after each allowed event, safe to
+// call while the limiter is in use
+// Replaces OnAllow, which may only be set before Init
+func (l *Limiter) SetOnAllow(f func(key string)) {
+	l.hooks.allow.Store(&f)
+}
+
+// Returns the hook currently called after each allowed event
+func (l *Limiter) AllowHook() func(key string) {
+	if f := l.hooks.allow.Load(); f != nil {
+		return *f
+	}
+	return l.OnAllow
+}
+
+// Switches the whitelist on or off, safe to call while the limiter is in use
+// Switching it on loads the list first, and starts its reloads if Init didn't
+func (l *Limiter) SetWhitelist(on bool) error {
+	return l.switchList("whitelist", on)
+}
+
+// Switches the blacklist on or off, safe to call while the limiter is in use
+// Switching it on loads the list first, and starts its reloads if Init didn't
+func (l *Limiter) SetBlacklist(on bool) error {
+	return l.switchList("blacklist", on)
+}
+
+// Switches the named list on or off
+func (l *Limiter) switchList(name string, on bool) error {
+	enabled, rl, freq, update := &l.Whitelist.enabled, &l.Whitelist.reload, &l.Whitelist.UpdateFreq, l.updateWhitelist
+	if name == "blacklist" {
+		enabled, rl, freq, update = &l.Blacklist.enabled, &l.Blacklist.reload, &l.Blacklist.UpdateFreq, l.updateBlacklist
+	}
+	if !on {
+		enabled.Store(false)
+		return nil
+	}
+	_, filename, format, _ := l.listOf(name)
+	if filename == "" {
+		return fmt.Errorf("%s file path is not set", name)
+	}
+	l.Lock()
+	running := rl.running
+	l.Unlock()
+	if !running {
+		loaded, err := l.loadList(name, filename, format)
+		if err != nil {
+			return err
+		}
+		l.replaceList(name, loaded)
+		l.Lock()
+		if !rl.running && l.ctx != nil {
+			if *freq == 0 {
+				*freq = 3 // Use default freq if none provided
+			}
+			rl.running = true
+			go update(l.ctx)
+		}
+		l.Unlock()
+	}
+	enabled.Store(true)
+	return nil
+}
+
+// Returns whether the whitelist is on
+func (l *Limiter) whitelistOn() bool {
+	return l.Whitelist.enabled.Load()
+}
+
+// Returns whether the blacklist is on
+func (l *Limiter) blacklistOn() bool {
+	return l.Blacklist.enabled.Load()
+}
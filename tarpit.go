@@ -0,0 +1,28 @@
+package golimiter
+
+import (
+	"context"
+	"time"
+)
+
+// Holds a denied request or connection for the tarpit delay, raising the cost
+// of scraping and bruteforcing for the client
+// Returns immediately if tarpitting is off or the maximum number of denials
+// are already being held, so attackers can't exhaust the server's resources
+func (l *Limiter) tarpit(ctx context.Context) {
+	if !l.Tarpit.On || l.Tarpit.slots == nil {
+		return
+	}
+	select {
+	case l.Tarpit.slots <- struct{}{}:
+	default:
+		return
+	}
+	defer func() { <-l.Tarpit.slots }()
+	t := time.NewTimer(l.Tarpit.Delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
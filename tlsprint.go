@@ -0,0 +1,44 @@
+package golimiter
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// Returns the TLS fingerprint (e.g. JA3 or JA4) of a connection's client,
+// or "" if it is unknown
+type ConnFingerprint func(conn net.Conn) string
+
+// Returns the key a connection is limited under and whether its client's
+// fingerprint is blocked
+// Connections are keyed by ip unless Fingerprint.Key is set
+func (l *Limiter) connKey(conn net.Conn, ip string) (key string, blocked bool) {
+	if l.Fingerprint.Func == nil {
+		return ip, false
+	}
+	fp := l.Fingerprint.Func(conn)
+	if fp == "" {
+		return ip, false
+	}
+	if l.botPrints[fp] {
+		return ip, true
+	}
+	if !l.Fingerprint.Key {
+		return ip, false
+	}
+	return l.hintedKey(ip, fp), false
+}
+
+// Completes the handshake of TLS connections if Fingerprint.Handshake is set,
+// so the fingerprint captured during it is available before the connection is limited
+// Returns false if the handshake failed
+func (l *Limiter) handshake(conn net.Conn) bool {
+	tc, ok := conn.(*tls.Conn)
+	if !ok || l.Fingerprint.Handshake <= 0 {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.Fingerprint.Handshake)
+	defer cancel()
+	return tc.HandshakeContext(ctx) == nil
+}
@@ -0,0 +1,128 @@
+package golimiter
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Returned by a Transport that does not wait for outgoing requests over their limit
+var ErrOutboundLimited = errors.New("outbound request over its rate limit")
+
+// Returns the request's host as the key, limiting outgoing requests per host
+func HostKey(r *http.Request) string {
+	return r.URL.Host
+}
+
+// Transport is an http.RoundTripper throttling outgoing requests with the
+// limiter, so that clients stay within third-party API limits
+type Transport struct {
+	Limiter *Limiter
+	Key     func(r *http.Request) string // Key an outgoing request is limited under (default HostKey)
+	Next    http.RoundTripper            // Transport the allowed requests are sent with (default http.DefaultTransport)
+	// Fail requests over their limit with ErrOutboundLimited instead of
+	// waiting until they are allowed (or their context is done)
+	NoWait bool
+	// Don't hold back a key after the upstream answers 429 or 503 with a Retry-After
+	IgnoreRetryAfter bool
+	mu               sync.Mutex
+	backoff          map[string]time.Time // Times until which keys are held back, from upstream Retry-After headers
+}
+
+// Wraps the transport so that outgoing requests are limited under their key
+// Requests wait until they are allowed unless NoWait is set on the returned Transport
+func (l *Limiter) LimitTransport(key func(r *http.Request) string, next http.RoundTripper) *Transport {
+	return &Transport{Limiter: l, Key: key, Next: next}
+}
+
+// Implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	keyFunc, next := t.Key, t.Next
+	if keyFunc == nil {
+		keyFunc = HostKey
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	key := keyFunc(r)
+	if err := t.waitBackoff(r, key); err != nil {
+		return nil, err
+	}
+	if t.NoWait {
+		if !t.Limiter.AllowKey(key) {
+			return nil, ErrOutboundLimited
+		}
+	} else if err := t.Limiter.WaitKey(r.Context(), key); err != nil {
+		return nil, err
+	}
+	resp, err := next.RoundTrip(r)
+	if err == nil && !t.IgnoreRetryAfter {
+		t.observe(key, resp)
+	}
+	return resp, err
+}
+
+// Holds back requests for a key the upstream has asked to back off,
+// failing them instead if NoWait is set
+func (t *Transport) waitBackoff(r *http.Request, key string) error {
+	t.mu.Lock()
+	until, ok := t.backoff[key]
+	if ok && !time.Now().Before(until) {
+		delete(t.backoff, key)
+		ok = false
+	}
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if t.NoWait {
+		return ErrOutboundLimited
+	}
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-r.Context().Done():
+		return r.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Records the Retry-After of a 429 or 503 response, holding back the key until then
+func (t *Transport) observe(key string, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.backoff == nil {
+		t.backoff = make(map[string]time.Time)
+	}
+	if until.After(t.backoff[key]) {
+		t.backoff[key] = until
+	}
+}
+
+// Parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return time.Time{}, false
+		}
+		return now.Add(time.Duration(secs) * time.Second), true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil || !t.After(now) {
+		return time.Time{}, false
+	}
+	return t, true
+}
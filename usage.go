@@ -0,0 +1,191 @@
+package golimiter
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Usage of an API key over a window, for billing
+// Usage is kept per limiter instance, in whole minutes
+type UsageReport struct {
+	Key       string    `json:"key"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Allowed   int64     `json:"allowed"`    // Events allowed in the window
+	Denied    int64     `json:"denied"`     // Events denied by the key's rate or quota in the window
+	Quota     int64     `json:"quota"`      // Events allowed per quota period (0- no quota)
+	QuotaUsed int64     `json:"quota_used"` // Events counted against the quota in the current period
+}
+
+// Events of a key per minute, oldest first
+type usageLog []usageSlot
+
+// Events of a key in one minute
+type usageSlot struct {
+	minute  int64 // Unix minute
+	allowed int64
+	denied  int64
+}
+
+// Counts an event of the key in the current minute, dropping the minutes
+// older than the registry's Retention
+// Must be called while holding the lock
+func (kr *KeyRegistry) recordUsage(k *apiKey, now time.Time, allowed bool) {
+	minute := now.Unix() / 60
+	if n := len(k.usage); n == 0 || k.usage[n-1].minute != minute {
+		k.usage = append(k.usage, usageSlot{minute: minute})
+		retention := kr.Retention
+		if retention == 0 {
+			retention = 24 * time.Hour // Use default retention if none provided
+		}
+		oldest, drop := minute-int64(retention/time.Minute), 0
+		for drop < len(k.usage) && k.usage[drop].minute <= oldest {
+			drop++
+		}
+		k.usage = k.usage[drop:]
+	}
+	slot := &k.usage[len(k.usage)-1]
+	if allowed {
+		slot.allowed++
+	} else {
+		slot.denied++
+	}
+}
+
+// A key's usage summed under the lock, with the quota counter left to read
+// from the store after releasing it
+type pendingUsage struct {
+	rep     UsageReport
+	counter string        // Store key of the quota counter ("" if not read from the store)
+	period  time.Duration // Quota period the counter expires after
+}
+
+// Returns the key's usage over the window up to now, in whole minutes
+// Unknown keys, and windows past the registry's Retention, report what is kept
+func (kr *KeyRegistry) Usage(key string, window time.Duration) UsageReport {
+	now := time.Now()
+	return kr.usageOf([]string{key}, now.Add(-window), now)[0]
+}
+
+// Returns the usage of every registered key over the window up to now, sorted by key
+func (kr *KeyRegistry) UsageAll(window time.Duration) []UsageReport {
+	now := time.Now()
+	return kr.usageOf(kr.Keys(), now.Add(-window), now)
+}
+
+// Returns the usage of the keys in the minutes from from up to to
+// Quota counters are read from the store after releasing the lock, so a slow
+// store doesn't hold up the requests of every key
+func (kr *KeyRegistry) usageOf(keys []string, from, to time.Time) []UsageReport {
+	pending := make([]pendingUsage, len(keys))
+	kr.RLock()
+	for i, key := range keys {
+		pending[i] = kr.usage(key, from, to)
+	}
+	kr.RUnlock()
+	reports := make([]UsageReport, len(keys))
+	for i, p := range pending {
+		reports[i] = p.rep
+		if p.counter == "" {
+			continue
+		}
+		if used, err := kr.Store.Incr(p.counter, 0, p.period); err == nil { // The store's counter is the source of truth
+			reports[i].QuotaUsed = used
+		}
+	}
+	return reports
+}
+
+// Sums the key's usage in the minutes from from up to to
+// Must be called while holding the lock
+func (kr *KeyRegistry) usage(key string, from, to time.Time) pendingUsage {
+	rep := UsageReport{Key: key, From: from, To: to}
+	k, exists := kr.keys[key]
+	if !exists {
+		return pendingUsage{rep: rep}
+	}
+	first, last := from.Unix()/60, to.Unix()/60
+	for _, s := range k.usage {
+		if s.minute >= first && s.minute <= last {
+			rep.Allowed += s.allowed
+			rep.Denied += s.denied
+		}
+	}
+	rep.Quota = k.limits.Quota
+	if k.limits.Quota <= 0 {
+		return pendingUsage{rep: rep}
+	}
+	rep.QuotaUsed = k.used
+	if kr.Store == nil {
+		return pendingUsage{rep: rep}
+	}
+	period := k.limits.QuotaPeriod
+	idx := to.UnixNano() / int64(period)
+	return pendingUsage{rep: rep, counter: "quota:" + key + ":" + strconv.FormatInt(idx, 10), period: period}
+}
+
+// Receives the usage of every registered key, e.g. to send it to a billing system
+type UsageExporter func(reports []UsageReport) error
+
+// Passes the usage of every registered key over each interval to export,
+// every interval, until the context is done or export returns an error
+// Run it in a goroutine; the context's error or export's error is returned
+func (kr *KeyRegistry) ExportUsage(ctx context.Context, every time.Duration, export UsageExporter) error {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	from := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			reports := kr.usageOf(kr.Keys(), from, now)
+			from = now
+			if err := export(reports); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Returns an exporter writing the reports as CSV rows to w, with a header row first
+func CSVUsage(w io.Writer) UsageExporter {
+	cw := csv.NewWriter(w)
+	header := false
+	return func(reports []UsageReport) error {
+		if !header {
+			cw.Write([]string{"key", "from", "to", "allowed", "denied", "quota", "quota_used"})
+			header = true
+		}
+		for _, rep := range reports {
+			cw.Write([]string{
+				rep.Key,
+				rep.From.UTC().Format(time.RFC3339),
+				rep.To.UTC().Format(time.RFC3339),
+				strconv.FormatInt(rep.Allowed, 10),
+				strconv.FormatInt(rep.Denied, 10),
+				strconv.FormatInt(rep.Quota, 10),
+				strconv.FormatInt(rep.QuotaUsed, 10),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+}
+
+// Returns an exporter writing each report as a line of JSON to w
+func JSONUsage(w io.Writer) UsageExporter {
+	enc := json.NewEncoder(w)
+	return func(reports []UsageReport) error {
+		for _, rep := range reports {
+			if err := enc.Encode(rep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
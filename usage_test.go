@@ -0,0 +1,47 @@
+package golimiter
+
+import (
+	"testing"
+	"time"
+)
+
+// Store whose counters report n once release is closed, signalling each call on calls
+type heldStore struct {
+	n       int64
+	calls   chan struct{}
+	release chan struct{}
+}
+
+func (s *heldStore) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	s.calls <- struct{}{}
+	<-s.release
+	return s.n, nil
+}
+
+func TestUsageQueriesStoreOutsideLock(t *testing.T) {
+	store := &heldStore{n: 7, calls: make(chan struct{}, 1), release: make(chan struct{})}
+	kr := NewKeyRegistry()
+	kr.Store = store
+	if err := kr.Add("acct", KeyLimits{Rate: 1, Burst: 1, Quota: 100}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan UsageReport, 1)
+	go func() { done <- kr.Usage("acct", time.Hour) }()
+	<-store.calls
+	// The store query is in flight; the registry must stay usable meanwhile
+	locked := make(chan struct{})
+	go func() {
+		kr.Lock()
+		kr.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("registry locked while querying the store")
+	}
+	close(store.release)
+	if rep := <-done; rep.Quota != 100 || rep.QuotaUsed != 7 {
+		t.Fatalf("got %+v, want the store's quota count", rep)
+	}
+}
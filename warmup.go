@@ -0,0 +1,63 @@
+package golimiter
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Returns the fraction of the rates and bursts allowed while warming up, 1 once warm
+// Must be called while holding the lock
+func (l *Limiter) warmth(now time.Time) float64 {
+	if l.warmFrom.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(l.warmFrom)
+	if elapsed >= l.WarmUp.Window {
+		return 1
+	}
+	from := l.WarmUp.From
+	if from == 0 {
+		from = 0.1 // Use default fraction if none provided
+	}
+	return from + (1-from)*float64(elapsed)/float64(l.WarmUp.Window)
+}
+
+// Returns the rate and burst reduced to the fraction, keeping a burst of at least one
+func warm(r rate.Limit, b int, f float64) (rate.Limit, int) {
+	if f >= 1 || r == rate.Inf {
+		return r, b
+	}
+	wb := int(math.Ceil(float64(b) * f))
+	if wb < 1 && b > 0 {
+		wb = 1
+	}
+	return r * rate.Limit(f), wb
+}
+
+// Starts warming up again, if the limiter has just recovered from a load state
+// and ends the warm-up once its window has passed, restoring every visitor's limits
+// Must be called while holding the lock
+func (l *Limiter) updateWarmUp(now time.Time, recovered bool) {
+	if recovered && l.WarmUp.AfterRecovery && l.WarmUp.Window > 0 {
+		l.warmFrom = now
+	}
+	if l.warmFrom.IsZero() || now.Sub(l.warmFrom) < l.WarmUp.Window {
+		return
+	}
+	l.warmFrom = time.Time{}
+	for _, v := range l.visitors {
+		l.applyWarmUp(v, now, true)
+	}
+}
+
+// Gives the visitor's limiters the rates and bursts of the warm-up's progress
+// Visitors are only updated while warming up, unless ended is set
+// Must be called while holding the lock
+func (l *Limiter) applyWarmUp(v *visitor, now time.Time, ended bool) {
+	if l.warmFrom.IsZero() && !ended || v.fixed != nil {
+		return
+	}
+	l.applyParams(v, now)
+}
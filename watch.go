@@ -0,0 +1,92 @@
+package golimiter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of a local list file: the file its path resolves to through
+// symlinks (e.g. a mounted ConfigMap's ..data link), and its stat
+type fileVersion struct {
+	path string
+	info os.FileInfo // nil if the file is missing
+}
+
+// Returns the current version of the file
+func statVersion(filename string) fileVersion {
+	path, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return fileVersion{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{path: path}
+	}
+	return fileVersion{path: path, info: info}
+}
+
+// Reports whether the versions are of the same file, unchanged
+// A file replaced by a rename or a symlink swap is another file, even if
+// its size and modification time happen to match
+func (v fileVersion) same(o fileVersion) bool {
+	if v.info == nil || o.info == nil {
+		return v.path == o.path && v.info == nil && o.info == nil
+	}
+	return v.path == o.path && os.SameFile(v.info, o.info) &&
+		v.info.Size() == o.info.Size() && v.info.ModTime().Equal(o.info.ModTime())
+}
+
+// Resolves a local list file's path through symlinks, so it and its detached
+// signature are read from the same version of a mounted ConfigMap or Secret
+// Remote and unresolvable locations are returned as they are
+func resolveList(filename string) string {
+	if isRemote(filename) {
+		return filename
+	}
+	if path, err := filepath.EvalSymlinks(filename); err == nil {
+		return path
+	}
+	return filename
+}
+
+// Returns the path whose ".sig" holds the detached signature of the list read
+// from path: path itself if the signature is next to it (as in a mounted
+// ConfigMap's version directory), else the list's filename
+func signedPath(filename, path string) string {
+	if path != filename {
+		if _, err := os.Stat(path + ".sig"); err == nil {
+			return path
+		}
+	}
+	return filename
+}
+
+// Waits for the next reload of a list file: the next tick or, if
+// ListReload.Watch is set, a change of the local file from the version last
+// loaded, once it has held for a check so files written in place aren't read half way
+// Returns false once the context is done
+func (l *Limiter) nextReload(ctx context.Context, tick <-chan time.Time, filename string, last fileVersion) bool {
+	var watch <-chan time.Time
+	if l.ListReload.Watch > 0 && !isRemote(filename) {
+		t := time.NewTicker(l.ListReload.Watch)
+		defer t.Stop()
+		watch = t.C
+	}
+	pending := last
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-tick:
+			return true
+		case <-watch:
+			v := statVersion(filename)
+			if !v.same(last) && v.same(pending) {
+				return true
+			}
+			pending = v
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package golimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// A limit of events per window (e.g. 300 per minute), enforced together with
+// a visitor's rate and burst and any other windows; see Limiter.Windows
+// Windows are token buckets refilling Limit tokens evenly over Per, so
+// "5000 per day" allows 5000 events at once and then one every 17.28 seconds
+type WindowLimit struct {
+	Limit int           // Events allowed per window
+	Per   time.Duration // Length of the window
+}
+
+// Returns the windows enforced for the visitor: its plan's if it has one,
+// the default Windows otherwise
+// Must be called while holding the lock
+func (l *Limiter) visitorWindows(v *visitor) []WindowLimit {
+	plans := l.Plans
+	if v.ns != nil {
+		plans = v.ns.plans
+	}
+	if p, ok := plans[v.plan]; ok && v.plan != "" {
+		return p.Windows
+	}
+	return l.Windows
+}
+
+// Returns a limiter for each of the windows, divided by the replica count
+// Must be called while holding the lock
+func (l *Limiter) windowLimiters(windows []WindowLimit) []*rate.Limiter {
+	if len(windows) == 0 {
+		return nil
+	}
+	n := l.Replicas.Count
+	if n < 1 {
+		n = 1
+	}
+	lims := make([]*rate.Limiter, len(windows))
+	for i, w := range windows {
+		r := rate.Limit(float64(w.Limit) / w.Per.Seconds() / float64(n))
+		lims[i] = rate.NewLimiter(r, (w.Limit+n-1)/n)
+	}
+	return lims
+}
+
+// Takes n events from each of the visitor's windows at now, if all of them allow it
+// Returns the reservations to cancel (at the same now) should the visitor's own
+// limit deny the events, or else the decision denying them with the longest wait
+// among the windows, so Retry-After reflects the most restrictive window
+func reserveWindows(v *visitor, n int, now time.Time) ([]*rate.Reservation, Decision, bool) {
+	if len(v.windows) == 0 {
+		return nil, Decision{}, true
+	}
+	held := make([]*rate.Reservation, 0, len(v.windows))
+	var wait time.Duration
+	for _, lim := range v.windows {
+		res := lim.ReserveN(now, n)
+		if !res.OK() { // n exceeds the window's limit; it can never be allowed
+			cancelWindows(held, now)
+			return nil, Decision{RetryAfter: refillTime(lim)}, false
+		}
+		held = append(held, res)
+		if delay := res.DelayFrom(now); delay > wait {
+			wait = delay
+		}
+	}
+	if wait > 0 {
+		cancelWindows(held, now)
+		return nil, Decision{RetryAfter: wait}, false
+	}
+	return held, Decision{}, true
+}
+
+// Returns the time an empty window takes to refill
+func refillTime(lim *rate.Limiter) time.Duration {
+	return time.Duration(float64(lim.Burst()) / float64(lim.Limit()) * float64(time.Second))
+}
+
+// Gives back the events taken from the windows; now must be the time they were taken at
+func cancelWindows(held []*rate.Reservation, now time.Time) {
+	for _, res := range held {
+		res.CancelAt(now)
+	}
+}
+
+// Lowers the decision's remaining events to those left in the most exhausted window
+func windowsRemaining(v *visitor, d Decision) Decision {
+	for _, lim := range v.windows {
+		if left := int(lim.Tokens()); left < d.Remaining {
+			d.Remaining = left
+		}
+	}
+	return d
+}
+
+// Reserves n events from each of the limiters at now, for callers that wait
+// Returns the reservations and the longest of their delays, or an error if n
+// exceeds a limiter's burst, in which case nothing is taken
+func reserveAll(lims []*rate.Limiter, n int, now time.Time) ([]*rate.Reservation, time.Duration, error) {
+	held := make([]*rate.Reservation, 0, len(lims))
+	var wait time.Duration
+	for _, lim := range lims {
+		res := lim.ReserveN(now, n)
+		if !res.OK() {
+			cancelWindows(held, now)
+			return nil, 0, fmt.Errorf("%d events exceed the limit of %d", n, lim.Burst())
+		}
+		held = append(held, res)
+		if delay := res.DelayFrom(now); delay > wait {
+			wait = delay
+		}
+	}
+	return held, wait, nil
+}
+
+// Waits out the reservations' delay, giving them back if the context is done
+// first or its deadline falls before the end of the delay
+func waitReserved(ctx context.Context, held []*rate.Reservation, wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+	if dl, ok := ctx.Deadline(); ok && dl.Before(time.Now().Add(wait)) {
+		for _, res := range held {
+			res.Cancel()
+		}
+		return context.DeadlineExceeded
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		for _, res := range held {
+			res.Cancel()
+		}
+		return ctx.Err()
+	}
+}